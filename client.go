@@ -20,7 +20,12 @@ import (
 )
 
 // ErrBadHandshake is returned when the server response to opening handshake is
-// invalid.
+// invalid. Dialers return a *BadHandshakeError matching ErrBadHandshake with
+// errors.Is.
+//
+// Dialers returned ErrBadHandshake itself in earlier versions. Comparisons
+// such as err == websocket.ErrBadHandshake no longer match and must be
+// replaced with errors.Is(err, websocket.ErrBadHandshake).
 var ErrBadHandshake = errors.New("websocket: bad handshake")
 
 var errInvalidCompression = errors.New("websocket: invalid compression negotiation")
@@ -31,9 +36,9 @@ var errInvalidCompression = errors.New("websocket: invalid compression negotiati
 // (Cookie). Use the response.Header to get the selected subprotocol
 // (Sec-WebSocket-Protocol) and cookies (Set-Cookie).
 //
-// If the WebSocket handshake fails, ErrBadHandshake is returned along with a
-// non-nil *http.Response so that callers can handle redirects, authentication,
-// etc.
+// If the WebSocket handshake fails, a *BadHandshakeError is returned along
+// with a non-nil *http.Response so that callers can handle redirects,
+// authentication, etc.
 //
 // Deprecated: Use Dialer instead.
 func NewClient(netConn net.Conn, u *url.URL, requestHeader http.Header, readBufSize, writeBufSize int) (c *Conn, response *http.Response, err error) {
@@ -101,14 +106,61 @@ type Dialer struct {
 
 	// EnableCompression specifies if the client should attempt to negotiate
 	// per message compression (RFC 7692). Setting this value to true does not
-	// guarantee that compression will be supported. Currently only "no context
-	// takeover" modes are supported.
+	// guarantee that compression will be supported.
 	EnableCompression bool
 
+	// EnableContextTakeover specifies if the client should offer to retain
+	// the compression sliding window across messages. The server decides if
+	// context takeover is used in each direction. This field is ignored if
+	// EnableCompression is false.
+	EnableContextTakeover bool
+
+	// Extensions specifies the extensions offered by the client in addition
+	// to permessage-deflate, in order of preference.
+	Extensions []Extension
+
+	// KeepAlive configures the keepalive of dialed connections. The
+	// keepalive is disabled if KeepAlive.Interval is zero.
+	KeepAlive KeepAlive
+
+	// Interceptors specifies the interceptors of dialed connections. See
+	// the Interceptor type for details.
+	Interceptors []Interceptor
+
+	// InterceptControl specifies whether the interceptors are called for
+	// control messages. If InterceptControl is false, then the interceptors
+	// are called for text and binary messages only.
+	InterceptControl bool
+
+	// Codecs maps subprotocols to the codecs of WriteValue and ReadValue on
+	// dialed connections. Connections use JSONCodec when the negotiated
+	// subprotocol is not in Codecs.
+	Codecs map[string]Codec
+
+	// Observer, if not nil, receives the events of dialed connections and
+	// the handshake errors of the dialer.
+	Observer Observer
+
 	// Jar specifies the cookie jar.
 	// If Jar is nil, cookies are not sent in requests and ignored
 	// in responses.
 	Jar http.CookieJar
+
+	// MaxRedirects specifies the maximum number of redirect responses
+	// followed by the dialer. If MaxRedirects is zero, redirects are not
+	// followed and the redirect response is returned as a handshake error.
+	MaxRedirects int
+
+	// HTTP2Transport, if not nil, specifies the transport used to open
+	// connections on HTTP/2 streams with the extended CONNECT method (RFC
+	// 8441). The transport must support extended CONNECT, such as the
+	// Transport type of golang.org/x/net/http2 v0.35.0 or later. Connections
+	// dialed with the same transport share its HTTP/2 connections.
+	//
+	// When HTTP2Transport is set, the NetDial, NetDialContext,
+	// NetDialTLSContext, Proxy and TLSClientConfig fields are ignored; the
+	// transport dials the network connections.
+	HTTP2Transport http.RoundTripper
 }
 
 // Dial creates a new client connection by calling DialContext with a background context.
@@ -152,15 +204,45 @@ var nilDialer = *DefaultDialer
 //
 // The context will be used in the request and in the Dialer.
 //
-// If the WebSocket handshake fails, ErrBadHandshake is returned along with a
-// non-nil *http.Response so that callers can handle redirects, authentication,
-// etcetera. The response body may not contain the entire response and does not
-// need to be closed by the application.
+// If the WebSocket handshake fails, a *BadHandshakeError is returned along
+// with a non-nil *http.Response so that callers can handle redirects,
+// authentication, etcetera. The response body may not contain the entire
+// response and does not need to be closed by the application.
+//
+// The dialer follows up to MaxRedirects redirect responses with a Location
+// header. The http and https schemes of the location are mapped to ws and
+// wss. A redirect from wss to ws is not followed. The Authorization and
+// Cookie headers of requestHeader are not sent to another host. A redirect
+// response that is not followed is returned as a *BadHandshakeError.
 func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
 	if d == nil {
 		d = &nilDialer
 	}
 
+	for redirects := 0; ; redirects++ {
+		conn, resp, err := d.dial(ctx, urlStr, requestHeader)
+		location, ok := redirectLocation(err)
+		if ok && redirects < d.MaxRedirects {
+			next, header, rerr := redirectRequest(urlStr, location, requestHeader)
+			if rerr == nil {
+				urlStr, requestHeader = next, header
+				continue
+			}
+			err = redirectError(err, rerr)
+		} else if ok && d.MaxRedirects > 0 {
+			err = redirectError(err, ErrTooManyRedirects)
+		}
+		observeBadHandshake(d.Observer, err)
+		return conn, resp, err
+	}
+}
+
+// dial performs a single handshake.
+func (d *Dialer) dial(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	if d.HTTP2Transport != nil {
+		return d.dialHTTP2(ctx, urlStr, requestHeader)
+	}
+
 	challengeKey, err := generateChallengeKey()
 	if err != nil {
 		return nil, nil, err
@@ -234,8 +316,9 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 		}
 	}
 
-	if d.EnableCompression {
-		req.Header["Sec-WebSocket-Extensions"] = []string{"permessage-deflate; server_no_context_takeover; client_no_context_takeover"}
+	extensions := withCompression(d.EnableCompression, d.EnableContextTakeover, d.Extensions)
+	if len(extensions) > 0 {
+		req.Header["Sec-WebSocket-Extensions"] = []string{offerExtensions(extensions)}
 	}
 
 	if d.HandshakeTimeout != 0 {
@@ -374,35 +457,46 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 		}
 	}
 
-	if resp.StatusCode != 101 ||
-		!tokenListContainsValue(resp.Header, "Upgrade", "websocket") ||
-		!tokenListContainsValue(resp.Header, "Connection", "upgrade") ||
-		resp.Header.Get("Sec-Websocket-Accept") != computeAcceptKey(challengeKey) {
+	wsTrace := ContextClientTrace(ctx)
+	if wsTrace != nil && wsTrace.HandshakeResponse != nil {
+		wsTrace.HandshakeResponse(resp.StatusCode, resp.Header)
+	}
+	err = checkHandshakeResponse(resp.StatusCode,
+		tokenListContainsValue(resp.Header, "Upgrade", "websocket"),
+		tokenListContainsValue(resp.Header, "Connection", "upgrade"),
+		resp.Header.Get("Sec-Websocket-Accept"), challengeKey)
+	if wsTrace != nil && wsTrace.HandshakeValidated != nil {
+		wsTrace.HandshakeValidated(err)
+	}
+	if err != nil {
 		// Before closing the network connection on return from this
 		// function, slurp up some of the response to aid application
 		// debugging.
-		buf := make([]byte, 1024)
+		buf := make([]byte, maxHandshakeErrorBody)
 		n, _ := io.ReadFull(resp.Body, buf)
 		resp.Body = io.NopCloser(bytes.NewReader(buf[:n]))
-		return nil, resp, ErrBadHandshake
+		return nil, resp, &BadHandshakeError{Cause: err, StatusCode: resp.StatusCode, Header: resp.Header, Body: buf[:n]}
 	}
 
-	for _, ext := range parseExtensions(resp.Header) {
-		if ext[""] != "permessage-deflate" {
-			continue
-		}
-		_, snct := ext["server_no_context_takeover"]
-		_, cnct := ext["client_no_context_takeover"]
-		if !snct || !cnct {
-			return nil, resp, errInvalidCompression
-		}
-		conn.newCompressionWriter = compressNoContextTakeover
-		conn.newDecompressionReader = decompressNoContextTakeover
-		break
+	negotiated, err := configureExtensions(parseExtensions(resp.Header), extensions)
+	if wsTrace != nil && wsTrace.ExtensionsNegotiated != nil {
+		wsTrace.ExtensionsNegotiated(strings.Join(resp.Header.Values("Sec-Websocket-Extensions"), ", "), err)
+	}
+	if err != nil {
+		return nil, resp, &BadHandshakeError{Cause: err, StatusCode: resp.StatusCode, Header: resp.Header}
 	}
+	conn.setExtensions(negotiated)
+	conn.setInterceptors(d.Interceptors, d.InterceptControl)
+	conn.codecs = d.Codecs
 
 	resp.Body = io.NopCloser(bytes.NewReader([]byte{}))
 	conn.subprotocol = resp.Header.Get("Sec-Websocket-Protocol")
+	if wsTrace != nil {
+		if wsTrace.SubprotocolSelected != nil {
+			wsTrace.SubprotocolSelected(conn.subprotocol)
+		}
+		conn.setTrace(wsTrace.ConnTrace)
+	}
 
 	if err := netConn.SetDeadline(time.Time{}); err != nil {
 		return nil, resp, err
@@ -412,6 +506,9 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 	// closing the network connection.
 	netConn = nil
 
+	conn.startObserving(d.Observer)
+	conn.StartKeepAlive(d.KeepAlive)
+
 	return conn, resp, nil
 }
 
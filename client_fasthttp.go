@@ -0,0 +1,357 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/savsgio/gotils/strconv"
+	"github.com/valyala/fasthttp"
+)
+
+// FastHTTPDialer contains options for connecting to WebSocket server. The
+// handshake is performed with fasthttp request and response types.
+//
+// FastHTTPDialer does not support proxies and cookie jars like the Proxy and
+// Jar fields of Dialer. Cookies can be set in the request header.
+//
+// It is safe to call FastHTTPDialer's methods concurrently.
+type FastHTTPDialer struct {
+	// NetDialContext specifies the dial function for creating TCP connections. If
+	// NetDialContext is nil, net.Dialer DialContext is used.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// NetDialTLSContext specifies the dial function for creating TLS/TCP connections. If
+	// NetDialTLSContext is nil, NetDialContext is used.
+	// If NetDialTLSContext is set, Dial assumes the TLS handshake is done there and
+	// TLSClientConfig is ignored.
+	NetDialTLSContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSClientConfig specifies the TLS configuration to use with tls.Client.
+	// If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout specifies the duration for the handshake to complete.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize specify I/O buffer sizes in bytes. If a buffer
+	// size is zero, then a useful default size is used. The I/O buffer sizes
+	// do not limit the size of the messages that can be sent or received.
+	ReadBufferSize, WriteBufferSize int
+
+	// WriteBufferPool is a pool of buffers for write operations. If the value
+	// is not set, then write buffers are allocated to the connection for the
+	// lifetime of the connection.
+	WriteBufferPool BufferPool
+
+	// Subprotocols specifies the client's requested subprotocols.
+	Subprotocols []string
+
+	// EnableCompression specifies if the client should attempt to negotiate
+	// per message compression (RFC 7692).
+	EnableCompression bool
+
+	// EnableContextTakeover specifies if the client should offer to retain
+	// the compression sliding window across messages. This field is ignored
+	// if EnableCompression is false.
+	EnableContextTakeover bool
+
+	// Extensions specifies the extensions offered by the client in addition
+	// to permessage-deflate, in order of preference.
+	Extensions []Extension
+
+	// KeepAlive configures the keepalive of dialed connections. The
+	// keepalive is disabled if KeepAlive.Interval is zero.
+	KeepAlive KeepAlive
+
+	// Interceptors specifies the interceptors of dialed connections. See
+	// the Interceptor type for details.
+	Interceptors []Interceptor
+
+	// InterceptControl specifies whether the interceptors are called for
+	// control messages. If InterceptControl is false, then the interceptors
+	// are called for text and binary messages only.
+	InterceptControl bool
+
+	// Codecs maps subprotocols to the codecs of WriteValue and ReadValue on
+	// dialed connections. Connections use JSONCodec when the negotiated
+	// subprotocol is not in Codecs.
+	Codecs map[string]Codec
+
+	// Observer, if not nil, receives the events of dialed connections and
+	// the handshake errors of the dialer.
+	Observer Observer
+
+	// MaxRedirects specifies the maximum number of redirect responses
+	// followed by the dialer. If MaxRedirects is zero, redirects are not
+	// followed and the redirect response is returned as a handshake error.
+	MaxRedirects int
+}
+
+// Dial creates a new client connection by calling DialContext with a background context.
+func (d *FastHTTPDialer) Dial(urlStr string, requestHeader *fasthttp.RequestHeader) (*Conn, *fasthttp.Response, error) {
+	return d.DialContext(context.Background(), urlStr, requestHeader)
+}
+
+// DialContext creates a new client connection. Use requestHeader to specify the
+// origin (Origin), subprotocols (Sec-WebSocket-Protocol) and cookies (Cookie).
+// Use the response.Header to get the selected subprotocol
+// (Sec-WebSocket-Protocol) and cookies (Set-Cookie).
+//
+// The response is acquired from the fasthttp response pool. The application
+// may return it to the pool with fasthttp.ReleaseResponse when it is no longer
+// used.
+//
+// If the WebSocket handshake fails, a *BadHandshakeError is returned along
+// with a non-nil *fasthttp.Response so that callers can handle redirects,
+// authentication, etcetera. The response body may not contain the entire
+// response.
+//
+// The dialer follows up to MaxRedirects redirect responses with a Location
+// header. The http and https schemes of the location are mapped to ws and
+// wss. A redirect from wss to ws is not followed. The Authorization and
+// Cookie headers of requestHeader are not sent to another host. A redirect
+// response that is not followed is returned as a *BadHandshakeError.
+func (d *FastHTTPDialer) DialContext(ctx context.Context, urlStr string, requestHeader *fasthttp.RequestHeader) (*Conn, *fasthttp.Response, error) {
+	if d == nil {
+		d = &FastHTTPDialer{HandshakeTimeout: DefaultDialer.HandshakeTimeout}
+	}
+
+	for redirects := 0; ; redirects++ {
+		conn, resp, err := d.dial(ctx, urlStr, requestHeader)
+		location, ok := redirectLocation(err)
+		if ok && redirects < d.MaxRedirects {
+			next, header, rerr := redirectFastHTTPRequest(urlStr, location, requestHeader)
+			if rerr == nil {
+				fasthttp.ReleaseResponse(resp)
+				urlStr, requestHeader = next, header
+				continue
+			}
+			err = redirectError(err, rerr)
+		} else if ok && d.MaxRedirects > 0 {
+			err = redirectError(err, ErrTooManyRedirects)
+		}
+		observeBadHandshake(d.Observer, err)
+		return conn, resp, err
+	}
+}
+
+// dial performs a single handshake.
+func (d *FastHTTPDialer) dial(ctx context.Context, urlStr string, requestHeader *fasthttp.RequestHeader) (*Conn, *fasthttp.Response, error) {
+	challengeKey, err := generateChallengeKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, nil, errMalformedURL
+	}
+
+	if u.User != nil {
+		// User name and password are not allowed in websocket URIs.
+		return nil, nil, errMalformedURL
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	// Set the request headers using the capitalization for names and values in
+	// RFC examples. Although the capitalization shouldn't matter, there are
+	// servers that depend on it.
+	req.Header.DisableNormalizing()
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.Header.SetRequestURI(u.RequestURI())
+	req.Header.SetHost(u.Host)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", challengeKey)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if requestHeader != nil {
+		var headerErr error
+		requestHeader.VisitAll(func(key, value []byte) {
+			k := strconv.B2S(key)
+			switch {
+			case headerErr != nil:
+			case equalASCIIFold(k, "Host"):
+				req.Header.SetHostBytes(value)
+			case equalASCIIFold(k, "Upgrade") ||
+				equalASCIIFold(k, "Connection") ||
+				equalASCIIFold(k, "Sec-Websocket-Key") ||
+				equalASCIIFold(k, "Sec-Websocket-Version") ||
+				equalASCIIFold(k, "Sec-Websocket-Extensions") ||
+				(equalASCIIFold(k, "Sec-Websocket-Protocol") && len(d.Subprotocols) > 0):
+				headerErr = errors.New("websocket: duplicate header not allowed: " + k)
+			default:
+				req.Header.AddBytesKV(key, value)
+			}
+		})
+		if headerErr != nil {
+			return nil, nil, headerErr
+		}
+	}
+
+	extensions := withCompression(d.EnableCompression, d.EnableContextTakeover, d.Extensions)
+	if len(extensions) > 0 {
+		req.Header.Set("Sec-WebSocket-Extensions", offerExtensions(extensions))
+	}
+
+	if d.HandshakeTimeout != 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	var netDial netDialerFunc
+	switch {
+	case u.Scheme == "https" && d.NetDialTLSContext != nil:
+		netDial = d.NetDialTLSContext
+	case d.NetDialContext != nil:
+		netDial = d.NetDialContext
+	default:
+		netDial = (&net.Dialer{}).DialContext
+	}
+
+	hostPort, hostNoPort := hostPortNoPort(u)
+	netConn, err := netDial(ctx, "tcp", hostPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Close the network connection when returning an error. The variable
+	// netConn is set to nil before the success return at the end of the
+	// function.
+	defer func() {
+		if netConn != nil {
+			// It's safe to ignore the error from Close() because this code is
+			// only executed when returning a more important error to the
+			// application.
+			_ = netConn.Close()
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := netConn.SetDeadline(deadline); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if u.Scheme == "https" && d.NetDialTLSContext == nil {
+		cfg := cloneTLSConfig(d.TLSClientConfig)
+		if cfg.ServerName == "" {
+			cfg.ServerName = hostNoPort
+		}
+		tlsConn := tls.Client(netConn, cfg)
+		netConn = tlsConn
+		if err := doHandshake(ctx, tlsConn, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	conn := newConn(netConn, false, d.ReadBufferSize, d.WriteBufferSize, d.WriteBufferPool, nil, nil)
+
+	if _, err := req.Header.WriteTo(netConn); err != nil {
+		return nil, nil, err
+	}
+
+	resp := fasthttp.AcquireResponse()
+	if err := resp.Header.Read(conn.br); err != nil {
+		fasthttp.ReleaseResponse(resp)
+		return nil, nil, err
+	}
+
+	wsTrace := ContextClientTrace(ctx)
+	if wsTrace != nil && wsTrace.HandshakeResponse != nil {
+		wsTrace.HandshakeResponse(resp.StatusCode(), fastHTTPResponseHeader(&resp.Header))
+	}
+	err = checkHandshakeResponse(resp.StatusCode(),
+		tokenContainsValue(strconv.B2S(resp.Header.Peek("Upgrade")), "websocket"),
+		tokenContainsValue(strconv.B2S(resp.Header.Peek("Connection")), "upgrade"),
+		strconv.B2S(resp.Header.Peek("Sec-Websocket-Accept")), challengeKey)
+	if wsTrace != nil && wsTrace.HandshakeValidated != nil {
+		wsTrace.HandshakeValidated(err)
+	}
+	if err != nil {
+		// Before closing the network connection on return from this
+		// function, slurp up some of the response to aid application
+		// debugging.
+		body := readHandshakeErrorBody(conn.br, &resp.Header)
+		resp.SetBodyRaw(body)
+		return nil, resp, &BadHandshakeError{Cause: err, StatusCode: resp.StatusCode(), Header: fastHTTPResponseHeader(&resp.Header), Body: body}
+	}
+
+	negotiated, err := configureExtensions(parseExtensionValues(resp.Header.PeekAll("Sec-WebSocket-Extensions")), extensions)
+	if wsTrace != nil && wsTrace.ExtensionsNegotiated != nil {
+		var values []string
+		for _, v := range resp.Header.PeekAll("Sec-WebSocket-Extensions") {
+			values = append(values, string(v))
+		}
+		wsTrace.ExtensionsNegotiated(strings.Join(values, ", "), err)
+	}
+	if err != nil {
+		return nil, resp, &BadHandshakeError{Cause: err, StatusCode: resp.StatusCode(), Header: fastHTTPResponseHeader(&resp.Header)}
+	}
+	conn.setExtensions(negotiated)
+	conn.setInterceptors(d.Interceptors, d.InterceptControl)
+	conn.codecs = d.Codecs
+	conn.subprotocol = string(resp.Header.Peek("Sec-Websocket-Protocol"))
+	if wsTrace != nil {
+		if wsTrace.SubprotocolSelected != nil {
+			wsTrace.SubprotocolSelected(conn.subprotocol)
+		}
+		conn.setTrace(wsTrace.ConnTrace)
+	}
+
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		return nil, resp, err
+	}
+
+	// Success! Set netConn to nil to stop the deferred function above from
+	// closing the network connection.
+	netConn = nil
+
+	conn.startObserving(d.Observer)
+	conn.StartKeepAlive(d.KeepAlive)
+
+	return conn, resp, nil
+}
+
+// readHandshakeErrorBody reads up to maxHandshakeErrorBody bytes of the body
+// of a failed handshake response. The body may be delimited by the content
+// length, chunked or delimited by the end of the connection.
+func readHandshakeErrorBody(br *bufio.Reader, h *fasthttp.ResponseHeader) []byte {
+	if code := h.StatusCode(); code < 200 || code == fasthttp.StatusNoContent || code == fasthttp.StatusNotModified {
+		return nil
+	}
+	var r io.Reader
+	switch n := h.ContentLength(); {
+	case n >= 0:
+		r = io.LimitReader(br, int64(n))
+	case n == -1:
+		r = httputil.NewChunkedReader(br)
+	default:
+		r = br
+	}
+	buf := make([]byte, maxHandshakeErrorBody)
+	n, _ := io.ReadFull(r, buf)
+	return buf[:n]
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var cstFastHTTPDialer = FastHTTPDialer{
+	Subprotocols:     []string{"p1", "p2"},
+	ReadBufferSize:   1024,
+	WriteBufferSize:  1024,
+	HandshakeTimeout: 30 * time.Second,
+}
+
+func TestFastHTTPDial(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	var header fasthttp.RequestHeader
+	header.Set("Origin", s.URL)
+	ws, resp, err := cstFastHTTPDialer.Dial(s.URL, &header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	defer fasthttp.ReleaseResponse(resp)
+
+	var sessionID string
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		var c fasthttp.Cookie
+		if c.ParseBytes(value) == nil && string(c.Key()) == "sessionID" {
+			sessionID = string(c.Value())
+		}
+	})
+	if sessionID != "1234" {
+		t.Error("Set-Cookie not received from the server.")
+	}
+	if ws.Subprotocol() != "p1" {
+		t.Errorf("ws.Subprotocol() = %s, want p1", ws.Subprotocol())
+	}
+	sendRecv(t, ws)
+}
+
+func TestFastHTTPDialCompression(t *testing.T) {
+	upgrader := FastHTTPUpgrader{EnableCompression: true, EnableContextTakeover: true}
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		_ = upgrader.Upgrade(ctx, fastHTTPEchoHandler)
+	})
+	defer s.Close()
+
+	dialer := cstFastHTTPDialer
+	dialer.Subprotocols = nil
+	dialer.EnableCompression = true
+	dialer.EnableContextTakeover = true
+	ws, resp, err := dialer.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	if got := string(resp.Header.Peek("Sec-WebSocket-Extensions")); got != "permessage-deflate" {
+		t.Errorf("Sec-WebSocket-Extensions = %q, want %q", got, "permessage-deflate")
+	}
+	for i := 0; i < 10; i++ {
+		sendRecv(t, ws)
+	}
+}
+
+func TestFastHTTPDialBadHeader(t *testing.T) {
+	var header fasthttp.RequestHeader
+	header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	_, _, err := cstFastHTTPDialer.Dial("ws://example.com", &header)
+	if err == nil {
+		t.Errorf("Dial succeeded with duplicate header")
+	}
+}
+
+func TestFastHTTPRespOnBadHandshake(t *testing.T) {
+	const expectedStatus = http.StatusGone
+	const expectedBody = "This is the response body."
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(expectedStatus)
+		_, _ = io.WriteString(w, expectedBody)
+	}))
+	defer s.Close()
+
+	ws, resp, err := cstFastHTTPDialer.Dial(makeWsProto(s.URL), nil)
+	if !errors.Is(err, ErrBadHandshake) {
+		if ws != nil {
+			ws.Close()
+		}
+		t.Fatalf("Dial returned %v, want %v", err, ErrBadHandshake)
+	}
+	if resp.StatusCode() != expectedStatus {
+		t.Errorf("resp.StatusCode=%d, want %d", resp.StatusCode(), expectedStatus)
+	}
+	if string(resp.Body()) != expectedBody {
+		t.Errorf("resp.Body=%s, want %s", resp.Body(), expectedBody)
+	}
+}
+
+func TestFastHTTPBadHandshakeBody(t *testing.T) {
+	const body = "This is the response body."
+	for name, handler := range map[string]http.HandlerFunc{
+		"chunked": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGone)
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, body)
+		},
+		"close": func(w http.ResponseWriter, r *http.Request) {
+			conn, brw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = brw.WriteString("HTTP/1.1 410 Gone\r\nConnection: close\r\n\r\n" + body)
+			_ = brw.Flush()
+		},
+	} {
+		s := httptest.NewServer(handler)
+		_, resp, err := cstFastHTTPDialer.Dial(makeWsProto(s.URL), nil)
+		s.Close()
+		var herr *BadHandshakeError
+		if !errors.As(err, &herr) || herr.StatusCode != http.StatusGone {
+			t.Fatalf("%s: Dial() returned %v, want *BadHandshakeError with status 410", name, err)
+		}
+		if string(herr.Body) != body || string(resp.Body()) != body {
+			t.Errorf("%s: body = %q, resp.Body = %q, want %q", name, herr.Body, resp.Body(), body)
+		}
+	}
+}
@@ -811,6 +811,44 @@ func TestDialCompression(t *testing.T) {
 	sendRecv(t, ws)
 }
 
+func TestDialCompressionContextTakeover(t *testing.T) {
+	upgrader := Upgrader{EnableCompression: true, EnableContextTakeover: true}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("Upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		for {
+			op, p, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(op, p); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	dialer := cstDialer
+	dialer.EnableCompression = true
+	dialer.EnableContextTakeover = true
+	ws, resp, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	if got, want := resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate"; got != want {
+		t.Errorf("Sec-WebSocket-Extensions = %q, want %q", got, want)
+	}
+	for i := 0; i < 10; i++ {
+		sendRecv(t, ws)
+	}
+}
+
 func TestSocksProxyDial(t *testing.T) {
 	s := newServer(t)
 	defer s.Close()
@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// CloseGracefully performs the closing handshake by calling
+// CloseGracefullyContext with a context that expires after timeout.
+func (c *Conn) CloseGracefully(code int, reason string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.CloseGracefullyContext(ctx, code, reason)
+}
+
+// CloseGracefullyContext performs the closing handshake described in RFC 6455,
+// section 7 and closes the underlying network connection.
+//
+// The messages in the write queue, if enabled, are written before the close
+// message. After sending the close message with code and reason,
+// CloseGracefullyContext reads and discards messages until the peer's close
+// message arrives or ctx is done. The application must not read or write the
+// connection concurrently with CloseGracefullyContext.
+//
+// CloseGracefullyContext returns nil if the peer answered with a close
+// message. Otherwise, the error returned by ctx or by the connection is
+// returned. The network connection is closed in all cases.
+func (c *Conn) CloseGracefullyContext(ctx context.Context, code int, reason string) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	defer c.Close()
+
+	if c.writeQueue != nil {
+		if err := c.writeQueue.flush(ctx); err != nil && err != ErrCloseSent {
+			return err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(writeWait)
+	}
+	err := c.WriteControl(CloseMessage, FormatCloseMessage(code, reason), deadline)
+	if err != nil && err != ErrCloseSent {
+		return err
+	}
+
+	stop := watchContext(ctx, func() {
+		_ = c.conn.SetReadDeadline(aLongTimeAgo)
+	})
+	for {
+		var r io.Reader
+		_, r, err = c.NextReader()
+		if err == nil {
+			_, err = io.Copy(io.Discard, r)
+		}
+		if err != nil {
+			break
+		}
+	}
+	aborted := stop()
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		return nil
+	}
+	if aborted {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCloseGracefully(t *testing.T) {
+	s, c := newPipeConns()
+	defer c.Close()
+
+	peerErr := make(chan error, 1)
+	// The peer sends a message that is pending when the close message is
+	// sent. The default close handler answers the close message.
+	go func() { _ = c.WriteMessage(TextMessage, []byte("pending")) }()
+	go func() {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				peerErr <- err
+				return
+			}
+		}
+	}()
+
+	if err := s.CloseGracefully(CloseNormalClosure, "bye", time.Second); err != nil {
+		t.Fatalf("CloseGracefully() returned %v", err)
+	}
+	err := <-peerErr
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseNormalClosure || closeErr.Text != "bye" {
+		t.Fatalf("peer ReadMessage() returned %v, want close %d bye", err, CloseNormalClosure)
+	}
+}
+
+func TestCloseGracefullyTimeout(t *testing.T) {
+	s, c := newPipeConns()
+	defer c.Close()
+
+	// The peer reads the close message but never answers it.
+	c.SetCloseHandler(func(code int, text string) error { return nil })
+	go func() {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err := s.CloseGracefully(CloseGoingAway, "", 10*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CloseGracefully() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCloseGracefullyWriteQueue(t *testing.T) {
+	s, c := newPipeConns()
+	defer c.Close()
+	s.EnableWriteQueue(8, OverflowBlock)
+
+	for _, m := range []string{"a", "b", "c"} {
+		if err := s.WriteMessage(TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := make(chan string, 4)
+	go func() {
+		for {
+			_, p, err := c.ReadMessage()
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- string(p)
+		}
+	}()
+
+	if err := s.CloseGracefullyContext(context.Background(), CloseNormalClosure, ""); err != nil {
+		t.Fatalf("CloseGracefullyContext() returned %v", err)
+	}
+	var got string
+	for m := range received {
+		got += m
+	}
+	if got != "abc" {
+		t.Fatalf("peer received %q, want abc", got)
+	}
+}
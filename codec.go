@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// Codec encodes and decodes the values of WriteValue and ReadValue. A codec
+// encodes each value as one message.
+//
+// The package provides codecs built on the standard library only. For a
+// compact binary encoding shared with peers written in other languages, wrap
+// a CBOR or MessagePack library in a Codec:
+//
+//	type cborCodec struct{}
+//
+//	func (cborCodec) MessageType() int { return websocket.BinaryMessage }
+//
+//	func (cborCodec) Encode(w io.Writer, v interface{}) error {
+//		return cbor.NewEncoder(w).Encode(v)
+//	}
+//
+//	func (cborCodec) Decode(r io.Reader, v interface{}) error {
+//		return cbor.NewDecoder(r).Decode(v)
+//	}
+type Codec interface {
+	// MessageType returns the type of the messages written by the codec,
+	// either TextMessage or BinaryMessage.
+	MessageType() int
+
+	// Encode writes the encoding of v to w.
+	Encode(w io.Writer, v interface{}) error
+
+	// Decode reads an encoded value from r and stores it in the value
+	// pointed to by v. The reader returns io.EOF at the end of the message.
+	Decode(r io.Reader, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes values with encoding/json in text messages. It is the
+	// codec of WriteJSON and ReadJSON.
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec encodes values with encoding/gob in binary messages. Each
+	// message is self-describing: the type information is sent with every
+	// value, which adds tens of bytes per message type to each message.
+	//
+	// Gob is specific to Go and suits connections between Go programs. The
+	// standard library has no other general-purpose binary encoding, and the
+	// package does not depend on third-party encoders. See the Codec
+	// documentation to use an interoperable encoding such as CBOR or
+	// MessagePack.
+	//
+	// Security: encoding/gob is not hardened against untrusted input. A
+	// hostile peer can send messages that make the decoder use large
+	// amounts of memory or CPU. Use GobCodec only between trusted peers, and
+	// limit the size of the messages with SetReadLimit or the ReadLimit of a
+	// SubprotocolRoute.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) MessageType() int { return TextMessage }
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) MessageType() int { return BinaryMessage }
+
+func (gobCodec) Encode(w io.Writer, v interface{}) error {
+	return gob.NewEncoder(w).Encode(v)
+}
+
+func (gobCodec) Decode(r io.Reader, v interface{}) error {
+	return gob.NewDecoder(r).Decode(v)
+}
+
+// SetCodec sets the codec of WriteValue and ReadValue. If codec is nil, the
+// codec is selected by the negotiated subprotocol.
+func (c *Conn) SetCodec(codec Codec) {
+	c.codec = codec
+}
+
+// Codec returns the codec of WriteValue and ReadValue: the codec set with
+// SetCodec, or the codec of the negotiated subprotocol in the Codecs field
+// of the upgrader or dialer, or JSONCodec.
+func (c *Conn) Codec() Codec {
+	if c.codec != nil {
+		return c.codec
+	}
+	if codec, ok := c.codecs[c.subprotocol]; ok {
+		return codec
+	}
+	return JSONCodec
+}
+
+// WriteValue writes the encoding of v as a message using the connection's
+// codec. The message type is the codec's message type.
+func (c *Conn) WriteValue(v interface{}) error {
+	return c.writeValue(c.Codec(), v)
+}
+
+// ReadValue reads the next message from the connection and decodes it into
+// the value pointed to by v using the connection's codec. The size of the
+// message is limited by SetReadLimit.
+func (c *Conn) ReadValue(v interface{}) error {
+	return c.readValue(c.Codec(), v)
+}
+
+func (c *Conn) writeValue(codec Codec, v interface{}) error {
+	if c.writeQueue != nil {
+		var buf bytes.Buffer
+		if err := codec.Encode(&buf, v); err != nil {
+			return err
+		}
+		return c.writeQueue.push(context.Background(), queuedMessage{messageType: codec.MessageType(), data: buf.Bytes()})
+	}
+	w, err := c.NextWriter(codec.MessageType())
+	if err != nil {
+		return err
+	}
+	err1 := codec.Encode(w, v)
+	err2 := w.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+func (c *Conn) readValue(codec Codec, v interface{}) error {
+	_, r, err := c.NextReader()
+	if err != nil {
+		return err
+	}
+	err = codec.Decode(r, v)
+	if err == io.EOF {
+		// One value is expected in the message.
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type codecTestValue struct {
+	A int
+	B string
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		s, c := newPipeConns()
+		s.SetCodec(codec)
+		c.SetCodec(codec)
+
+		expect := codecTestValue{A: 1, B: "hello"}
+		done := make(chan error, 1)
+		go func() { done <- s.WriteValue(&expect) }()
+
+		mt, r, err := c.NextReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt != codec.MessageType() {
+			t.Errorf("%T: message type = %d, want %d", codec, mt, codec.MessageType())
+		}
+		var actual codecTestValue
+		if err := codec.Decode(r, &actual); err != nil {
+			t.Fatalf("%T: decode: %v", codec, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf("%T: got %+v, want %+v", codec, actual, expect)
+		}
+
+		go func() { done <- s.WriteMessage(codec.MessageType(), nil) }()
+		if err := c.ReadValue(&actual); err != io.ErrUnexpectedEOF {
+			t.Errorf("%T: ReadValue() of empty message returned %v, want %v", codec, err, io.ErrUnexpectedEOF)
+		}
+		<-done
+		s.Close()
+		c.Close()
+	}
+}
+
+func TestCodecSubprotocol(t *testing.T) {
+	codecs := map[string]Codec{"gob": GobCodec}
+	upgrader := Upgrader{Subprotocols: []string{"gob", "json"}, Codecs: codecs}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		var v codecTestValue
+		if err := ws.ReadValue(&v); err != nil {
+			return
+		}
+		v.A++
+		_ = ws.WriteValue(&v)
+	}))
+	defer srv.Close()
+
+	for _, subprotocol := range []string{"gob", "json"} {
+		dialer := cstDialer
+		dialer.Subprotocols = []string{subprotocol}
+		dialer.Codecs = codecs
+		ws, _, err := dialer.Dial(makeWsProto(srv.URL), nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		want := codecs[subprotocol]
+		if want == nil {
+			want = JSONCodec
+		}
+		if ws.Codec() != want {
+			t.Errorf("Codec() = %T for subprotocol %s, want %T", ws.Codec(), subprotocol, want)
+		}
+		if err := ws.WriteValue(&codecTestValue{A: 1}); err != nil {
+			t.Fatal(err)
+		}
+		var v codecTestValue
+		if err := ws.ReadValue(&v); err != nil || v.A != 2 {
+			t.Errorf("ReadValue() = %+v, %v, want A = 2", v, err)
+		}
+		ws.Close()
+	}
+}
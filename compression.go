@@ -7,6 +7,7 @@ package websocket
 import (
 	"errors"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -151,3 +152,396 @@ func (r *flateReadWrapper) Close() error {
 	r.fr = nil
 	return err
 }
+
+const (
+	// Range of LZ77 window sizes allowed by RFC 7692, section 7.1.2.
+	minWindowBits = 8
+	maxWindowBits = 15
+)
+
+// deflateParams holds the permessage-deflate parameters agreed in the opening
+// handshake. See RFC 7692, section 7.1.
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+	serverMaxWindowBits     int // zero when not negotiated
+	clientMaxWindowBits     int // zero when not negotiated
+}
+
+// parseWindowBits parses the value of a max window bits extension parameter.
+func parseWindowBits(v string) (int, bool) {
+	// The value is a decimal integer in the range 8 to 15 without leading
+	// zeros.
+	if len(v) == 0 || len(v) > 2 || v[0] == '0' {
+		return 0, false
+	}
+	bits, err := strconv.Atoi(v)
+	if err != nil || bits < minWindowBits || bits > maxWindowBits {
+		return 0, false
+	}
+	return bits, true
+}
+
+// acceptDeflateOffer examines a permessage-deflate offer from a client. If
+// the offer is acceptable, the function returns the parameters to use and
+// the parameters of the response to send to the client.
+func acceptDeflateOffer(offer map[string]string, contextTakeover bool) (deflateParams, ExtensionParams, bool) {
+	var p deflateParams
+	for k, v := range offer {
+		switch k {
+		case "":
+		case "server_no_context_takeover":
+			if v != "" {
+				return p, nil, false
+			}
+			p.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			if v != "" {
+				return p, nil, false
+			}
+			p.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			bits, ok := parseWindowBits(v)
+			if !ok {
+				return p, nil, false
+			}
+			p.serverMaxWindowBits = bits
+		case "client_max_window_bits":
+			// The parameter without a value only signals that the client
+			// supports limiting its window size.
+			if v != "" {
+				if _, ok := parseWindowBits(v); !ok {
+					return p, nil, false
+				}
+			}
+		default:
+			return p, nil, false
+		}
+	}
+
+	if !contextTakeover {
+		p.serverNoContextTakeover = true
+		p.clientNoContextTakeover = true
+	}
+
+	response := ExtensionParams{}
+	if p.serverNoContextTakeover {
+		response["server_no_context_takeover"] = ""
+	}
+	if p.clientNoContextTakeover {
+		response["client_no_context_takeover"] = ""
+	}
+	if p.serverMaxWindowBits != 0 {
+		response["server_max_window_bits"] = strconv.Itoa(p.serverMaxWindowBits)
+	}
+	return p, response, true
+}
+
+// deflateOffer returns the parameters of the permessage-deflate offer sent by
+// a client.
+func deflateOffer(contextTakeover bool) ExtensionParams {
+	if !contextTakeover {
+		return ExtensionParams{"server_no_context_takeover": "", "client_no_context_takeover": ""}
+	}
+	return ExtensionParams{"client_max_window_bits": ""}
+}
+
+// checkDeflateResponse validates the permessage-deflate response from a
+// server against the offer returned by deflateOffer.
+func checkDeflateResponse(response map[string]string, contextTakeover bool) (deflateParams, error) {
+	var p deflateParams
+	for k, v := range response {
+		switch k {
+		case "":
+		case "server_no_context_takeover":
+			if v != "" {
+				return p, errInvalidCompression
+			}
+			p.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			if v != "" {
+				return p, errInvalidCompression
+			}
+			p.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			bits, ok := parseWindowBits(v)
+			if !ok {
+				return p, errInvalidCompression
+			}
+			p.serverMaxWindowBits = bits
+		case "client_max_window_bits":
+			// The parameter is only offered with context takeover.
+			bits, ok := parseWindowBits(v)
+			if !ok || !contextTakeover {
+				return p, errInvalidCompression
+			}
+			p.clientMaxWindowBits = bits
+		default:
+			return p, errInvalidCompression
+		}
+	}
+	if !contextTakeover && (!p.serverNoContextTakeover || !p.clientNoContextTakeover) {
+		return p, errInvalidCompression
+	}
+	return p, nil
+}
+
+// enableDeflate configures the connection to compress and decompress messages
+// with the negotiated permessage-deflate parameters.
+func (c *Conn) enableDeflate(p deflateParams) {
+	c.extensions = append(c.extensions, &deflateExtension{params: p, c: c})
+	c.rsvMask |= rsv1Bit
+
+	writeTakeover, readTakeover := !p.serverNoContextTakeover, !p.clientNoContextTakeover
+	writeWindowBits, readWindowBits := p.serverMaxWindowBits, p.clientMaxWindowBits
+	if !c.isServer {
+		writeTakeover, readTakeover = readTakeover, writeTakeover
+		writeWindowBits, readWindowBits = readWindowBits, writeWindowBits
+	}
+	if writeWindowBits == 0 {
+		writeWindowBits = maxWindowBits
+	}
+	if readWindowBits == 0 {
+		readWindowBits = maxWindowBits
+	}
+
+	if writeTakeover || writeWindowBits < maxWindowBits {
+		cc := &compressContext{contextTakeover: writeTakeover, windowBits: writeWindowBits}
+		c.newCompressionWriter = cc.newWriter
+		c.compressStateful = true
+		if writeTakeover {
+			c.compressionLevel = contextTakeoverCompressionLevel
+		}
+	} else {
+		c.newCompressionWriter = compressNoContextTakeover
+	}
+	if readTakeover {
+		c.newDecompressionReader = (&decompressContext{size: 1 << readWindowBits}).newReader
+	} else {
+		c.newDecompressionReader = decompressNoContextTakeover
+	}
+}
+
+// contextTakeoverCompressionLevel is the default compression level with
+// context takeover. It is the lowest level at which the compressor matches
+// short messages against earlier messages: the faster levels of the flate
+// package encode short writes without looking at the sliding window or at a
+// preset dictionary.
+const contextTakeoverCompressionLevel = 7
+
+// compressContext holds the compressor owned by a connection. The compressor
+// retains the sliding window across messages when context takeover is used.
+type compressContext struct {
+	contextTakeover bool
+	windowBits      int
+
+	fw    *flate.Writer
+	tw    truncWriter
+	level int
+
+	// The fields below are used when the peer limits the window size.
+	wfw     *flate.Writer // compressor of messages larger than the window
+	buf     []byte        // message buffered until it exceeds the window
+	history []byte        // last bytes of the earlier messages
+}
+
+func (cc *compressContext) newWriter(w io.WriteCloser, level int) io.WriteCloser {
+	cc.tw = truncWriter{w: w}
+	if cc.windowBits < maxWindowBits {
+		cc.buf = cc.buf[:0]
+		return &flateWindowWriteWrapper{cc: cc, level: level}
+	}
+	switch {
+	case cc.fw == nil || cc.level != level:
+		// A new compressor does not refer to earlier messages. The peer's
+		// sliding window remains valid.
+		cc.fw, _ = flate.NewWriter(&cc.tw, level)
+		cc.level = level
+	case !cc.contextTakeover:
+		cc.fw.Reset(&cc.tw)
+	}
+	return &flateContextWriteWrapper{cc: cc}
+}
+
+// finish flushes the compressor and closes the frame writer.
+func (cc *compressContext) finish(fw *flate.Writer) error {
+	err1 := fw.Flush()
+	if cc.tw.p != [4]byte{0, 0, 0xff, 0xff} {
+		return errors.New("websocket: internal error, unexpected bytes at end of flate stream")
+	}
+	err2 := cc.tw.w.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+type flateContextWriteWrapper struct {
+	cc *compressContext
+}
+
+func (w *flateContextWriteWrapper) Write(p []byte) (int, error) {
+	if w.cc == nil {
+		return 0, errWriteClosed
+	}
+	return w.cc.fw.Write(p)
+}
+
+func (w *flateContextWriteWrapper) Close() error {
+	if w.cc == nil {
+		return errWriteClosed
+	}
+	cc := w.cc
+	w.cc = nil
+	return cc.finish(cc.fw)
+}
+
+// flateWindowWriteWrapper compresses a message when the peer limits the size
+// of the sliding window. A message that fits in the window is buffered and
+// compressed with the last bytes of the earlier messages as dictionary, so
+// that no match refers beyond the window. A larger message is compressed
+// without referring to earlier messages.
+type flateWindowWriteWrapper struct {
+	cc        *compressContext
+	level     int
+	streaming bool // the message is larger than the window
+}
+
+func (w *flateWindowWriteWrapper) Write(p []byte) (int, error) {
+	cc := w.cc
+	if cc == nil {
+		return 0, errWriteClosed
+	}
+	size := 1 << cc.windowBits
+	if cc.contextTakeover {
+		cc.history = appendWindow(cc.history, p, size)
+	}
+	if w.streaming {
+		return cc.wfw.Write(p)
+	}
+	if len(cc.buf)+len(p) <= size {
+		cc.buf = append(cc.buf, p...)
+		return len(p), nil
+	}
+	if cc.wfw == nil {
+		cc.wfw, _ = flate.NewWriterWindow(&cc.tw, size)
+	} else {
+		cc.wfw.Reset(&cc.tw)
+	}
+	w.streaming = true
+	if _, err := cc.wfw.Write(cc.buf); err != nil {
+		return 0, err
+	}
+	return cc.wfw.Write(p)
+}
+
+func (w *flateWindowWriteWrapper) Close() error {
+	cc := w.cc
+	if cc == nil {
+		return errWriteClosed
+	}
+	w.cc = nil
+	if w.streaming {
+		return cc.finish(cc.wfw)
+	}
+
+	// The history already holds the message. The dictionary is the part of
+	// the window before the message.
+	var dict []byte
+	if cc.contextTakeover {
+		dict = cc.history[:len(cc.history)-len(cc.buf)]
+		if n := len(dict) + len(cc.buf) - 1<<cc.windowBits; n > 0 {
+			dict = dict[n:]
+		}
+	}
+	if cc.fw == nil || cc.level != w.level {
+		cc.fw, _ = flate.NewWriterDict(&cc.tw, w.level, dict)
+		cc.level = w.level
+	} else {
+		cc.fw.ResetDict(&cc.tw, dict)
+	}
+	if _, err := cc.fw.Write(cc.buf); err != nil {
+		return err
+	}
+	return cc.finish(cc.fw)
+}
+
+// appendWindow appends p to the sliding window and keeps the last size bytes.
+func appendWindow(window, p []byte, size int) []byte {
+	if len(p) >= size {
+		return append(window[:0], p[len(p)-size:]...)
+	}
+	if n := len(window) + len(p) - size; n > 0 {
+		window = window[:copy(window, window[n:])]
+	}
+	return append(window, p...)
+}
+
+// decompressContext holds the sliding window shared by the messages read from
+// a connection with context takeover.
+type decompressContext struct {
+	size   int
+	window []byte
+}
+
+func (dc *decompressContext) newReader(r io.Reader) io.ReadCloser {
+	const tail =
+	// Add four bytes as specified in RFC
+	"\x00\x00\xff\xff" +
+		// Add final block to squelch unexpected EOF error from flate reader.
+		"\x01\x00\x00\xff\xff"
+
+	fr, _ := flateReaderPool.Get().(io.ReadCloser)
+	mr := io.MultiReader(r, strings.NewReader(tail))
+	if err := fr.(flate.Resetter).Reset(mr, dc.window); err != nil {
+		// Reset never fails, but handle error in case that changes.
+		fr = flate.NewReaderDict(mr, dc.window)
+	}
+	return &flateContextReadWrapper{fr: fr, dc: dc}
+}
+
+// write appends decompressed data to the sliding window.
+func (dc *decompressContext) write(p []byte) {
+	dc.window = appendWindow(dc.window, p, dc.size)
+}
+
+type flateContextReadWrapper struct {
+	fr io.ReadCloser
+	dc *decompressContext
+}
+
+func (r *flateContextReadWrapper) Read(p []byte) (int, error) {
+	if r.fr == nil {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := r.fr.Read(p)
+	r.dc.write(p[:n])
+	if err == io.EOF {
+		r.release()
+	}
+	return n, err
+}
+
+func (r *flateContextReadWrapper) Close() error {
+	if r.fr == nil {
+		return io.ErrClosedPipe
+	}
+	// Decompress the remainder of the message so that the sliding window is
+	// complete for the next message.
+	var buf [512]byte
+	for {
+		n, err := r.fr.Read(buf[:])
+		r.dc.write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	return r.release()
+}
+
+func (r *flateContextReadWrapper) release() error {
+	err := r.fr.Close()
+	flateReaderPool.Put(r.fr)
+	r.fr = nil
+	return err
+}
@@ -2,9 +2,13 @@ package websocket
 
 import (
 	"bytes"
+	stdflate "compress/flate"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/flate"
 )
 
 type nopCloser struct{ io.Writer }
@@ -55,8 +59,7 @@ func BenchmarkWriteWithCompression(b *testing.B) {
 	w := io.Discard
 	c := newTestConn(nil, w, false)
 	messages := textMessages(100)
-	c.enableWriteCompression = true
-	c.newCompressionWriter = compressNoContextTakeover
+	c.enableDeflate(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_ = c.WriteMessage(TextMessage, messages[i%len(messages)])
@@ -77,3 +80,251 @@ func TestValidCompressionLevel(t *testing.T) {
 		}
 	}
 }
+
+var acceptDeflateOfferTests = []struct {
+	offer           map[string]string
+	contextTakeover bool
+	response        string
+	ok              bool
+}{
+	{map[string]string{"": "permessage-deflate"}, false, "permessage-deflate; client_no_context_takeover; server_no_context_takeover", true},
+	{map[string]string{"": "permessage-deflate"}, true, "permessage-deflate", true},
+	{map[string]string{"": "permessage-deflate", "client_max_window_bits": ""}, true, "permessage-deflate", true},
+	{map[string]string{"": "permessage-deflate", "client_no_context_takeover": ""}, true, "permessage-deflate; client_no_context_takeover", true},
+	{map[string]string{"": "permessage-deflate", "server_no_context_takeover": ""}, true, "permessage-deflate; server_no_context_takeover", true},
+	{map[string]string{"": "permessage-deflate", "server_max_window_bits": "15"}, true, "permessage-deflate; server_max_window_bits=15", true},
+	{map[string]string{"": "permessage-deflate", "server_max_window_bits": "10"}, true, "permessage-deflate; server_max_window_bits=10", true},
+	{map[string]string{"": "permessage-deflate", "server_max_window_bits": ""}, true, "", false},
+	{map[string]string{"": "permessage-deflate", "client_max_window_bits": "16"}, true, "", false},
+	{map[string]string{"": "permessage-deflate", "client_max_window_bits": "010"}, true, "", false},
+	{map[string]string{"": "permessage-deflate", "server_no_context_takeover": "x"}, true, "", false},
+	{map[string]string{"": "permessage-deflate", "unknown": ""}, true, "", false},
+}
+
+func TestAcceptDeflateOffer(t *testing.T) {
+	for _, tt := range acceptDeflateOfferTests {
+		_, params, ok := acceptDeflateOffer(tt.offer, tt.contextTakeover)
+		var response string
+		if ok {
+			response = formatExtension("permessage-deflate", params)
+		}
+		if ok != tt.ok || response != tt.response {
+			t.Errorf("acceptDeflateOffer(%v, %v) = %q, %v, want %q, %v", tt.offer, tt.contextTakeover, response, ok, tt.response, tt.ok)
+		}
+	}
+}
+
+var checkDeflateResponseTests = []struct {
+	response        map[string]string
+	contextTakeover bool
+	ok              bool
+}{
+	{map[string]string{"": "permessage-deflate", "server_no_context_takeover": "", "client_no_context_takeover": ""}, false, true},
+	{map[string]string{"": "permessage-deflate", "server_no_context_takeover": ""}, false, false},
+	{map[string]string{"": "permessage-deflate"}, true, true},
+	{map[string]string{"": "permessage-deflate", "server_max_window_bits": "9"}, true, true},
+	{map[string]string{"": "permessage-deflate", "server_max_window_bits": "7"}, true, false},
+	{map[string]string{"": "permessage-deflate", "client_max_window_bits": "10"}, true, true},
+	{map[string]string{"": "permessage-deflate", "client_max_window_bits": ""}, true, false},
+	{map[string]string{"": "permessage-deflate", "server_no_context_takeover": "", "client_no_context_takeover": "", "client_max_window_bits": "10"}, false, false},
+}
+
+func TestCheckDeflateResponse(t *testing.T) {
+	for _, tt := range checkDeflateResponseTests {
+		_, err := checkDeflateResponse(tt.response, tt.contextTakeover)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkDeflateResponse(%v, %v) returned %v, want ok=%v", tt.response, tt.contextTakeover, err, tt.ok)
+		}
+	}
+}
+
+func jsonMessages(num int) [][]byte {
+	messages := make([][]byte, num)
+	for i := 0; i < num; i++ {
+		msg := fmt.Sprintf(`{"type":"message","room":"general","user":{"id":%d,"name":"user%d"},"text":"planet: %d, country: %d, city: %d, street: %d","client":"web"}`, i%5, i%5, i, i, i, i)
+		messages[i] = []byte(msg)
+	}
+	return messages
+}
+
+// shortJSONMessages returns messages shorter than 128 bytes.
+func shortJSONMessages(num int) [][]byte {
+	messages := make([][]byte, num)
+	for i := 0; i < num; i++ {
+		messages[i] = []byte(fmt.Sprintf(`{"type":"update","id":%d,"value":"abcdefghij"}`, i))
+	}
+	return messages
+}
+
+func TestContextTakeover(t *testing.T) {
+	messages := append(jsonMessages(100), shortJSONMessages(50)...)
+	// The large message exceeds the limited windows.
+	messages = append(messages, bytes.Repeat([]byte("0123456789abcdef"), 100))
+	messages = append(messages, shortJSONMessages(50)...)
+	for _, p := range []deflateParams{{}, {serverMaxWindowBits: 9, clientMaxWindowBits: 8}, {serverNoContextTakeover: true, clientNoContextTakeover: true, serverMaxWindowBits: 10, clientMaxWindowBits: 10}} {
+		for _, isServer := range []bool{true, false} {
+			var connBuf bytes.Buffer
+			wc := newTestConn(nil, &connBuf, isServer)
+			rc := newTestConn(&connBuf, nil, !isServer)
+			wc.enableDeflate(p)
+			rc.enableDeflate(p)
+			if !wc.compressStateful {
+				t.Errorf("%+v: compressStateful = false, want true", p)
+			}
+
+			for i, message := range messages {
+				if i == len(messages)/2 {
+					// Changing the level discards the compressor state.
+					if err := wc.SetCompressionLevel(flate.BestCompression); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := wc.WriteMessage(TextMessage, message); err != nil {
+					t.Fatalf("%+v: WriteMessage() returned %v", p, err)
+				}
+				if i%10 == 0 {
+					// Read part of the message to check that the remainder is
+					// added to the sliding window when the reader is closed.
+					_, r, err := rc.NextReader()
+					if err != nil {
+						t.Fatalf("%+v: NextReader() returned %v", p, err)
+					}
+					if _, err := r.Read(make([]byte, 5)); err != nil {
+						t.Fatalf("%+v: Read() returned %v", p, err)
+					}
+					continue
+				}
+				_, b, err := rc.ReadMessage()
+				if err != nil {
+					t.Fatalf("%+v: ReadMessage() returned %v", p, err)
+				}
+				if !bytes.Equal(b, message) {
+					t.Fatalf("%+v: ReadMessage() = %q, want %q", p, b, message)
+				}
+			}
+			if connBuf.Len() != 0 {
+				t.Errorf("%+v: %d unread bytes", p, connBuf.Len())
+			}
+		}
+	}
+}
+
+func TestContextTakeoverCompression(t *testing.T) {
+	for _, messages := range [][][]byte{jsonMessages(100), shortJSONMessages(200)} {
+		raw := 0
+		for _, message := range messages {
+			raw += len(message)
+		}
+		write := func(c *Conn) {
+			for _, message := range messages {
+				if err := c.WriteMessage(TextMessage, message); err != nil {
+					t.Fatalf("WriteMessage() returned %v", err)
+				}
+			}
+		}
+
+		var noContextBuf bytes.Buffer
+		nc := newTestConn(nil, &noContextBuf, true)
+		nc.enableDeflate(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
+		write(nc)
+
+		// Level 0 stands for the default level of the connection.
+		for _, level := range []int{0, flate.BestCompression} {
+			for _, p := range []deflateParams{{}, {serverMaxWindowBits: 9}} {
+				var contextBuf bytes.Buffer
+				cc := newTestConn(nil, &contextBuf, true)
+				cc.enableDeflate(p)
+				if level != 0 {
+					_ = cc.SetCompressionLevel(level)
+				}
+				write(cc)
+
+				// The frame headers take 2 bytes per message.
+				if contextBuf.Len() >= noContextBuf.Len() || contextBuf.Len()-2*len(messages) >= raw/2 {
+					t.Errorf("%d bytes of messages, level %d, %+v: context takeover wrote %d bytes, no context takeover wrote %d bytes", raw, level, p, contextBuf.Len(), noContextBuf.Len())
+				}
+			}
+		}
+	}
+}
+
+func TestContextTakeoverCompressionLevel(t *testing.T) {
+	c := newTestConn(nil, io.Discard, true)
+	c.enableDeflate(deflateParams{})
+	if c.compressionLevel != contextTakeoverCompressionLevel {
+		t.Errorf("default level = %d, want %d", c.compressionLevel, contextTakeoverCompressionLevel)
+	}
+
+	// An explicit level is used as is.
+	messages := shortJSONMessages(100)
+	sizes := make(map[int]int)
+	for _, level := range []int{flate.BestSpeed, contextTakeoverCompressionLevel} {
+		var buf bytes.Buffer
+		c := newTestConn(nil, &buf, true)
+		c.enableDeflate(deflateParams{})
+		if err := c.SetCompressionLevel(level); err != nil {
+			t.Fatal(err)
+		}
+		for _, message := range messages {
+			if err := c.WriteMessage(TextMessage, message); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sizes[level] = buf.Len()
+	}
+	if sizes[flate.BestSpeed] <= sizes[contextTakeoverCompressionLevel] {
+		t.Errorf("level %d wrote %d bytes, level %d wrote %d bytes, want the explicit level used", flate.BestSpeed, sizes[flate.BestSpeed], contextTakeoverCompressionLevel, sizes[contextTakeoverCompressionLevel])
+	}
+}
+
+// TestContextTakeoverWindow decodes the messages compressed for a limited
+// sliding window with a decompressor holding only the window of the earlier
+// messages, as a peer limiting its window does.
+func TestContextTakeoverWindow(t *testing.T) {
+	messages := append(jsonMessages(50), shortJSONMessages(50)...)
+	// The large message exceeds the limited windows.
+	messages = append(messages, bytes.Repeat([]byte("0123456789abcdef"), 100))
+	messages = append(messages, jsonMessages(50)...)
+	const tail = "\x00\x00\xff\xff\x01\x00\x00\xff\xff"
+
+	for _, bits := range []int{9, 10, 15} {
+		var buf bytes.Buffer
+		wc := newTestConn(nil, &buf, true)
+		wc.enableDeflate(deflateParams{serverMaxWindowBits: bits})
+		for _, message := range messages {
+			if err := wc.WriteMessage(TextMessage, message); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rc := newTestConn(&buf, nil, false)
+		rc.enableDeflate(deflateParams{serverMaxWindowBits: bits})
+		var window []byte
+		for i, message := range messages {
+			f, err := rc.ReadFrame()
+			if err != nil {
+				t.Fatalf("window bits %d, message %d: ReadFrame() returned %v", bits, i, err)
+			}
+			fr := stdflate.NewReaderDict(io.MultiReader(bytes.NewReader(f.Payload), strings.NewReader(tail)), window)
+			p, err := io.ReadAll(fr)
+			if err != nil || !bytes.Equal(p, message) {
+				t.Fatalf("window bits %d, message %d: decoded %q, %v, want %q", bits, i, p, err, message)
+			}
+			window = appendWindow(window, p, 1<<bits)
+		}
+	}
+}
+
+func TestDecompressContextWindow(t *testing.T) {
+	dc := &decompressContext{size: 8}
+	for _, s := range []string{"abc", "defgh", "ij", "0123456789"} {
+		dc.write([]byte(s))
+	}
+	if string(dc.window) != "23456789" {
+		t.Errorf("window = %q, want %q", dc.window, "23456789")
+	}
+	dc.write([]byte("xy"))
+	if string(dc.window) != "456789xy" {
+		t.Errorf("window = %q, want %q", dc.window, "456789xy")
+	}
+}
@@ -6,6 +6,7 @@ package websocket
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
@@ -14,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
@@ -255,30 +257,61 @@ type Conn struct {
 	writeErrMu sync.Mutex
 	writeErr   error
 
+	// cancelMu guards the abort of a context write. The abort sets the write
+	// deadline only while a data frame is written so that concurrent control
+	// frames are not affected.
+	cancelMu      sync.Mutex
+	writeCanceled bool // set when a context aborts the current message
+	writingData   bool // a data frame is written to the network connection
+
 	enableWriteCompression bool
 	compressionLevel       int
 	newCompressionWriter   func(io.WriteCloser, int) io.WriteCloser
+	compressStateful       bool // whether compression depends on connection state
 
 	// Read fields
-	reader  io.ReadCloser // the current reader returned to the application
-	readErr error
-	br      *bufio.Reader
+	reader       io.ReadCloser // the current reader returned to the application
+	readErr      error
+	readDeadline time.Time
+	br           *bufio.Reader
 	// bytes remaining in current frame.
 	// set setReadRemaining to safely update this value and prevent overflow
-	readRemaining int64
-	readFinal     bool  // true the current message has more frames.
-	readLength    int64 // Message size.
-	readLimit     int64 // Maximum message size.
-	readMaskPos   int
-	readMaskKey   [4]byte
-	handlePong    func(string) error
-	handlePing    func(string) error
-	handleClose   func(int, string) error
-	readErrCount  int
-	messageReader *messageReader // the current low-level reader
-
-	readDecompress         bool // whether last read frame had RSV1 set
+	readRemaining      int64
+	readFinal          bool  // true the current message has more frames.
+	readLength         int64 // Message size.
+	readLimit          int64 // Maximum message size.
+	readMaskPos        int
+	readMaskKey        [4]byte
+	handlePong         func(string) error
+	handlePing         func(string) error
+	handleClose        func(int, string) error
+	readErrCount       int
+	skipUTF8Validation bool
+	messageReader      *messageReader // the current low-level reader
+
+	readRSV                byte   // RSV bits of the first frame of the current message
+	readFrameRSV           byte   // RSV bits of the last frame read
+	readControl            []byte // payload of the last control frame read
 	newDecompressionReader func(io.Reader) io.ReadCloser
+
+	extensions []NegotiatedExtension // in the order listed in the handshake response
+	rsvMask    byte                  // RSV bits claimed by the extensions
+
+	interceptors     []Interceptor
+	interceptControl bool // whether control messages are intercepted
+	readingFrame     bool // whether ReadFrame is reading, which bypasses the interceptors
+
+	codec  Codec            // set by SetCodec
+	codecs map[string]Codec // codecs by subprotocol
+
+	observer       Observer
+	observerClosed atomic.Bool // set when ObserveConnClose is called
+	trace          *connTrace
+
+	upgradeRequest *FastHTTPUpgradeRequest
+	keepAlive      *keepAlive
+	writeQueue     *writeQueue
+	registry       *ConnRegistry
 }
 
 func newConn(conn net.Conn, isServer bool, readBufferSize, writeBufferSize int, writeBufferPool BufferPool, br *bufio.Reader, writeBuf []byte) *Conn {
@@ -350,9 +383,28 @@ func (c *Conn) Close() error {
 	if c.conn == nil {
 		return ErrNilNetConn
 	}
+	c.release()
 	return c.conn.Close()
 }
 
+// release stops the keepalive and the write queue of the connection, removes
+// the connection from its registry and notifies the observer. It is called by
+// Close and when the handler of a connection hijacked from fasthttp returns.
+func (c *Conn) release() {
+	if c.keepAlive != nil {
+		c.keepAlive.close()
+	}
+	if c.writeQueue != nil {
+		c.writeQueue.fail(net.ErrClosed)
+	}
+	if c.registry != nil {
+		c.registry.remove(c)
+	}
+	if c.observer != nil && c.observerClosed.CompareAndSwap(false, true) {
+		c.observer.ObserveConnClose(c)
+	}
+}
+
 // LocalAddr returns the local network address.
 func (c *Conn) LocalAddr() net.Addr {
 	if c == nil || c.conn == nil {
@@ -417,6 +469,12 @@ func (c *Conn) write(frameType int, deadline time.Time, buf0, buf1 []byte) error
 	if err := c.conn.SetWriteDeadline(deadline); err != nil {
 		return c.writeFatal(err)
 	}
+	if !isControl(frameType) {
+		if err := c.beginDataWrite(); err != nil {
+			return c.writeFatal(err)
+		}
+		defer c.endDataWrite()
+	}
 	if len(buf1) == 0 {
 		_, err = c.conn.Write(buf0)
 	} else {
@@ -446,6 +504,19 @@ func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) er
 	if !isControl(messageType) {
 		return errBadWriteOpCode
 	}
+	if c.interceptControl {
+		var err error
+		if data, err = c.intercept(OutboundMessage, messageType, data); err != nil {
+			if err == ErrDropMessage {
+				return nil
+			}
+			return c.rejectMessage(err)
+		}
+	}
+	return c.writeControl(messageType, data, deadline)
+}
+
+func (c *Conn) writeControl(messageType int, data []byte, deadline time.Time) error {
 	if len(data) > maxControlFramePayloadSize {
 		return errInvalidControlFrame
 	}
@@ -507,7 +578,9 @@ func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) er
 	if _, err = c.conn.Write(buf); err != nil {
 		return c.writeFatal(err)
 	}
+	c.observeFrame(OutboundMessage, messageType, int64(len(data)))
 	if messageType == CloseMessage {
+		c.closeSent(data)
 		_ = c.writeFatal(ErrCloseSent)
 	}
 	return err
@@ -564,22 +637,46 @@ func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
 	if c == nil {
 		return nil, ErrNilConn
 	}
+	if c.intercepts(messageType) {
+		// The message is written by the writer's Close method.
+		if c.writer != nil {
+			c.writer.Close()
+			c.writer = nil
+		}
+		c.writeErrMu.Lock()
+		err := c.writeErr
+		c.writeErrMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		c.writer = &interceptWriter{c: c, messageType: messageType}
+		return c.writer, nil
+	}
+	return c.nextWriter(messageType)
+}
+
+func (c *Conn) nextWriter(messageType int) (io.WriteCloser, error) {
 	var mw messageWriter
 	if err := c.beginMessage(&mw, messageType); err != nil {
 		return nil, err
 	}
 	c.writer = &mw
-	if c.newCompressionWriter != nil && c.enableWriteCompression && isData(messageType) {
-		w := c.newCompressionWriter(c.writer, c.compressionLevel)
-		mw.compress = true
-		c.writer = w
+	if isData(messageType) {
+		for i := len(c.extensions) - 1; i >= 0; i-- {
+			var rsv byte
+			c.writer, rsv = c.extensions[i].NewWriter(c.writer, messageType)
+			mw.rsv |= rsv
+		}
+		if c.observer != nil {
+			c.writer = &observeWriter{c: c, messageType: messageType, w: c.writer}
+		}
 	}
 	return c.writer, nil
 }
 
 type messageWriter struct {
 	c         *Conn
-	compress  bool // whether next call to flushFrame should set RSV1
+	rsv       byte // RSV bits to set in the next call to flushFrame
 	pos       int  // end of data in writeBuf.
 	frameType int  // type of the current frame.
 	err       error
@@ -618,10 +715,8 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 	if final {
 		b0 |= finalBit
 	}
-	if w.compress {
-		b0 |= rsv1Bit
-	}
-	w.compress = false
+	b0 |= w.rsv
+	w.rsv = 0
 
 	b1 := byte(0)
 	if !c.isServer {
@@ -651,6 +746,11 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 		c.writeBuf[framePos+1] = b1 | byte(length)
 	}
 
+	var closePayload []byte
+	if w.frameType == CloseMessage && (c.observer != nil || c.trace != nil) {
+		closePayload = append(append([]byte(nil), c.writeBuf[maxFrameHeaderSize:w.pos]...), extra...)
+	}
+
 	if !c.isServer {
 		key := newMaskKey()
 		copy(c.writeBuf[maxFrameHeaderSize-4:], key[:])
@@ -679,6 +779,10 @@ func (w *messageWriter) flushFrame(final bool, extra []byte) error {
 	if err != nil {
 		return w.endMessage(err)
 	}
+	c.observeFrame(OutboundMessage, w.frameType, int64(length))
+	if closePayload != nil {
+		c.closeSent(closePayload)
+	}
 
 	if final {
 		_ = w.endMessage(errWriteClosed)
@@ -787,6 +891,18 @@ func (c *Conn) WritePreparedMessage(pm *PreparedMessage) error {
 	if c == nil {
 		return ErrNilConn
 	}
+	if c.writeQueue != nil {
+		return c.writeQueue.push(context.Background(), queuedMessage{messageType: pm.messageType, pm: pm})
+	}
+	return c.writePreparedMessage(pm)
+}
+
+func (c *Conn) writePreparedMessage(pm *PreparedMessage) error {
+	if c.intercepts(pm.messageType) || isData(pm.messageType) && (c.hasMessageTransform() || c.compressStateful && c.enableWriteCompression) {
+		// The frames depend on the interceptors or on the state of the
+		// connection's extensions and cannot be shared.
+		return c.writeMessage(pm.messageType, pm.data)
+	}
 	frameType, frameData, err := pm.frame(prepareKey{
 		isServer:         c.isServer,
 		compress:         c.newCompressionWriter != nil && c.enableWriteCompression && isData(pm.messageType),
@@ -804,6 +920,14 @@ func (c *Conn) WritePreparedMessage(pm *PreparedMessage) error {
 		panic("concurrent write to websocket connection")
 	}
 	c.isWriting = false
+	if err == nil {
+		c.observeFrame(OutboundMessage, frameType, framePayloadLength(frameData))
+		if isData(frameType) {
+			c.observeMessage(OutboundMessage, frameType, int64(len(pm.data)))
+		} else if frameType == CloseMessage {
+			c.closeSent(pm.data)
+		}
+	}
 	return err
 }
 
@@ -813,7 +937,24 @@ func (c *Conn) WriteMessage(messageType int, data []byte) error {
 	if c == nil {
 		return ErrNilConn
 	}
-	if c.isServer && (c.newCompressionWriter == nil || !c.enableWriteCompression) {
+	if c.writeQueue != nil {
+		return c.writeQueue.push(context.Background(), queuedMessage{messageType: messageType, data: append([]byte(nil), data...)})
+	}
+	return c.writeMessage(messageType, data)
+}
+
+func (c *Conn) writeMessage(messageType int, data []byte) error {
+	if c.intercepts(messageType) {
+		var err error
+		if data, err = c.intercept(OutboundMessage, messageType, data); err != nil {
+			if err == ErrDropMessage {
+				return nil
+			}
+			return c.rejectMessage(err)
+		}
+	}
+
+	if c.isServer && (c.newCompressionWriter == nil || !c.enableWriteCompression) && !c.hasMessageTransform() {
 		// Fast path with no allocations and single frame.
 
 		var mw messageWriter
@@ -822,11 +963,16 @@ func (c *Conn) WriteMessage(messageType int, data []byte) error {
 		}
 		n := copy(c.writeBuf[mw.pos:], data)
 		mw.pos += n
-		data = data[n:]
-		return mw.flushFrame(true, data)
+		if err := mw.flushFrame(true, data[n:]); err != nil {
+			return err
+		}
+		if isData(messageType) {
+			c.observeMessage(OutboundMessage, messageType, int64(len(data)))
+		}
+		return nil
 	}
 
-	w, err := c.NextWriter(messageType)
+	w, err := c.nextWriter(messageType)
 	if err != nil {
 		return err
 	}
@@ -872,26 +1018,22 @@ func (c *Conn) advanceFrame() (int, error) {
 
 	frameType := int(p[0] & 0xf)
 	final := p[0]&finalBit != 0
-	rsv1 := p[0]&rsv1Bit != 0
-	rsv2 := p[0]&rsv2Bit != 0
-	rsv3 := p[0]&rsv3Bit != 0
+	rsv := p[0] & (rsv1Bit | rsv2Bit | rsv3Bit)
+	c.readFrameRSV = rsv
 	mask := p[1]&maskBit != 0
 	_ = c.setReadRemaining(int64(p[1] & 0x7f)) // will not fail because argument is >= 0
 
-	c.readDecompress = false
-	if rsv1 {
-		if c.newDecompressionReader != nil {
-			c.readDecompress = true
-		} else {
-			errors = append(errors, "RSV1 set")
-		}
+	// RSV bits are allowed when claimed by a negotiated extension.
+	unclaimed := rsv &^ c.rsvMask
+	if unclaimed&rsv1Bit != 0 {
+		errors = append(errors, "RSV1 set")
 	}
 
-	if rsv2 {
+	if unclaimed&rsv2Bit != 0 {
 		errors = append(errors, "RSV2 set")
 	}
 
-	if rsv3 {
+	if unclaimed&rsv3Bit != 0 {
 		errors = append(errors, "RSV3 set")
 	}
 
@@ -908,6 +1050,7 @@ func (c *Conn) advanceFrame() (int, error) {
 			errors = append(errors, "data before FIN")
 		}
 		c.readFinal = final
+		c.readRSV = rsv
 	case continuationFrame:
 		if c.readFinal {
 			errors = append(errors, "continuation after FIN")
@@ -969,6 +1112,9 @@ func (c *Conn) advanceFrame() (int, error) {
 		copy(c.readMaskKey[:], p)
 	}
 
+	c.observeFrame(InboundMessage, frameType, c.readRemaining)
+	c.traceFrame(frameType)
+
 	// 5. For text and binary messages, enforce read limit and return.
 
 	if frameType == continuationFrame || frameType == TextMessage || frameType == BinaryMessage {
@@ -977,10 +1123,12 @@ func (c *Conn) advanceFrame() (int, error) {
 		// Don't allow readLength to overflow in the presence of a large readRemaining
 		// counter.
 		if c.readLength < 0 {
+			c.observeProtocolError(ErrReadLimit)
 			return noFrame, ErrReadLimit
 		}
 
 		if c.readLimit > 0 && c.readLength > c.readLimit {
+			c.observeProtocolError(ErrReadLimit)
 			// Make a best effort to send a close message describing the problem.
 			_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(writeWait))
 			return noFrame, ErrReadLimit
@@ -1003,10 +1151,26 @@ func (c *Conn) advanceFrame() (int, error) {
 		}
 	}
 
+	if c.interceptControl && !c.readingFrame {
+		payload, err = c.intercept(InboundMessage, frameType, payload)
+		if err == ErrDropMessage {
+			// Skip the handlers of the dropped frame.
+			c.readControl = nil
+			return frameType, nil
+		}
+		if err != nil {
+			return noFrame, c.rejectMessage(err)
+		}
+	}
+	c.readControl = payload
+
 	// 7. Process control frame payload.
 
 	switch frameType {
 	case PongMessage:
+		if c.keepAlive != nil {
+			c.keepAlive.handlePong(payload)
+		}
 		if err := c.handlePong(string(payload)); err != nil {
 			return noFrame, err
 		}
@@ -1027,6 +1191,7 @@ func (c *Conn) advanceFrame() (int, error) {
 				return noFrame, c.handleProtocolError("invalid utf8 payload in close frame")
 			}
 		}
+		c.closeReceived(closeCode, closeText)
 		if err := c.handleClose(closeCode, closeText); err != nil {
 			return noFrame, err
 		}
@@ -1044,9 +1209,11 @@ func (c *Conn) handleProtocolError(message string) error {
 	if len(data) > maxControlFramePayloadSize {
 		data = data[:maxControlFramePayloadSize]
 	}
+	err := errors.New("websocket: " + message)
+	c.observeProtocolError(err)
 	// Make a best effor to send a close message describing the problem.
 	_ = c.WriteControl(CloseMessage, data, time.Now().Add(writeWait))
-	return errors.New("websocket: " + message)
+	return err
 }
 
 // NextReader returns the next data message received from the peer. The
@@ -1075,15 +1242,37 @@ func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
 	for c.readErr == nil {
 		frameType, err := c.advanceFrame()
 		if err != nil {
-			c.readErr = err
+			c.readErr = c.keepAliveReadError(err)
 			break
 		}
 
 		if frameType == TextMessage || frameType == BinaryMessage {
 			c.messageReader = &messageReader{c}
 			c.reader = c.messageReader
-			if c.readDecompress {
-				c.reader = c.newDecompressionReader(c.reader)
+			for i := len(c.extensions) - 1; i >= 0; i-- {
+				c.reader = c.extensions[i].NewReader(c.reader, frameType, c.readRSV)
+			}
+			if frameType == TextMessage && !c.skipUTF8Validation {
+				c.reader = &utf8Reader{c: c, r: c.reader}
+			}
+			if c.observer != nil {
+				c.reader = &observeReader{c: c, messageType: frameType, r: c.reader}
+			}
+			if len(c.interceptors) > 0 {
+				r, err := c.readInterceptedMessage(frameType, c.reader)
+				c.reader.Close()
+				c.reader = nil
+				if err == ErrDropMessage {
+					c.readLength = 0
+					continue
+				}
+				if err != nil {
+					if c.readErr == nil {
+						c.readErr = err
+					}
+					break
+				}
+				return frameType, r, nil
 			}
 			return frameType, c.reader, nil
 		}
@@ -1118,7 +1307,9 @@ func (r *messageReader) Read(b []byte) (int, error) {
 				b = b[:c.readRemaining]
 			}
 			n, err := c.br.Read(b)
-			c.readErr = err
+			if err != nil {
+				c.readErr = c.keepAliveReadError(err)
+			}
 			if c.isServer {
 				c.readMaskPos = maskBytes(c.readMaskKey, c.readMaskPos, b[:n])
 			}
@@ -1182,6 +1373,7 @@ func (c *Conn) SetReadDeadline(t time.Time) error {
 	if c.conn == nil {
 		return ErrNilNetConn
 	}
+	c.readDeadline = t
 	return c.conn.SetReadDeadline(t)
 }
 
@@ -1319,7 +1511,10 @@ func (c *Conn) EnableWriteCompression(enable bool) {
 // SetCompressionLevel sets the flate compression level for subsequent text and
 // binary messages. This function is a noop if compression was not negotiated
 // with the peer. See the github.com/klauspost/compress/flate package for a description of
-// compression levels.
+// compression levels. With context takeover, the default level is 7, the
+// lowest level matching short messages against earlier messages. The lower
+// levels are faster but compress short messages without referring to earlier
+// messages.
 func (c *Conn) SetCompressionLevel(level int) error {
 	if c == nil {
 		return ErrNilConn
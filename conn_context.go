@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past used to abort blocking I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watchContext calls abort when ctx is done before the returned function is
+// called. The returned function reports whether abort was called.
+func watchContext(ctx context.Context, abort func()) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	var (
+		mu      sync.Mutex
+		active  = true
+		aborted bool
+	)
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if active {
+			aborted = true
+			abort()
+		}
+	})
+	return func() bool {
+		stop()
+		mu.Lock()
+		defer mu.Unlock()
+		active = false
+		return aborted
+	}
+}
+
+// NextReaderContext is like NextReader, but aborts waiting for the next
+// message when ctx is done. The context does not apply to reading the
+// message with the returned reader.
+//
+// If ctx is done before a message arrives, NextReaderContext returns the
+// context's error and the connection fails: subsequent reads return the same
+// error. The application should close the connection.
+func (c *Conn) NextReaderContext(ctx context.Context) (messageType int, r io.Reader, err error) {
+	if c == nil {
+		return noFrame, nil, ErrNilConn
+	}
+	if err := ctx.Err(); err != nil {
+		return noFrame, nil, err
+	}
+	stop := watchContext(ctx, func() {
+		_ = c.conn.SetReadDeadline(aLongTimeAgo)
+	})
+	messageType, r, err = c.NextReader()
+	if stop() {
+		if err != nil {
+			c.readErr = ctx.Err()
+			return noFrame, nil, c.readErr
+		}
+		// The message arrived before the read was aborted.
+		_ = c.conn.SetReadDeadline(c.readDeadline)
+	}
+	return messageType, r, err
+}
+
+// ReadMessageContext is like ReadMessage, but aborts reading when ctx is done.
+//
+// If ctx is done before the message is read, ReadMessageContext returns the
+// context's error and the connection fails: subsequent reads return the same
+// error. The application should close the connection.
+func (c *Conn) ReadMessageContext(ctx context.Context) (messageType int, p []byte, err error) {
+	if c == nil {
+		return noFrame, nil, ErrNilConn
+	}
+	if err := ctx.Err(); err != nil {
+		return noFrame, nil, err
+	}
+	stop := watchContext(ctx, func() {
+		_ = c.conn.SetReadDeadline(aLongTimeAgo)
+	})
+	messageType, p, err = c.ReadMessage()
+	if stop() {
+		if err != nil {
+			c.readErr = ctx.Err()
+			return noFrame, nil, c.readErr
+		}
+		_ = c.conn.SetReadDeadline(c.readDeadline)
+	}
+	return messageType, p, err
+}
+
+// WriteMessageContext is like WriteMessage, but aborts writing when ctx is
+// done.
+//
+// If ctx is done before the message is written, WriteMessageContext returns
+// the context's error and the connection fails: the message may be partially
+// written and subsequent writes return an error. The application should close
+// the connection. Control messages written concurrently with WriteControl are
+// not aborted.
+//
+// When the write queue is enabled, the context applies to waiting for room
+// in the queue.
+func (c *Conn) WriteMessageContext(ctx context.Context, messageType int, data []byte) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.writeQueue != nil {
+		return c.writeQueue.push(ctx, queuedMessage{messageType: messageType, data: append([]byte(nil), data...)})
+	}
+	stop := watchContext(ctx, c.cancelWrite)
+	err := c.writeMessage(messageType, data)
+	if stop() {
+		c.resetWriteCancel()
+		if err != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// cancelWrite aborts the message written by WriteMessageContext. The write
+// deadline is moved to the past only while a data frame is written, because
+// the write lock may be held by a concurrent WriteControl. The next data
+// frame fails otherwise.
+func (c *Conn) cancelWrite() {
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	c.writeCanceled = true
+	if c.writingData {
+		_ = c.conn.SetWriteDeadline(aLongTimeAgo)
+	}
+}
+
+func (c *Conn) resetWriteCancel() {
+	c.cancelMu.Lock()
+	c.writeCanceled = false
+	c.cancelMu.Unlock()
+}
+
+// beginDataWrite is called with the write lock held after the write deadline
+// of a data frame is set. It returns errWriteTimeout if the message was
+// aborted by cancelWrite.
+func (c *Conn) beginDataWrite() error {
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	if c.writeCanceled {
+		return errWriteTimeout
+	}
+	c.writingData = true
+	return nil
+}
+
+func (c *Conn) endDataWrite() {
+	c.cancelMu.Lock()
+	c.writingData = false
+	c.cancelMu.Unlock()
+}
+
+// WriteJSONContext is like WriteJSON, but aborts writing when ctx is done. See
+// WriteMessageContext for the state of the connection after an aborted write.
+func (c *Conn) WriteJSONContext(ctx context.Context, v interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return c.WriteMessageContext(ctx, TextMessage, buf.Bytes())
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadMessageContext(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+
+	go func() {
+		_ = s.WriteMessage(TextMessage, []byte("first"))
+		_ = s.WriteMessage(TextMessage, []byte("second"))
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, p, err := c.ReadMessageContext(ctx)
+	if err != nil || string(p) != "first" {
+		t.Fatalf("ReadMessageContext() = %q, %v, want first", p, err)
+	}
+	cancel()
+
+	// Cancelling the context after the read must not affect the connection.
+	_, p, err = c.ReadMessage()
+	if err != nil || string(p) != "second" {
+		t.Fatalf("ReadMessage() = %q, %v, want second", p, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.ReadMessageContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReadMessageContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, _, err := c.NextReader(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("NextReader() after abort returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNextReaderContextCanceled(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := c.NextReaderContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("NextReaderContext() returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWriteMessageContext(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var v string
+		if err := c.ReadJSON(&v); err != nil || v != "hello" {
+			t.Errorf("ReadJSON() = %q, %v, want hello", v, err)
+		}
+	}()
+	if err := s.WriteJSONContext(context.Background(), "hello"); err != nil {
+		t.Fatalf("WriteJSONContext() returned %v", err)
+	}
+	<-done
+
+	// Nobody reads the connection and the write blocks.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.WriteMessageContext(ctx, TextMessage, []byte("blocked")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WriteMessageContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := s.WriteMessage(TextMessage, []byte("after")); err == nil {
+		t.Fatal("WriteMessage() after abort returned nil error")
+	}
+}
+
+// writeSignalConn reports the first write to the network connection.
+type writeSignalConn struct {
+	net.Conn
+	once    sync.Once
+	writing chan struct{}
+}
+
+func (c *writeSignalConn) Write(p []byte) (int, error) {
+	c.once.Do(func() { close(c.writing) })
+	return c.Conn.Write(p)
+}
+
+func TestWriteMessageContextConcurrentControl(t *testing.T) {
+	p1, p2 := net.Pipe()
+	sc := &writeSignalConn{Conn: p1, writing: make(chan struct{})}
+	s := newConn(sc, true, 1024, 1024, nil, nil, nil)
+	c := newConn(p2, false, 1024, 1024, nil, nil, nil)
+	defer s.Close()
+	defer c.Close()
+
+	// The ping holds the write lock until the client reads it.
+	pingErr := make(chan error, 1)
+	go func() {
+		pingErr <- s.WriteControl(PingMessage, []byte("ping"), time.Now().Add(5*time.Second))
+	}()
+	<-sc.writing
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- s.WriteMessageContext(ctx, TextMessage, []byte("canceled"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	c.SetPingHandler(func(string) error { return nil })
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	if f, err := c.ReadFrame(); err != nil || f.Opcode != PingMessage {
+		t.Fatalf("ReadFrame() = %v, %v, want ping", f, err)
+	}
+	if err := <-pingErr; err != nil {
+		t.Errorf("WriteControl() concurrent with the canceled write returned %v", err)
+	}
+	if err := <-writeErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteMessageContext() returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWriteMessageContextQueue(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	s.EnableWriteQueue(1, OverflowBlock)
+
+	// The first message blocks the queue's goroutine, the second fills the
+	// queue.
+	for i := 0; i < 2; i++ {
+		if err := s.WriteMessageContext(context.Background(), TextMessage, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		for i == 0 && s.WriteQueueLen() != 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.WriteMessageContext(ctx, TextMessage, []byte("x")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WriteMessageContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
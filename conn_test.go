@@ -93,9 +93,11 @@ func TestFraming(t *testing.T) {
 				var connBuf bytes.Buffer
 				wc := newTestConn(nil, &connBuf, isServer)
 				rc := newTestConn(chunker.f(&connBuf), nil, !isServer)
+				// The text messages hold arbitrary bytes.
+				rc.EnableUTF8Validation(false)
 				if compress {
-					wc.newCompressionWriter = compressNoContextTakeover
-					rc.newDecompressionReader = decompressNoContextTakeover
+					wc.enableDeflate(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
+					rc.enableDeflate(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
 				}
 				for _, n := range frameSizes {
 					for _, writer := range writers {
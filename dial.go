@@ -0,0 +1,195 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Errors describing why a handshake response is not valid. A dialer returns
+// them as the Cause of a *BadHandshakeError.
+var (
+	ErrHandshakeStatus     = errors.New("websocket: bad handshake: unexpected status")
+	ErrHandshakeUpgrade    = errors.New("websocket: bad handshake: 'websocket' token not found in 'Upgrade' header")
+	ErrHandshakeConnection = errors.New("websocket: bad handshake: 'upgrade' token not found in 'Connection' header")
+	ErrHandshakeAccept     = errors.New("websocket: bad handshake: 'Sec-WebSocket-Accept' header does not match the challenge key")
+	ErrTooManyRedirects    = errors.New("websocket: bad handshake: too many redirects")
+)
+
+var errInsecureRedirect = errors.New("websocket: redirect from secure to insecure scheme")
+
+// maxHandshakeErrorBody is the maximum number of bytes of the response body
+// kept by a dialer when the handshake fails.
+const maxHandshakeErrorBody = 1024
+
+// BadHandshakeError is returned by Dialer and FastHTTPDialer when the server
+// response to the opening handshake is invalid. The error matches
+// ErrBadHandshake and its Cause with errors.Is:
+//
+//	var herr *websocket.BadHandshakeError
+//	if errors.As(err, &herr) && herr.StatusCode == http.StatusUnauthorized {
+//		// Authenticate and dial again.
+//	}
+type BadHandshakeError struct {
+	// Cause is the specific validation failure, such as ErrHandshakeStatus
+	// or ErrHandshakeAccept, the error from configuring the extensions
+	// selected by the server, or the reason a redirect response is not
+	// followed.
+	Cause error
+
+	// StatusCode is the HTTP status of the handshake response.
+	StatusCode int
+
+	// Header is the header of the handshake response.
+	Header http.Header
+
+	// Body holds at most the first 1024 bytes of the response body.
+	Body []byte
+}
+
+func (e *BadHandshakeError) Error() string {
+	msg := ErrBadHandshake.Error()
+	if e.Cause != nil {
+		msg = e.Cause.Error()
+	}
+	return msg + " (HTTP status " + strconv.Itoa(e.StatusCode) + ")"
+}
+
+// Unwrap returns ErrBadHandshake and the cause of the error.
+func (e *BadHandshakeError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{ErrBadHandshake}
+	}
+	return []error{ErrBadHandshake, e.Cause}
+}
+
+// checkHandshakeResponse returns an error describing why the handshake
+// response is not valid.
+func checkHandshakeResponse(status int, upgrade, connection bool, accept, challengeKey string) error {
+	switch {
+	case status != http.StatusSwitchingProtocols:
+		return ErrHandshakeStatus
+	case !upgrade:
+		return ErrHandshakeUpgrade
+	case !connection:
+		return ErrHandshakeConnection
+	case accept != computeAcceptKey(challengeKey):
+		return ErrHandshakeAccept
+	}
+	return nil
+}
+
+// observeBadHandshake reports a handshake error returned by a dialer.
+func observeBadHandshake(o Observer, err error) {
+	var herr *BadHandshakeError
+	if o != nil && errors.As(err, &herr) {
+		o.ObserveHandshakeError(herr.StatusCode, err)
+	}
+}
+
+// fastHTTPResponseHeader returns a copy of a fasthttp response header.
+func fastHTTPResponseHeader(h *fasthttp.ResponseHeader) http.Header {
+	header := make(http.Header)
+	h.VisitAll(func(k, v []byte) {
+		header.Add(string(k), string(v))
+	})
+	return header
+}
+
+// redirectLocation returns the Location header of a redirect response
+// returned as a handshake error.
+func redirectLocation(err error) (string, bool) {
+	herr, ok := err.(*BadHandshakeError)
+	if !ok || herr.Cause != ErrHandshakeStatus {
+		return "", false
+	}
+	switch herr.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return "", false
+	}
+	location := herr.Header.Get("Location")
+	return location, location != ""
+}
+
+// redirectError returns the error for a redirect response that is not
+// followed. The error keeps the response of err with cause as the Cause.
+func redirectError(err, cause error) error {
+	herr := *err.(*BadHandshakeError)
+	herr.Cause = cause
+	return &herr
+}
+
+// redirectURL resolves the location of a redirect response against the URL
+// of the request. The http and https schemes are mapped to ws and wss. A
+// redirect from a secure to an insecure scheme is refused.
+func redirectURL(from *url.URL, location string) (*url.URL, error) {
+	u, err := from.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return nil, errors.New("websocket: redirect to unsupported scheme " + u.Scheme)
+	}
+	if u.Scheme == "ws" && (from.Scheme == "wss" || from.Scheme == "https") {
+		// Following the redirect would send the request header in cleartext.
+		return nil, errInsecureRedirect
+	}
+	return u, nil
+}
+
+// redirectHeaders are the request headers removed when a redirect leads to
+// another host.
+var redirectHeaders = []string{"Authorization", "Cookie", "Cookie2", "Host", "Www-Authenticate"}
+
+// redirectRequest returns the URL and the request header of the handshake
+// following a redirect response.
+func redirectRequest(urlStr, location string, requestHeader http.Header) (string, http.Header, error) {
+	from, err := url.Parse(urlStr)
+	if err != nil {
+		return "", nil, err
+	}
+	to, err := redirectURL(from, location)
+	if err != nil {
+		return "", nil, err
+	}
+	if to.Host != from.Host && requestHeader != nil {
+		requestHeader = requestHeader.Clone()
+		for _, k := range redirectHeaders {
+			delete(requestHeader, k)
+		}
+	}
+	return to.String(), requestHeader, nil
+}
+
+// redirectFastHTTPRequest returns the URL and the request header of the
+// handshake following a redirect response.
+func redirectFastHTTPRequest(urlStr, location string, requestHeader *fasthttp.RequestHeader) (string, *fasthttp.RequestHeader, error) {
+	from, err := url.Parse(urlStr)
+	if err != nil {
+		return "", nil, err
+	}
+	to, err := redirectURL(from, location)
+	if err != nil {
+		return "", nil, err
+	}
+	if to.Host != from.Host && requestHeader != nil {
+		h := &fasthttp.RequestHeader{}
+		requestHeader.CopyTo(h)
+		for _, k := range redirectHeaders {
+			h.Del(k)
+		}
+		requestHeader = h
+	}
+	return to.String(), requestHeader, nil
+}
@@ -0,0 +1,213 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestBadHandshakeError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Www-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(strings.Repeat("x", 2000)))
+	}))
+	defer s.Close()
+
+	for _, dial := range []func() error{
+		func() error {
+			_, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+			return err
+		},
+		func() error {
+			_, resp, err := cstFastHTTPDialer.Dial(makeWsProto(s.URL), nil)
+			fasthttp.ReleaseResponse(resp)
+			return err
+		},
+	} {
+		err := dial()
+		var herr *BadHandshakeError
+		if !errors.As(err, &herr) {
+			t.Fatalf("Dial() returned %v, want *BadHandshakeError", err)
+		}
+		if !errors.Is(err, ErrBadHandshake) || !errors.Is(err, ErrHandshakeStatus) {
+			t.Errorf("Dial() returned %v, want ErrBadHandshake and ErrHandshakeStatus", err)
+		}
+		if herr.StatusCode != http.StatusUnauthorized || herr.Header.Get("Www-Authenticate") != "Bearer" {
+			t.Errorf("error = %d %v, want 401 with Www-Authenticate", herr.StatusCode, herr.Header)
+		}
+		if len(herr.Body) != maxHandshakeErrorBody || herr.Body[0] != 'x' {
+			t.Errorf("len(Body) = %d, want %d", len(herr.Body), maxHandshakeErrorBody)
+		}
+	}
+}
+
+func TestBadHandshakeErrorAccept(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "upgrade")
+		w.Header().Set("Sec-Websocket-Accept", "bad")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	defer s.Close()
+
+	_, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if !errors.Is(err, ErrHandshakeAccept) {
+		t.Fatalf("Dial() returned %v, want %v", err, ErrHandshakeAccept)
+	}
+	if want := ErrHandshakeAccept.Error() + " (HTTP status 101)"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestDialRedirect(t *testing.T) {
+	auth := make(chan string, 1)
+	upgrader := Upgrader{}
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth <- r.Header.Get("Authorization")
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	defer target.Close()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/ws", http.StatusFound)
+		case "/ws":
+			auth <- r.Header.Get("Authorization")
+			if ws, err := upgrader.Upgrade(w, r, nil); err == nil {
+				ws.Close()
+			}
+		default:
+			http.Redirect(w, r, target.URL+"/ws", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer s.Close()
+
+	header := http.Header{"Authorization": {"Bearer t"}}
+	dialer := cstDialer
+	dialer.MaxRedirects = 1
+
+	ws, _, err := dialer.Dial(makeWsProto(s.URL)+"/same", header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+	if got := <-auth; got != "Bearer t" {
+		t.Errorf("Authorization = %q after redirect to same host, want Bearer t", got)
+	}
+
+	ws, _, err = dialer.Dial(makeWsProto(s.URL)+"/other", header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ws.Close()
+	if got := <-auth; got != "" {
+		t.Errorf("Authorization = %q after redirect to other host, want none", got)
+	}
+
+	fastDialer := cstFastHTTPDialer
+	fastDialer.MaxRedirects = 1
+	ws, resp, err := fastDialer.Dial(makeWsProto(s.URL)+"/other", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	fasthttp.ReleaseResponse(resp)
+	ws.Close()
+	<-auth
+}
+
+func TestDialTooManyRedirects(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+	}))
+	defer s.Close()
+
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL)+"/", nil)
+	if !errors.Is(err, ErrHandshakeStatus) || resp == nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("Dial() without redirects returned %v, want %v with status 302", err, ErrHandshakeStatus)
+	}
+
+	dialer := cstDialer
+	dialer.MaxRedirects = 2
+	_, resp, err = dialer.Dial(makeWsProto(s.URL)+"/", nil)
+	if !errors.Is(err, ErrTooManyRedirects) || resp == nil || resp.Request.URL.Path != "/xx" {
+		t.Fatalf("Dial() returned %v, want %v after /xx", err, ErrTooManyRedirects)
+	}
+
+	fastDialer := cstFastHTTPDialer
+	fastDialer.MaxRedirects = 2
+	_, fastResp, err := fastDialer.Dial(makeWsProto(s.URL)+"/", nil)
+	if !errors.Is(err, ErrTooManyRedirects) || string(fastResp.Header.Peek("Location")) != "/xxx" {
+		t.Fatalf("Dial() returned %v, want %v", err, ErrTooManyRedirects)
+	}
+	fasthttp.ReleaseResponse(fastResp)
+}
+
+func TestDialRedirectObserved(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/insecure" {
+			http.Redirect(w, r, "ws://"+r.Host+"/", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+	}))
+	defer s.Close()
+	tlsConfig := s.Client().Transport.(*http.Transport).TLSClientConfig
+
+	for path, want := range map[string]error{"/insecure": errInsecureRedirect, "/": ErrTooManyRedirects} {
+		var m Metrics
+		dialer := cstDialer
+		dialer.TLSClientConfig = tlsConfig
+		dialer.MaxRedirects = 1
+		dialer.Observer = &m
+		if _, _, err := dialer.Dial(makeWsProto(s.URL)+path, nil); !errors.Is(err, want) || !errors.Is(err, ErrBadHandshake) {
+			t.Errorf("%s: Dial() returned %v, want %v", path, err, want)
+		}
+
+		fastDialer := cstFastHTTPDialer
+		fastDialer.TLSClientConfig = tlsConfig
+		fastDialer.MaxRedirects = 1
+		fastDialer.Observer = &m
+		_, resp, err := fastDialer.Dial(makeWsProto(s.URL)+path, nil)
+		if !errors.Is(err, want) || !errors.Is(err, ErrBadHandshake) {
+			t.Errorf("%s: FastHTTPDialer.Dial() returned %v, want %v", path, err, want)
+		}
+		fasthttp.ReleaseResponse(resp)
+
+		if got := m.HandshakeErrors.Get("302"); got == nil || got.String() != "2" {
+			t.Errorf("%s: HandshakeErrors[302] = %v, want 2", path, got)
+		}
+	}
+}
+
+func TestRedirectRequestDowngrade(t *testing.T) {
+	header := http.Header{"Authorization": {"Bearer t"}}
+	tests := []struct {
+		from, location string
+		ok             bool
+	}{
+		{"wss://example.com/a", "/b", true},
+		{"wss://example.com/a", "https://example.com/b", true},
+		{"ws://example.com/a", "wss://example.com/b", true},
+		{"wss://example.com/a", "ws://example.com/b", false},
+		{"wss://example.com/a", "http://example.com/b", false},
+	}
+	for _, tt := range tests {
+		_, _, err := redirectRequest(tt.from, tt.location, header)
+		if (err == nil) != tt.ok {
+			t.Errorf("redirectRequest(%s, %s) returned %v, want ok %v", tt.from, tt.location, err, tt.ok)
+		}
+		_, _, err = redirectFastHTTPRequest(tt.from, tt.location, nil)
+		if (err == nil) != tt.ok {
+			t.Errorf("redirectFastHTTPRequest(%s, %s) returned %v, want ok %v", tt.from, tt.location, err, tt.ok)
+		}
+	}
+}
@@ -96,8 +96,16 @@
 // return the type of the received message. The messageType argument to the
 // WriteMessage and NextWriter methods specifies the type of a sent message.
 //
-// It is the application's responsibility to ensure that text messages are
-// valid UTF-8 encoded text.
+// It is the application's responsibility to ensure that sent text messages
+// are valid UTF-8 encoded text. Received text messages are validated as they
+// are read. A connection receiving invalid UTF-8 text sends a close message
+// with CloseInvalidFramePayloadData and fails. Call the connection
+// EnableUTF8Validation method to disable validation for trusted peers.
+//
+// The Interceptors field of Upgrader, FastHTTPUpgrader, Dialer and
+// FastHTTPDialer registers functions called with every message sent or
+// received on the connection. Interceptors log, measure, rewrite, drop or
+// reject messages. An interceptor buffers the complete message.
 //
 // Control Messages
 //
@@ -110,6 +118,10 @@
 // NextReader, ReadMessage or the message Read method. The default close
 // handler sends a close message to the peer.
 //
+// Call the connection CloseGracefully or CloseGracefullyContext method to
+// send a close message, wait for the peer's close message and close the
+// network connection.
+//
 // Connections handle received ping messages by calling the handler function
 // set with the SetPingHandler method. The default ping handler sends a pong
 // message to the peer.
@@ -119,6 +131,12 @@
 // If an application sends ping messages, then the application should set a
 // pong handler to receive the corresponding pong.
 //
+// Set the KeepAlive field of Upgrader, FastHTTPUpgrader or Dialer to have the
+// connection send pings at a regular interval. When the peer does not answer a
+// ping in time, the connection is closed and the read methods return
+// ErrKeepAliveTimeout. The RTT method returns the round-trip time measured by
+// the last ping. The keepalive does not change the pong handler.
+//
 // The control message handler functions are called from the NextReader,
 // ReadMessage and message reader Read methods. The default close and ping
 // handlers can block these methods for a short time when the handler writes to
@@ -152,6 +170,11 @@
 // The Close and WriteControl methods can be called concurrently with all other
 // methods.
 //
+// Call the connection EnableWriteQueue method to allow many goroutines to call
+// WriteMessage, WriteJSON and WritePreparedMessage concurrently. The messages
+// are written in order from a bounded queue. The OverflowPolicy given to
+// EnableWriteQueue specifies what happens when the queue is full.
+//
 // Origin Considerations
 //
 // Web browsers allow Javascript applications to open a WebSocket connection to
@@ -237,10 +260,28 @@
 //
 //  conn.EnableWriteCompression(false)
 //
-// Currently this package does not support compression with "context takeover".
-// This means that messages must be compressed and decompressed in isolation,
-// without retaining sliding window or dictionary state across messages. For
-// more details refer to RFC 7692.
+// By default, messages are compressed and decompressed in isolation, without
+// retaining sliding window or dictionary state across messages. Set the
+// EnableContextTakeover option in Dialer or Upgrader to negotiate "context
+// takeover". With context takeover, the connection keeps a sliding window
+// across messages. This improves compression of small, repetitive messages,
+// but the connection holds the compressor and decompressor state for its
+// lifetime. For more details refer to RFC 7692.
+//
+// With context takeover, the default compression level is 7 because the
+// faster levels do not match short messages against earlier messages. A level
+// set with SetCompressionLevel is used as is. When the peer limits the size of
+// the sliding window, messages that fit in the window are buffered before
+// compression.
 //
 // Use of compression is experimental and may result in decreased performance.
+//
+// Extensions
+//
+// Applications can implement other WebSocket extensions (RFC 6455, section 9)
+// with the Extension interface. Set the Extensions field in Dialer or Upgrader
+// to negotiate the extensions with the peer. A negotiated extension
+// transforms the payload of text and binary messages and claims the RSV bits
+// it sets in frame headers. The permessage-deflate extension enabled by the
+// EnableCompression option is implemented with the same interface.
 package websocket
@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The RSV bits of the first byte of a frame header. An extension claims the
+// bits it uses with the RSV method of NegotiatedExtension.
+const (
+	RSV1 = rsv1Bit
+	RSV2 = rsv2Bit
+	RSV3 = rsv3Bit
+)
+
+// ExtensionParams holds the parameters of an extension in a
+// Sec-WebSocket-Extensions header. A parameter without a value maps to the
+// empty string.
+type ExtensionParams map[string]string
+
+// Extension is a WebSocket extension as described in RFC 6455, section 9.
+//
+// Extensions are configured with the Extensions field of Dialer, Upgrader and
+// FastHTTPUpgrader. The methods of an Extension are called concurrently for
+// different handshakes.
+type Extension interface {
+	// Name returns the extension token used in the Sec-WebSocket-Extensions
+	// header.
+	Name() string
+
+	// Offer returns the parameters of the extension offered by a client.
+	Offer() ExtensionParams
+
+	// Accept is called by a server for each offer of the extension in the
+	// order of the client's preference. Accept returns the parameters to send
+	// in the response and the extension to use on the connection. Return ok
+	// false to decline the offer.
+	Accept(offer ExtensionParams) (response ExtensionParams, ext NegotiatedExtension, ok bool)
+
+	// Configure is called by a client with the parameters of the server's
+	// response. Configure returns the extension to use on the connection. An
+	// error fails the handshake.
+	Configure(response ExtensionParams) (NegotiatedExtension, error)
+}
+
+// NegotiatedExtension transforms the messages of a single connection.
+//
+// Extensions are applied to outgoing messages in the order listed in the
+// server's handshake response and to incoming messages in the reverse order.
+type NegotiatedExtension interface {
+	// RSV returns the RSV bits used by the extension. The connection fails
+	// with a protocol error when the peer sets RSV bits not claimed by a
+	// negotiated extension. Two extensions cannot claim the same bits.
+	RSV() byte
+
+	// NewWriter returns a writer that transforms an outgoing text or binary
+	// message and writes the result to w. The writer's Close method must
+	// close w. NewWriter also returns the RSV bits to set in the first frame
+	// of the message.
+	NewWriter(w io.WriteCloser, messageType int) (io.WriteCloser, byte)
+
+	// NewReader returns a reader that transforms an incoming text or binary
+	// message read from r. The rsv argument holds the RSV bits of the first
+	// frame of the message.
+	NewReader(r io.Reader, messageType int, rsv byte) io.ReadCloser
+}
+
+var (
+	errUnexpectedExtension  = errors.New("websocket: server selected an extension that was not offered")
+	errExtensionRSVConflict = errors.New("websocket: negotiated extensions use the same RSV bits")
+)
+
+// formatExtension formats an extension as an element of a
+// Sec-WebSocket-Extensions header.
+func formatExtension(name string, params ExtensionParams) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteString("; ")
+		b.WriteString(k)
+		if v := params[k]; v != "" {
+			b.WriteByte('=')
+			writeTokenOrQuoted(&b, v)
+		}
+	}
+	return b.String()
+}
+
+// writeTokenOrQuoted writes v as a token or as a quoted string per RFC 2616.
+func writeTokenOrQuoted(b *strings.Builder, v string) {
+	for i := 0; i < len(v); i++ {
+		if !isTokenOctet[v[i]] {
+			b.WriteByte('"')
+			for j := 0; j < len(v); j++ {
+				if v[j] == '"' || v[j] == '\\' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(v[j])
+			}
+			b.WriteByte('"')
+			return
+		}
+	}
+	b.WriteString(v)
+}
+
+// extensionParams returns the parameters of an extension parsed by
+// parseExtensions.
+func extensionParams(ext map[string]string) ExtensionParams {
+	params := make(ExtensionParams, len(ext))
+	for k, v := range ext {
+		if k != "" {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+// acceptExtensions accepts the offers of a client in the order of the
+// client's preference. The function returns the Sec-WebSocket-Extensions
+// response header and the negotiated extensions.
+func acceptExtensions(offers []map[string]string, extensions []Extension) (string, []NegotiatedExtension) {
+	if len(extensions) == 0 {
+		return "", nil
+	}
+
+	var (
+		response   []string
+		negotiated []NegotiatedExtension
+		accepted   = make([]bool, len(extensions))
+		rsv        byte
+	)
+	for _, offer := range offers {
+		for i, e := range extensions {
+			if accepted[i] || e.Name() != offer[""] {
+				continue
+			}
+			params, ne, ok := e.Accept(extensionParams(offer))
+			if !ok || ne.RSV()&rsv != 0 {
+				continue
+			}
+			accepted[i] = true
+			rsv |= ne.RSV()
+			response = append(response, formatExtension(offer[""], params))
+			negotiated = append(negotiated, ne)
+			break
+		}
+	}
+	return strings.Join(response, ", "), negotiated
+}
+
+// offerExtensions returns the Sec-WebSocket-Extensions request header for the
+// extensions offered by a client.
+func offerExtensions(extensions []Extension) string {
+	offers := make([]string, len(extensions))
+	for i, e := range extensions {
+		offers[i] = formatExtension(e.Name(), e.Offer())
+	}
+	return strings.Join(offers, ", ")
+}
+
+// configureExtensions configures the extensions selected by a server.
+func configureExtensions(responses []map[string]string, extensions []Extension) ([]NegotiatedExtension, error) {
+	var (
+		negotiated []NegotiatedExtension
+		configured = make([]bool, len(extensions))
+		rsv        byte
+	)
+	for _, response := range responses {
+		i := 0
+		for ; i < len(extensions); i++ {
+			if !configured[i] && extensions[i].Name() == response[""] {
+				break
+			}
+		}
+		if i == len(extensions) {
+			return nil, errUnexpectedExtension
+		}
+		configured[i] = true
+		ne, err := extensions[i].Configure(extensionParams(response))
+		if err != nil {
+			return nil, err
+		}
+		if ne.RSV()&rsv != 0 {
+			return nil, errExtensionRSVConflict
+		}
+		rsv |= ne.RSV()
+		negotiated = append(negotiated, ne)
+	}
+	return negotiated, nil
+}
+
+// setExtensions installs the negotiated extensions on the connection.
+func (c *Conn) setExtensions(extensions []NegotiatedExtension) {
+	for _, e := range extensions {
+		if d, ok := e.(*deflateExtension); ok {
+			c.enableDeflate(d.params)
+			continue
+		}
+		c.extensions = append(c.extensions, e)
+		c.rsvMask |= e.RSV()
+	}
+}
+
+// hasMessageTransform returns true if a negotiated extension other than
+// permessage-deflate transforms messages.
+func (c *Conn) hasMessageTransform() bool {
+	for _, e := range c.extensions {
+		if _, ok := e.(*deflateExtension); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// perMessageDeflate is the permessage-deflate extension defined in RFC 7692.
+type perMessageDeflate struct {
+	contextTakeover bool
+}
+
+func (perMessageDeflate) Name() string { return "permessage-deflate" }
+
+func (e perMessageDeflate) Offer() ExtensionParams {
+	return deflateOffer(e.contextTakeover)
+}
+
+func (e perMessageDeflate) Accept(offer ExtensionParams) (ExtensionParams, NegotiatedExtension, bool) {
+	p, response, ok := acceptDeflateOffer(offer, e.contextTakeover)
+	if !ok {
+		return nil, nil, false
+	}
+	return response, &deflateExtension{params: p}, true
+}
+
+func (e perMessageDeflate) Configure(response ExtensionParams) (NegotiatedExtension, error) {
+	p, err := checkDeflateResponse(response, e.contextTakeover)
+	if err != nil {
+		return nil, err
+	}
+	return &deflateExtension{params: p}, nil
+}
+
+// deflateExtension compresses the messages of a connection with the
+// permessage-deflate parameters negotiated in the handshake.
+type deflateExtension struct {
+	params deflateParams
+	c      *Conn
+}
+
+func (e *deflateExtension) RSV() byte { return rsv1Bit }
+
+func (e *deflateExtension) NewWriter(w io.WriteCloser, messageType int) (io.WriteCloser, byte) {
+	c := e.c
+	if !c.enableWriteCompression || c.newCompressionWriter == nil {
+		return w, 0
+	}
+	return c.newCompressionWriter(w, c.compressionLevel), rsv1Bit
+}
+
+func (e *deflateExtension) NewReader(r io.Reader, messageType int, rsv byte) io.ReadCloser {
+	if rsv&rsv1Bit == 0 || e.c.newDecompressionReader == nil {
+		if rc, ok := r.(io.ReadCloser); ok {
+			return rc
+		}
+		return io.NopCloser(r)
+	}
+	return e.c.newDecompressionReader(r)
+}
+
+// withCompression returns the extensions configured on an upgrader or dialer
+// with permessage-deflate prepended when compression is enabled.
+func withCompression(enableCompression, contextTakeover bool, extensions []Extension) []Extension {
+	if !enableCompression {
+		return extensions
+	}
+	result := make([]Extension, 0, len(extensions)+1)
+	result = append(result, perMessageDeflate{contextTakeover: contextTakeover})
+	return append(result, extensions...)
+}
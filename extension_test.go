@@ -0,0 +1,242 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// xorExtension is a test extension that XORs message payloads with a key.
+type xorExtension struct {
+	key byte
+}
+
+func (xorExtension) Name() string { return "x-xor" }
+
+func (e xorExtension) Offer() ExtensionParams {
+	return ExtensionParams{"key": strconv.Itoa(int(e.key))}
+}
+
+func (e xorExtension) Accept(offer ExtensionParams) (ExtensionParams, NegotiatedExtension, bool) {
+	key, err := strconv.Atoi(offer["key"])
+	if err != nil || key == 0 || key > 255 {
+		return nil, nil, false
+	}
+	return ExtensionParams{"key": offer["key"]}, &xorConn{key: byte(key)}, true
+}
+
+func (e xorExtension) Configure(response ExtensionParams) (NegotiatedExtension, error) {
+	if response["key"] != strconv.Itoa(int(e.key)) {
+		return nil, errUnexpectedExtension
+	}
+	return &xorConn{key: e.key}, nil
+}
+
+type xorConn struct {
+	key byte
+}
+
+func (x *xorConn) RSV() byte { return RSV2 }
+
+func (x *xorConn) NewWriter(w io.WriteCloser, messageType int) (io.WriteCloser, byte) {
+	return &xorWriter{w: w, key: x.key}, RSV2
+}
+
+func (x *xorConn) NewReader(r io.Reader, messageType int, rsv byte) io.ReadCloser {
+	if rsv&RSV2 == 0 {
+		return io.NopCloser(r)
+	}
+	return io.NopCloser(&xorReader{r: r, key: x.key})
+}
+
+type xorWriter struct {
+	w   io.WriteCloser
+	key byte
+}
+
+func (w *xorWriter) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i, b := range p {
+		q[i] = b ^ w.key
+	}
+	return w.w.Write(q)
+}
+
+func (w *xorWriter) Close() error { return w.w.Close() }
+
+type xorReader struct {
+	r   io.Reader
+	key byte
+}
+
+func (r *xorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= r.key
+	}
+	return n, err
+}
+
+func TestFormatExtension(t *testing.T) {
+	tests := []struct {
+		params ExtensionParams
+		want   string
+	}{
+		{nil, "x"},
+		{ExtensionParams{"b": "", "a": "1"}, "x; a=1; b"},
+		{ExtensionParams{"a": "x y"}, `x; a="x y"`},
+		{ExtensionParams{"a": `"\`}, `x; a="\"\\"`},
+	}
+	for _, tt := range tests {
+		got := formatExtension("x", tt.params)
+		if got != tt.want {
+			t.Errorf("formatExtension(%v) = %q, want %q", tt.params, got, tt.want)
+		}
+		h := http.Header{"Sec-Websocket-Extensions": {got}}
+		ext := parseExtensions(h)
+		if tt.params == nil {
+			tt.params = ExtensionParams{}
+		}
+		if len(ext) != 1 || !reflect.DeepEqual(extensionParams(ext[0]), tt.params) {
+			t.Errorf("parseExtensions(%q) = %v, want %v", got, ext, tt.params)
+		}
+	}
+}
+
+func TestAcceptExtensions(t *testing.T) {
+	extensions := []Extension{perMessageDeflate{}, xorExtension{}}
+	h := http.Header{"Sec-Websocket-Extensions": {"x-xor; key=0, x-unknown, x-xor; key=7, permessage-deflate; client_max_window_bits, x-xor; key=8"}}
+	header, negotiated := acceptExtensions(parseExtensions(h), extensions)
+	if want := "x-xor; key=7, permessage-deflate; client_no_context_takeover; server_no_context_takeover"; header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	if len(negotiated) != 2 {
+		t.Fatalf("len(negotiated) = %d, want 2", len(negotiated))
+	}
+	if x, ok := negotiated[0].(*xorConn); !ok || x.key != 7 {
+		t.Errorf("negotiated[0] = %#v, want key 7", negotiated[0])
+	}
+}
+
+func TestConfigureExtensions(t *testing.T) {
+	extensions := []Extension{perMessageDeflate{}, xorExtension{key: 7}}
+	tests := []struct {
+		header string
+		err    bool
+	}{
+		{"", false},
+		{"x-xor; key=7", false},
+		{"x-xor; key=7, permessage-deflate; server_no_context_takeover; client_no_context_takeover", false},
+		{"x-xor; key=8", true},
+		{"x-unknown", true},
+		{"x-xor; key=7, x-xor; key=7", true},
+		{"permessage-deflate", true},
+	}
+	for _, tt := range tests {
+		h := http.Header{"Sec-Websocket-Extensions": {tt.header}}
+		_, err := configureExtensions(parseExtensions(h), extensions)
+		if (err != nil) != tt.err {
+			t.Errorf("configureExtensions(%q) returned %v, want error %v", tt.header, err, tt.err)
+		}
+	}
+}
+
+func TestExtensionFraming(t *testing.T) {
+	messages := jsonMessages(10)
+	for _, isServer := range []bool{true, false} {
+		var connBuf bytes.Buffer
+		wc := newTestConn(nil, &connBuf, isServer)
+		rc := newTestConn(&connBuf, nil, !isServer)
+		for _, c := range []*Conn{wc, rc} {
+			c.setExtensions([]NegotiatedExtension{
+				&xorConn{key: 0x55},
+				&deflateExtension{params: deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true}},
+			})
+		}
+
+		for i, message := range messages {
+			wc.EnableWriteCompression(i%2 == 0)
+			if err := wc.WriteMessage(BinaryMessage, message); err != nil {
+				t.Fatalf("WriteMessage() returned %v", err)
+			}
+			if connBuf.Bytes()[0]&RSV2 == 0 {
+				t.Fatalf("RSV2 not set")
+			}
+			if got := connBuf.Bytes()[0]&RSV1 != 0; got != (i%2 == 0) {
+				t.Fatalf("RSV1 set = %v, want %v", got, i%2 == 0)
+			}
+			_, p, err := rc.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() returned %v", err)
+			}
+			if !bytes.Equal(p, message) {
+				t.Fatalf("ReadMessage() = %q, want %q", p, message)
+			}
+		}
+
+		pm, err := NewPreparedMessage(TextMessage, messages[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := wc.WritePreparedMessage(pm); err != nil {
+			t.Fatalf("WritePreparedMessage() returned %v", err)
+		}
+		_, p, err := rc.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() returned %v", err)
+		}
+		if !bytes.Equal(p, messages[0]) {
+			t.Fatalf("ReadMessage() = %q, want %q", p, messages[0])
+		}
+	}
+}
+
+func TestUnclaimedRSV(t *testing.T) {
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, true)
+	wc.setExtensions([]NegotiatedExtension{&xorConn{key: 1}})
+	if err := wc.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	rc := newTestConn(&connBuf, io.Discard, false)
+	if _, _, err := rc.NextReader(); err == nil || err.Error() != "websocket: RSV2 set" {
+		t.Errorf("NextReader() returned %v, want RSV2 error", err)
+	}
+}
+
+func TestDialExtensions(t *testing.T) {
+	upgrader := Upgrader{EnableCompression: true, Extensions: []Extension{xorExtension{}}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("Upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		op, p, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = ws.WriteMessage(op, p)
+	}))
+	defer s.Close()
+
+	dialer := cstDialer
+	dialer.EnableCompression = true
+	dialer.Extensions = []Extension{xorExtension{key: 42}}
+	ws, resp, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	want := "permessage-deflate; client_no_context_takeover; server_no_context_takeover, x-xor; key=42"
+	if got := resp.Header.Get("Sec-Websocket-Extensions"); got != want {
+		t.Errorf("Sec-WebSocket-Extensions = %q, want %q", got, want)
+	}
+	sendRecv(t, ws)
+}
@@ -0,0 +1,335 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ContinuationFrame denotes a frame continuing a fragmented text or binary
+// message. It is used with ReadFrame and WriteFrame.
+const ContinuationFrame = continuationFrame
+
+var (
+	errBadFrame        = errors.New("websocket: bad frame")
+	errWriteFrameQueue = errors.New("websocket: data frame written with the write queue enabled")
+)
+
+// Frame is a single WebSocket frame as described in RFC 6455, section 5.2.
+type Frame struct {
+	// Opcode is one of ContinuationFrame, TextMessage, BinaryMessage,
+	// CloseMessage, PingMessage or PongMessage.
+	Opcode int
+
+	// Fin is true for the final frame of a message.
+	Fin bool
+
+	// RSV holds the RSV1, RSV2 and RSV3 bits of the frame.
+	RSV byte
+
+	// Payload is the unmasked application data of the frame.
+	Payload []byte
+}
+
+// ReadFrame reads the next frame from the connection.
+//
+// ReadFrame checks the frame like NextReader does: RSV bits must be claimed
+// by a negotiated extension, control frames must be final and short, the
+// frames of a fragmented message must be in sequence and the size of a
+// message is limited by SetReadLimit. Received control frames are passed to
+// the ping, pong and close handlers and returned to the caller. After a close
+// frame is returned, ReadFrame returns a *CloseError.
+//
+// The payload of a data frame is returned as received: extensions such as
+// permessage-deflate are not applied, text is not validated as UTF-8 and the
+// interceptors are not called.
+//
+// The application must not call ReadFrame while reading a message returned
+// by NextReader.
+func (c *Conn) ReadFrame() (Frame, error) {
+	f, err := c.nextFrame()
+	if err != nil || isControl(f.Opcode) {
+		return f, err
+	}
+	f.Payload, err = c.readFramePayload()
+	if err != nil {
+		return Frame{}, err
+	}
+	return f, nil
+}
+
+// readFramePayload reads the payload of the data frame returned by
+// nextFrame.
+func (c *Conn) readFramePayload() ([]byte, error) {
+	p, err := io.ReadAll(io.LimitReader(c.br, c.readRemaining))
+	if err == nil && int64(len(p)) < c.readRemaining {
+		err = errUnexpectedEOF
+	}
+	if err != nil {
+		c.readErr = c.keepAliveReadError(err)
+		return nil, c.readErr
+	}
+	_ = c.setReadRemaining(0) // will not fail because argument is >= 0
+	if c.isServer {
+		maskBytes(c.readMaskKey, 0, p)
+	}
+	return p, nil
+}
+
+// nextFrame reads the header of the next frame. The payload of a data frame
+// remains to be read from c.br up to c.readRemaining.
+func (c *Conn) nextFrame() (Frame, error) {
+	if c == nil {
+		return Frame{}, ErrNilConn
+	}
+	if c.readErr != nil {
+		return Frame{}, c.readErr
+	}
+	if c.reader != nil {
+		c.reader.Close()
+		c.reader = nil
+	}
+	c.messageReader = nil
+	if c.readFinal {
+		c.readLength = 0
+	}
+
+	c.readingFrame = true
+	frameType, err := c.advanceFrame()
+	c.readingFrame = false
+	if err != nil {
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) {
+			c.readErr = err
+			return Frame{
+				Opcode:  CloseMessage,
+				Fin:     true,
+				Payload: append([]byte(nil), c.readControl...),
+			}, nil
+		}
+		c.readErr = c.keepAliveReadError(err)
+		return Frame{}, c.readErr
+	}
+
+	f := Frame{Opcode: frameType, Fin: true, RSV: c.readFrameRSV}
+	if isControl(frameType) {
+		f.Payload = append([]byte(nil), c.readControl...)
+		return f, nil
+	}
+	f.Fin = c.readFinal
+	return f, nil
+}
+
+// forwardFrame reads the next frame and writes it to dst. The payload of a
+// data frame is copied from the connection to dst without holding the frame
+// in memory.
+func (c *Conn) forwardFrame(dst *Conn) error {
+	f, err := c.nextFrame()
+	if err != nil {
+		return err
+	}
+	if isControl(f.Opcode) {
+		return dst.WriteFrame(f)
+	}
+	r := &framePayloadReader{c: c}
+	err = dst.copyFrame(f, c.readRemaining, r)
+	if r.err != nil {
+		c.readErr = c.keepAliveReadError(r.err)
+		return c.readErr
+	}
+	return err
+}
+
+// framePayloadReader reads the unmasked payload of the current frame.
+type framePayloadReader struct {
+	c   *Conn
+	err error
+}
+
+func (r *framePayloadReader) Read(p []byte) (int, error) {
+	c := r.c
+	if c.readRemaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.readRemaining {
+		p = p[:c.readRemaining]
+	}
+	n, err := c.br.Read(p)
+	if c.isServer {
+		c.readMaskPos = maskBytes(c.readMaskKey, c.readMaskPos, p[:n])
+	}
+	_ = c.setReadRemaining(c.readRemaining - int64(n)) // will not fail because n <= c.readRemaining
+	if err == io.EOF && c.readRemaining > 0 {
+		err = errUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// frameCopyBufferPool holds the buffers of copyFrame.
+var frameCopyBufferPool = sync.Pool{New: func() interface{} {
+	b := make([]byte, 32<<10)
+	return &b
+}}
+
+// WriteFrame writes a single frame to the connection. The frame is masked
+// when the connection is a client.
+//
+// WriteFrame checks that the opcode is valid, that control frames are final
+// and that their payload is at most 125 bytes. WriteFrame does not check the
+// sequence of frames or the RSV bits, which allows applications to implement
+// their own fragmentation strategy and to test peers. The payload is written
+// as given: extensions such as permessage-deflate are not applied and the
+// interceptors are not called.
+//
+// The application must not call WriteFrame while writing a message returned
+// by NextWriter. WriteFrame returns an error for data frames when the write
+// queue is enabled with EnableWriteQueue, because the frames of a message
+// could be interleaved with queued messages. Control frames can be written
+// concurrently with other methods like WriteControl.
+func (c *Conn) WriteFrame(f Frame) error {
+	if c == nil {
+		return ErrNilConn
+	}
+	switch f.Opcode {
+	case ContinuationFrame, TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		if !f.Fin || len(f.Payload) > maxControlFramePayloadSize {
+			return errInvalidControlFrame
+		}
+	default:
+		return errBadWriteOpCode
+	}
+	if f.RSV&^(rsv1Bit|rsv2Bit|rsv3Bit) != 0 {
+		return errBadFrame
+	}
+	data := !isControl(f.Opcode)
+	if data && c.writeQueue != nil {
+		return errWriteFrameQueue
+	}
+
+	header, key := c.frameHeader(f, int64(len(f.Payload)))
+	payload := f.Payload
+	if !c.isServer {
+		payload = append([]byte(nil), f.Payload...)
+		maskBytes(key, 0, payload)
+	}
+	if len(payload) == 0 {
+		payload = nil
+	}
+	if data {
+		// Best-effort detection of concurrent writes like NextWriter. See the
+		// concurrency section in the package documentation.
+		if c.isWriting {
+			panic("concurrent write to websocket connection")
+		}
+		c.isWriting = true
+		defer func() { c.isWriting = false }()
+	}
+	if err := c.write(f.Opcode, c.writeDeadline, header, payload); err != nil {
+		return err
+	}
+	c.observeFrame(OutboundMessage, f.Opcode, int64(len(f.Payload)))
+	if f.Opcode == CloseMessage {
+		c.closeSent(f.Payload)
+	}
+	return nil
+}
+
+// frameHeader returns the header of a frame with a payload of n bytes and the
+// mask key of a client frame.
+func (c *Conn) frameHeader(f Frame, n int64) ([]byte, [4]byte) {
+	header := make([]byte, 0, maxFrameHeaderSize)
+	b0 := byte(f.Opcode) | f.RSV
+	if f.Fin {
+		b0 |= finalBit
+	}
+	var b1 byte
+	if !c.isServer {
+		b1 |= maskBit
+	}
+	switch {
+	case n >= 65536:
+		header = append(header, b0, b1|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	case n > 125:
+		header = append(header, b0, b1|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, b0, b1|byte(n))
+	}
+	var key [4]byte
+	if !c.isServer {
+		key = newMaskKey()
+		header = append(header, key[:]...)
+	}
+	return header, key
+}
+
+// copyFrame writes a data frame with a payload of n bytes read from r. If
+// reading r fails, the frame is incomplete and the connection fails.
+func (c *Conn) copyFrame(f Frame, n int64, r io.Reader) error {
+	if c.writeQueue != nil {
+		return errWriteFrameQueue
+	}
+	if c.isWriting {
+		panic("concurrent write to websocket connection")
+	}
+	c.isWriting = true
+	defer func() { c.isWriting = false }()
+
+	header, key := c.frameHeader(f, n)
+	size := n
+
+	<-c.mu
+	defer func() { c.mu <- struct{}{} }()
+
+	c.writeErrMu.Lock()
+	err := c.writeErr
+	c.writeErrMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if c.conn == nil {
+		return ErrNilNetConn
+	}
+	if err := c.conn.SetWriteDeadline(c.writeDeadline); err != nil {
+		return c.writeFatal(err)
+	}
+	if err := c.beginDataWrite(); err != nil {
+		return c.writeFatal(err)
+	}
+	defer c.endDataWrite()
+	if _, err := c.conn.Write(header); err != nil {
+		return c.writeFatal(err)
+	}
+
+	bp := frameCopyBufferPool.Get().(*[]byte)
+	defer frameCopyBufferPool.Put(bp)
+	buf := *bp
+	pos := 0
+	for n > 0 {
+		if int64(len(buf)) > n {
+			buf = buf[:n]
+		}
+		m, err := io.ReadFull(r, buf)
+		if !c.isServer {
+			pos = maskBytes(key, pos, buf[:m])
+		}
+		if _, werr := c.conn.Write(buf[:m]); werr != nil {
+			return c.writeFatal(werr)
+		}
+		n -= int64(m)
+		if err != nil {
+			if err == io.EOF {
+				err = errUnexpectedEOF
+			}
+			return c.writeFatal(err)
+		}
+	}
+	c.observeFrame(OutboundMessage, f.Opcode, size)
+	return nil
+}
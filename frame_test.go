@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []Frame{
+		{Opcode: TextMessage, Payload: []byte("hel")},
+		{Opcode: PingMessage, Fin: true, Payload: []byte("ping")},
+		{Opcode: ContinuationFrame, Payload: bytes.Repeat([]byte("l"), 200)},
+		{Opcode: ContinuationFrame, Fin: true, Payload: []byte("o")},
+		{Opcode: BinaryMessage, Fin: true, Payload: bytes.Repeat([]byte{0xff}, 70000)},
+		{Opcode: BinaryMessage, Fin: true, Payload: []byte{}},
+		{Opcode: CloseMessage, Fin: true, Payload: FormatCloseMessage(CloseNormalClosure, "bye")},
+	}
+	for _, isServer := range []bool{true, false} {
+		var connBuf, pongBuf bytes.Buffer
+		wc := newTestConn(nil, &connBuf, isServer)
+		rc := newTestConn(&connBuf, &pongBuf, !isServer)
+
+		for _, f := range frames {
+			if err := wc.WriteFrame(f); err != nil {
+				t.Fatalf("WriteFrame(%d) returned %v", f.Opcode, err)
+			}
+		}
+		for _, want := range frames {
+			got, err := rc.ReadFrame()
+			if err != nil {
+				t.Fatalf("ReadFrame() returned %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("ReadFrame() = %d %v %d bytes, want %d %v %d bytes", got.Opcode, got.Fin, len(got.Payload), want.Opcode, want.Fin, len(want.Payload))
+			}
+		}
+		if _, err := rc.ReadFrame(); !IsCloseError(err, CloseNormalClosure) {
+			t.Fatalf("ReadFrame() after close returned %v, want close error", err)
+		}
+
+		// The default handlers answered the ping and close frames.
+		pc := newTestConn(&pongBuf, io.Discard, isServer)
+		for _, want := range []int{PongMessage, CloseMessage} {
+			f, err := pc.ReadFrame()
+			if err != nil || f.Opcode != want {
+				t.Fatalf("peer ReadFrame() = %d, %v, want %d", f.Opcode, err, want)
+			}
+		}
+	}
+}
+
+func TestFrameMessage(t *testing.T) {
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, false)
+	rc := newTestConn(&connBuf, nil, true)
+	for _, f := range []Frame{
+		{Opcode: TextMessage, Payload: []byte("he")},
+		{Opcode: ContinuationFrame, Payload: []byte("ll")},
+		{Opcode: ContinuationFrame, Fin: true, Payload: []byte("o")},
+	} {
+		if err := wc.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wc.WriteMessage(BinaryMessage, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, p, err := rc.ReadMessage()
+	if err != nil || string(p) != "hello" {
+		t.Fatalf("ReadMessage() = %q, %v, want hello", p, err)
+	}
+	f, err := rc.ReadFrame()
+	if err != nil || f.Opcode != BinaryMessage || !f.Fin || string(f.Payload) != "world" {
+		t.Fatalf("ReadFrame() = %+v, %v, want final binary world", f, err)
+	}
+}
+
+func TestWriteFrameErrors(t *testing.T) {
+	c := newTestConn(nil, io.Discard, true)
+	for _, f := range []Frame{
+		{Opcode: 3, Fin: true},
+		{Opcode: PingMessage},
+		{Opcode: PongMessage, Fin: true, Payload: make([]byte, 126)},
+		{Opcode: TextMessage, Fin: true, RSV: 0x01},
+	} {
+		if err := c.WriteFrame(f); err == nil {
+			t.Errorf("WriteFrame(%+v) returned nil error", f)
+		}
+	}
+}
+
+func TestWriteFrameWriteQueue(t *testing.T) {
+	var buf bytes.Buffer
+	c := newTestConn(nil, &buf, true)
+	c.EnableWriteQueue(1, OverflowBlock)
+	defer c.Close()
+
+	if err := c.WriteFrame(Frame{Opcode: TextMessage, Payload: []byte("hel")}); err != errWriteFrameQueue {
+		t.Errorf("WriteFrame(data) returned %v, want %v", err, errWriteFrameQueue)
+	}
+	if err := c.WriteFrame(Frame{Opcode: PingMessage, Fin: true}); err != nil {
+		t.Errorf("WriteFrame(ping) returned %v", err)
+	}
+}
+
+func TestReadFrameProtocolError(t *testing.T) {
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, true)
+	rc := newTestConn(&connBuf, io.Discard, false)
+	if err := wc.WriteFrame(Frame{Opcode: ContinuationFrame, Fin: true, Payload: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.ReadFrame(); err == nil || err.Error() != "websocket: continuation after FIN" {
+		t.Fatalf("ReadFrame() returned %v, want continuation error", err)
+	}
+}
@@ -8,10 +8,11 @@ require (
 	github.com/klauspost/compress v1.17.11
 	github.com/savsgio/gotils v0.0.0-20240704082632-aef3928b8a38
 	github.com/valyala/fasthttp v1.58.0
-	golang.org/x/net v0.33.0
+	golang.org/x/net v0.35.0
 )
 
 require (
 	github.com/andybalholm/brotli v1.1.1 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
 )
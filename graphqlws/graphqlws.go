@@ -0,0 +1,378 @@
+// Package graphqlws implements the server side of the GraphQL over WebSocket
+// protocol (graphql-transport-ws).
+//
+// The Server handles the lifecycle of the protocol: the connection_init and
+// connection_ack handshake, pings, and the subscribe, next, error and
+// complete messages of the operations. The application executes the
+// operations by implementing the Executor interface.
+//
+// A Server serves net/http requests with ServeHTTP, fasthttp requests with
+// ServeFastHTTP, and connections upgraded by the application with ServeConn:
+//
+//	var rt websocket.SubprotocolRouter
+//	rt.Handle(graphqlws.Subprotocol, websocket.SubprotocolRoute{Handler: srv.ServeConn})
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/valyala/fasthttp"
+)
+
+// Subprotocol is the WebSocket subprotocol of the protocol.
+const Subprotocol = "graphql-transport-ws"
+
+const defaultInitTimeout = 3 * time.Second
+
+// Message types of the protocol.
+const (
+	typeConnectionInit = "connection_init"
+	typeConnectionAck  = "connection_ack"
+	typePing           = "ping"
+	typePong           = "pong"
+	typeSubscribe      = "subscribe"
+	typeNext           = "next"
+	typeError          = "error"
+	typeComplete       = "complete"
+)
+
+// Close codes of the protocol.
+const (
+	closeBadRequest             = 4400
+	closeUnauthorized           = 4401
+	closeForbidden              = 4403
+	closeSubprotocolUnsupported = 4406
+	closeInitTimeout            = 4408
+	closeSubscriberExists       = 4409
+	closeTooManyInitRequests    = 4429
+)
+
+// message is a message of the protocol.
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SubscribePayload is the payload of a subscribe message.
+type SubscribePayload struct {
+	OperationName string                 `json:"operationName,omitempty"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// ExecutionResult is a result of an operation sent in a next message.
+type ExecutionResult struct {
+	Data       interface{}            `json:"data,omitempty"`
+	Errors     []Error                `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Error is a GraphQL error.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Location is the location of a GraphQL error in the query.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Errors is an error holding GraphQL errors. When Executor.Subscribe returns
+// Errors, the errors are sent to the client in the error message of the
+// operation.
+type Errors []Error
+
+func (e Errors) Error() string {
+	if len(e) == 0 {
+		return "graphqlws: no errors"
+	}
+	if len(e) == 1 {
+		return "graphqlws: " + e[0].Message
+	}
+	return fmt.Sprintf("graphqlws: %s (and %d more errors)", e[0].Message, len(e)-1)
+}
+
+// Executor executes the operations of the clients.
+type Executor interface {
+	// Subscribe starts an operation and returns the channel of its results.
+	// The server sends each result in a next message, and a complete message
+	// when the channel is closed. Queries and mutations send a single
+	// result.
+	//
+	// The context is canceled when the client completes the operation or
+	// when the connection is closed. The executor must close the channel
+	// once the context is canceled.
+	//
+	// If Subscribe returns an error, the server sends an error message
+	// instead of running the operation. The message holds the GraphQL errors
+	// of an Errors value, or the message of other errors.
+	Subscribe(ctx context.Context, id string, payload *SubscribePayload) (<-chan *ExecutionResult, error)
+}
+
+// Server serves the graphql-transport-ws protocol.
+type Server struct {
+	// Executor executes the operations. Executor must not be nil.
+	Executor Executor
+
+	// Init, if not nil, is called with the payload of the connection_init
+	// message. Init returns the context of the connection's operations, such
+	// as a context holding the authenticated user, and the payload of the
+	// connection_ack message. A nil context keeps the context passed to Init.
+	// If Init returns an error, the server closes the connection with code
+	// 4403 (Forbidden).
+	Init func(ctx context.Context, payload json.RawMessage) (context.Context, interface{}, error)
+
+	// InitTimeout specifies the time allowed for the client to send the
+	// connection_init message. If InitTimeout is zero, then a default of 3
+	// seconds is used.
+	InitTimeout time.Duration
+
+	// Upgrader upgrades the requests of ServeHTTP. The Subprotocols field of
+	// the upgrader is ignored.
+	Upgrader websocket.Upgrader
+
+	// FastHTTPUpgrader upgrades the requests of ServeFastHTTP. The
+	// Subprotocols field of the upgrader is ignored.
+	FastHTTPUpgrader websocket.FastHTTPUpgrader
+}
+
+// ServeHTTP upgrades the request and serves the connection.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := s.Upgrader
+	u.Subprotocols = []string{Subprotocol}
+	c, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.ServeConn(c)
+}
+
+// ServeFastHTTP upgrades the request and serves the connection.
+func (s *Server) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
+	u := s.FastHTTPUpgrader
+	u.Subprotocols = []string{Subprotocol}
+	_ = u.Upgrade(ctx, s.ServeConn)
+}
+
+// ServeConn serves the protocol on an upgraded connection until the
+// connection fails or is closed by the protocol. ServeConn closes the
+// connection and waits for the operations to complete before returning.
+func (s *Server) ServeConn(c *websocket.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := &serverConn{
+		s:       s,
+		c:       c,
+		connCtx: ctx,
+		opCtx:   ctx,
+		subs:    make(map[string]*subscription),
+	}
+	defer sc.wg.Wait()
+	defer cancel()
+	defer c.Close()
+
+	if c.Subprotocol() != Subprotocol {
+		sc.close(closeSubprotocolUnsupported, "Subprotocol not acceptable")
+		return
+	}
+	timeout := s.InitTimeout
+	if timeout == 0 {
+		timeout = defaultInitTimeout
+	}
+	timer := time.AfterFunc(timeout, func() {
+		sc.mu.Lock()
+		initialized := sc.initialized
+		sc.mu.Unlock()
+		if !initialized {
+			sc.close(closeInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer timer.Stop()
+
+	for {
+		var m message
+		if err := c.ReadJSON(&m); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || err == io.ErrUnexpectedEOF {
+				sc.close(closeBadRequest, "Invalid message received")
+			}
+			return
+		}
+		if code, reason := sc.handle(&m); code != 0 {
+			sc.close(code, reason)
+			return
+		}
+	}
+}
+
+// serverConn is the state of a connection served by a Server.
+type serverConn struct {
+	s       *Server
+	c       *websocket.Conn
+	connCtx context.Context // canceled when the connection ends
+	wg      sync.WaitGroup
+
+	wmu sync.Mutex // serializes writes to the connection
+
+	mu          sync.Mutex
+	opCtx       context.Context // parent context of the operations
+	initialized bool            // connection_init received
+	acked       bool            // connection_ack sent
+	subs        map[string]*subscription
+}
+
+// subscription is a running operation.
+type subscription struct {
+	cancel context.CancelFunc
+}
+
+func (sc *serverConn) send(m *message) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	return sc.c.WriteJSON(m)
+}
+
+func (sc *serverConn) sendPayload(id, typ string, payload interface{}) error {
+	m := &message{ID: id, Type: typ}
+	if payload != nil {
+		p, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		m.Payload = p
+	}
+	return sc.send(m)
+}
+
+// close closes the connection with a close code of the protocol.
+func (sc *serverConn) close(code int, reason string) {
+	sc.wmu.Lock()
+	_ = sc.c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	sc.wmu.Unlock()
+	sc.c.Close()
+}
+
+// handle handles a message from the client. It returns a close code and
+// reason to close the connection on protocol violations.
+func (sc *serverConn) handle(m *message) (int, string) {
+	switch m.Type {
+	case typeConnectionInit:
+		sc.mu.Lock()
+		initialized := sc.initialized
+		sc.initialized = true
+		sc.mu.Unlock()
+		if initialized {
+			return closeTooManyInitRequests, "Too many initialisation requests"
+		}
+		ctx := sc.connCtx
+		var ack interface{}
+		if sc.s.Init != nil {
+			initCtx, payload, err := sc.s.Init(ctx, m.Payload)
+			if err != nil {
+				return closeForbidden, "Forbidden"
+			}
+			if initCtx != nil {
+				ctx = initCtx
+			}
+			ack = payload
+		}
+		sc.mu.Lock()
+		sc.opCtx = ctx
+		sc.acked = true
+		sc.mu.Unlock()
+		_ = sc.sendPayload("", typeConnectionAck, ack)
+	case typePing:
+		_ = sc.send(&message{Type: typePong, Payload: m.Payload})
+	case typePong:
+	case typeSubscribe:
+		return sc.subscribe(m)
+	case typeComplete:
+		sc.mu.Lock()
+		if sub := sc.subs[m.ID]; sub != nil {
+			delete(sc.subs, m.ID)
+			sub.cancel()
+		}
+		sc.mu.Unlock()
+	default:
+		return closeBadRequest, "Invalid message received"
+	}
+	return 0, ""
+}
+
+func (sc *serverConn) subscribe(m *message) (int, string) {
+	var payload SubscribePayload
+	if m.ID == "" || json.Unmarshal(m.Payload, &payload) != nil {
+		return closeBadRequest, "Invalid message received"
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.acked {
+		return closeUnauthorized, "Unauthorized"
+	}
+	if sc.subs[m.ID] != nil {
+		return closeSubscriberExists, "Subscriber for " + m.ID + " already exists"
+	}
+	ctx, cancel := context.WithCancel(sc.opCtx)
+	// Stop the operation when the connection ends, even if Init returned a
+	// context unrelated to the connection.
+	stop := context.AfterFunc(sc.connCtx, cancel)
+	sub := &subscription{cancel: cancel}
+	sc.subs[m.ID] = sub
+	sc.wg.Add(1)
+	go func() {
+		defer sc.wg.Done()
+		defer stop()
+		defer cancel()
+		sc.run(ctx, m.ID, sub, &payload)
+	}()
+	return 0, ""
+}
+
+// run runs an operation and sends its results.
+func (sc *serverConn) run(ctx context.Context, id string, sub *subscription, payload *SubscribePayload) {
+	results, err := sc.s.Executor.Subscribe(ctx, id, payload)
+	if err != nil {
+		if sc.remove(id, sub) {
+			var errs Errors
+			if !errors.As(err, &errs) {
+				errs = Errors{{Message: err.Error()}}
+			}
+			_ = sc.sendPayload(id, typeError, errs)
+		}
+		return
+	}
+	for result := range results {
+		if ctx.Err() == nil {
+			_ = sc.sendPayload(id, typeNext, result)
+		}
+	}
+	if sc.remove(id, sub) {
+		_ = sc.send(&message{ID: id, Type: typeComplete})
+	}
+}
+
+// remove removes a running operation. It reports whether the operation was
+// running, that is not completed by the client.
+func (sc *serverConn) remove(id string, sub *subscription) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.subs[id] != sub {
+		return false
+	}
+	delete(sc.subs, id)
+	return true
+}
@@ -0,0 +1,210 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/valyala/fasthttp"
+)
+
+// testExecutor streams the numbers of the "count" query and runs the "tick"
+// subscription until canceled.
+type testExecutor struct {
+	canceled chan string
+}
+
+func (e *testExecutor) Subscribe(ctx context.Context, id string, payload *SubscribePayload) (<-chan *ExecutionResult, error) {
+	results := make(chan *ExecutionResult)
+	switch payload.Query {
+	case "count":
+		go func() {
+			defer close(results)
+			for i := 1; i <= 3; i++ {
+				select {
+				case results <- &ExecutionResult{Data: map[string]interface{}{"n": i, "user": ctx.Value(userKey{})}}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	case "tick":
+		go func() {
+			defer close(results)
+			for {
+				select {
+				case results <- &ExecutionResult{Data: "tick"}:
+				case <-ctx.Done():
+					select {
+					case e.canceled <- id:
+					default:
+					}
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	default:
+		return nil, Errors{{Message: "unknown query"}}
+	}
+	return results, nil
+}
+
+type userKey struct{}
+
+func newTestServer(t *testing.T) (*Server, *testExecutor, string) {
+	t.Helper()
+	e := &testExecutor{canceled: make(chan string, 1)}
+	s := &Server{
+		Executor:    e,
+		InitTimeout: 50 * time.Millisecond,
+		Init: func(ctx context.Context, payload json.RawMessage) (context.Context, interface{}, error) {
+			var p struct{ Token string }
+			if err := json.Unmarshal(payload, &p); err != nil || p.Token == "" {
+				return nil, nil, errors.New("no token")
+			}
+			return context.WithValue(ctx, userKey{}, p.Token), map[string]string{"hello": p.Token}, nil
+		},
+	}
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	return s, e, "ws" + strings.TrimPrefix(hs.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{Subprotocol}}
+	c, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if c.Subprotocol() != Subprotocol {
+		t.Fatalf("Subprotocol() = %q, want %q", c.Subprotocol(), Subprotocol)
+	}
+	return c
+}
+
+func send(t *testing.T, c *websocket.Conn, text string) {
+	t.Helper()
+	if err := c.WriteMessage(websocket.TextMessage, []byte(text)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expect(t *testing.T, c *websocket.Conn, want string) {
+	t.Helper()
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() returned %v, want %s", err, want)
+	}
+	if got := strings.TrimSpace(string(p)); got != want {
+		t.Fatalf("ReadMessage() = %s, want %s", got, want)
+	}
+}
+
+func expectClose(t *testing.T, c *websocket.Conn, code int) {
+	t.Helper()
+	for {
+		_, _, err := c.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, code) {
+			t.Fatalf("ReadMessage() returned %v, want close code %d", err, code)
+		}
+		return
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	_, e, url := newTestServer(t)
+	c := dial(t, url)
+
+	send(t, c, `{"type":"connection_init","payload":{"Token":"u1"}}`)
+	expect(t, c, `{"type":"connection_ack","payload":{"hello":"u1"}}`)
+	send(t, c, `{"type":"ping","payload":{"x":1}}`)
+	expect(t, c, `{"type":"pong","payload":{"x":1}}`)
+
+	send(t, c, `{"id":"1","type":"subscribe","payload":{"query":"count"}}`)
+	for _, n := range []string{"1", "2", "3"} {
+		expect(t, c, `{"id":"1","type":"next","payload":{"data":{"n":`+n+`,"user":"u1"}}}`)
+	}
+	expect(t, c, `{"id":"1","type":"complete"}`)
+
+	send(t, c, `{"id":"2","type":"subscribe","payload":{"query":"bad"}}`)
+	expect(t, c, `{"id":"2","type":"error","payload":[{"message":"unknown query"}]}`)
+
+	send(t, c, `{"id":"3","type":"subscribe","payload":{"query":"tick"}}`)
+	expect(t, c, `{"id":"3","type":"next","payload":{"data":"tick"}}`)
+	send(t, c, `{"id":"3","type":"complete"}`)
+	if id := <-e.canceled; id != "3" {
+		t.Errorf("canceled operation %s, want 3", id)
+	}
+
+	// The ID of a completed operation can be reused.
+	send(t, c, `{"id":"3","type":"subscribe","payload":{"query":"bad"}}`)
+	for {
+		_, p, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(p), `"error"`) {
+			break
+		}
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	_, _, url := newTestServer(t)
+
+	tests := []struct {
+		name     string
+		messages []string
+		code     int
+	}{
+		{"unauthorized", []string{`{"id":"1","type":"subscribe","payload":{"query":"count"}}`}, 4401},
+		{"forbidden", []string{`{"type":"connection_init"}`}, 4403},
+		{"too many init", []string{`{"type":"connection_init","payload":{"Token":"u"}}`, `{"type":"connection_init"}`}, 4429},
+		{"duplicate", []string{
+			`{"type":"connection_init","payload":{"Token":"u"}}`,
+			`{"id":"1","type":"subscribe","payload":{"query":"tick"}}`,
+			`{"id":"1","type":"subscribe","payload":{"query":"tick"}}`,
+		}, 4409},
+		{"invalid type", []string{`{"type":"unknown"}`}, 4400},
+		{"invalid json", []string{`{`}, 4400},
+		{"init timeout", nil, 4408},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dial(t, url)
+			for _, m := range tt.messages {
+				send(t, c, m)
+			}
+			expectClose(t, c, tt.code)
+		})
+	}
+}
+
+func TestServeFastHTTP(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &fasthttp.Server{Handler: s.ServeFastHTTP}
+	go func() { _ = fs.Serve(ln) }()
+	defer func() { _ = fs.Shutdown() }()
+
+	c := dial(t, "ws://"+ln.Addr().String())
+	send(t, c, `{"type":"connection_init","payload":{"Token":"u2"}}`)
+	expect(t, c, `{"type":"connection_ack","payload":{"hello":"u2"}}`)
+	send(t, c, `{"id":"1","type":"subscribe","payload":{"query":"count"}}`)
+	expect(t, c, `{"id":"1","type":"next","payload":{"data":{"n":1,"user":"u2"}}}`)
+}
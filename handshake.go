@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+// UpgradeResponse holds the parts of a handshake response that a
+// BeforeUpgrade hook may change when accepting the handshake.
+type UpgradeResponse struct {
+	// Header specifies headers added to the handshake response, such as
+	// Set-Cookie. The Sec-WebSocket-Protocol and Sec-WebSocket-Extensions
+	// headers are not allowed; use the Subprotocol field to select the
+	// subprotocol.
+	Header http.Header
+
+	// Subprotocol is the subprotocol selected for the connection. The field
+	// is initialized with the subprotocol selected by the upgrader. The hook
+	// may set the field to one of the subprotocols requested by the client
+	// or to the empty string.
+	Subprotocol string
+}
+
+// HandshakeRejection is returned by a BeforeUpgrade hook to reject the
+// handshake with the given HTTP response.
+type HandshakeRejection struct {
+	// Status is the HTTP status of the response. If Status is zero, then
+	// http.StatusForbidden is used.
+	Status int
+
+	// Header specifies the headers of the response, such as WWW-Authenticate
+	// or Retry-After.
+	Header http.Header
+
+	// Body is the body of the response.
+	Body []byte
+}
+
+func (r *HandshakeRejection) status() int {
+	if r.Status == 0 {
+		return http.StatusForbidden
+	}
+	return r.Status
+}
+
+func (r *HandshakeRejection) Error() string {
+	return "websocket: handshake rejected with status " + strconv.Itoa(r.status())
+}
+
+// containsSubprotocol returns true if the subprotocol is empty or in the list
+// of subprotocols requested by the client.
+func containsSubprotocol(requested []string, subprotocol string) bool {
+	if subprotocol == "" {
+		return true
+	}
+	for _, p := range requested {
+		if p == subprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+// reject writes the rejection response.
+func (u *Upgrader) reject(w http.ResponseWriter, r *http.Request, rejection *HandshakeRejection) (*Conn, error) {
+	err := HandshakeError{message: rejection.Error(), status: rejection.status()}
+	u.handshakeFailed(r, err)
+	h := w.Header()
+	for k, vs := range rejection.Header {
+		h[k] = append(h[k], vs...)
+	}
+	w.WriteHeader(err.status)
+	_, _ = w.Write(rejection.Body)
+	return nil, err
+}
+
+// reject writes the rejection response.
+func (u *FastHTTPUpgrader) reject(ctx *fasthttp.RequestCtx, rejection *HandshakeRejection) error {
+	err := HandshakeError{message: rejection.Error(), status: rejection.status()}
+	u.handshakeFailed(ctx, err)
+	for k, vs := range rejection.Header {
+		for _, v := range vs {
+			ctx.Response.Header.Add(k, v)
+		}
+	}
+	ctx.SetStatusCode(err.status)
+	ctx.SetBody(rejection.Body)
+	return err
+}
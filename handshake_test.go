@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func authorizeUpgrade(r *http.Request, resp *UpgradeResponse) error {
+	switch r.Header.Get("Authorization") {
+	case "":
+		return &HandshakeRejection{
+			Status: http.StatusUnauthorized,
+			Header: http.Header{"Www-Authenticate": {`Bearer realm="ws"`}},
+			Body:   []byte("token required"),
+		}
+	case "Bearer ok":
+		resp.Header = http.Header{"Set-Cookie": {"session=1"}}
+		resp.Subprotocol = "p2"
+		return nil
+	}
+	return errors.New("bad token")
+}
+
+func TestBeforeUpgrade(t *testing.T) {
+	upgradeErr := make(chan error, 1)
+	upgrader := Upgrader{Subprotocols: []string{"p1", "p2"}, BeforeUpgrade: authorizeUpgrade}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, http.Header{"X-Test": {"1"}})
+		upgradeErr <- err
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	defer s.Close()
+
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil {
+		t.Fatalf("Dial() returned %v, want %v", err, ErrBadHandshake)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("Www-Authenticate") != `Bearer realm="ws"` || string(body) != "token required" {
+		t.Errorf("response = %d %v %q, want rejection", resp.StatusCode, resp.Header, body)
+	}
+	var herr HandshakeError
+	if err := <-upgradeErr; !errors.As(err, &herr) || herr.StatusCode() != http.StatusUnauthorized {
+		t.Errorf("Upgrade() returned %v, want HandshakeError with status 401", err)
+	}
+
+	_, resp, err = cstDialer.Dial(makeWsProto(s.URL), http.Header{"Authorization": {"Bearer bad"}})
+	if !errors.Is(err, ErrBadHandshake) || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial() returned %v, want %v with status 403", err, ErrBadHandshake)
+	}
+	if err := <-upgradeErr; !errors.As(err, &herr) || herr.StatusCode() != http.StatusForbidden {
+		t.Errorf("Upgrade() returned %v, want HandshakeError with status 403", err)
+	}
+
+	ws, resp, err := cstDialer.Dial(makeWsProto(s.URL), http.Header{"Authorization": {"Bearer ok"}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if err := <-upgradeErr; err != nil {
+		t.Fatalf("Upgrade() returned %v", err)
+	}
+	if ws.Subprotocol() != "p2" {
+		t.Errorf("Subprotocol() = %q, want p2", ws.Subprotocol())
+	}
+	if resp.Header.Get("Set-Cookie") != "session=1" || resp.Header.Get("X-Test") != "1" {
+		t.Errorf("response header = %v, want Set-Cookie and X-Test", resp.Header)
+	}
+}
+
+func TestBeforeUpgradeBadSubprotocol(t *testing.T) {
+	upgrader := Upgrader{BeforeUpgrade: func(r *http.Request, resp *UpgradeResponse) error {
+		resp.Subprotocol = "p3"
+		return nil
+	}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ws, err := upgrader.Upgrade(w, r, nil); err == nil {
+			ws.Close()
+		}
+	}))
+	defer s.Close()
+
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if !errors.Is(err, ErrBadHandshake) || resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Dial() returned %v, want %v with status 500", err, ErrBadHandshake)
+	}
+}
+
+func TestFastHTTPBeforeUpgrade(t *testing.T) {
+	upgradeErr := make(chan error, 1)
+	upgrader := FastHTTPUpgrader{
+		Subprotocols: []string{"p1", "p2"},
+		BeforeUpgrade: func(ctx *fasthttp.RequestCtx, resp *UpgradeResponse) error {
+			if string(ctx.Request.Header.Peek("Authorization")) == "" {
+				return &HandshakeRejection{
+					Status: fasthttp.StatusServiceUnavailable,
+					Header: http.Header{"Retry-After": {"30"}},
+					Body:   []byte("busy"),
+				}
+			}
+			resp.Header = http.Header{"X-Session": {"1"}}
+			resp.Subprotocol = ""
+			return nil
+		},
+	}
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		upgradeErr <- upgrader.Upgrade(ctx, func(ws *Conn) {
+			ws.Close()
+		})
+	})
+	defer s.Close()
+
+	_, resp, err := cstDialer.Dial(s.URL, nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil {
+		t.Fatalf("Dial() returned %v, want %v", err, ErrBadHandshake)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "30" || string(body) != "busy" {
+		t.Errorf("response = %d %v %q, want rejection", resp.StatusCode, resp.Header, body)
+	}
+	var herr HandshakeError
+	if err := <-upgradeErr; !errors.As(err, &herr) || herr.StatusCode() != http.StatusServiceUnavailable {
+		t.Errorf("Upgrade() returned %v, want HandshakeError with status 503", err)
+	}
+
+	ws, resp, err := cstDialer.Dial(s.URL, http.Header{"Authorization": {"Bearer ok"}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if ws.Subprotocol() != "" {
+		t.Errorf("Subprotocol() = %q, want none", ws.Subprotocol())
+	}
+	if resp.Header.Get("X-Session") != "1" {
+		t.Errorf("response header = %v, want X-Session", resp.Header)
+	}
+}
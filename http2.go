@@ -0,0 +1,425 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// acceptHTTP2 completes the handshake of an HTTP/2 extended CONNECT request
+// (RFC 8441). The connection runs on the request stream.
+func (u *Upgrader) acceptHTTP2(w http.ResponseWriter, r *http.Request, responseHeader http.Header, subprotocol, extensionsHeader string, extensions []NegotiatedExtension, trace *ServerTrace) (*Conn, error) {
+	h := w.Header()
+	for k, vs := range responseHeader {
+		if k != "Sec-Websocket-Protocol" {
+			h[k] = vs
+		}
+	}
+	if subprotocol != "" {
+		h.Set("Sec-Websocket-Protocol", subprotocol)
+	}
+	if extensionsHeader != "" {
+		h.Set("Sec-Websocket-Extensions", extensionsHeader)
+	}
+
+	// Deadlines are not supported by all HTTP/2 servers. Errors from the
+	// deadline setters are ignored.
+	rc := http.NewResponseController(w)
+	if u.HandshakeTimeout > 0 {
+		_ = rc.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+	// Clear deadlines set by HTTP server.
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if local == nil {
+		local = http2Addr("")
+	}
+	ctx := r.Context()
+	stream := newHTTP2Conn(r.Body, w, rc.Flush, func() {
+		if ctx.Err() == nil {
+			_ = rc.SetReadDeadline(aLongTimeAgo)
+			_ = rc.SetWriteDeadline(aLongTimeAgo)
+		}
+	}, r.Body.Close, local, http2Addr(r.RemoteAddr))
+	// The stream ends when the handler returns. The request context is
+	// canceled just before.
+	stream.done = ctx.Done()
+
+	c := newConn(stream, true, u.ReadBufferSize, u.WriteBufferSize, u.WriteBufferPool, nil, nil)
+	c.subprotocol = subprotocol
+	c.setExtensions(extensions)
+	c.setInterceptors(u.Interceptors, u.InterceptControl)
+	c.codecs = u.Codecs
+	if trace != nil {
+		c.setTrace(trace.ConnTrace)
+	}
+
+	if u.Registry != nil && !u.Registry.add(c) {
+		stream.Close()
+		return nil, ErrShuttingDown
+	}
+
+	c.startObserving(u.Observer)
+	c.StartKeepAlive(u.KeepAlive)
+	context.AfterFunc(ctx, func() { _ = c.Close() })
+
+	return c, nil
+}
+
+// dialHTTP2 performs a handshake with an HTTP/2 extended CONNECT request
+// (RFC 8441) sent with the dialer's HTTP2Transport.
+func (d *Dialer) dialHTTP2(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, nil, errMalformedURL
+	}
+
+	if u.User != nil {
+		// User name and password are not allowed in websocket URIs.
+		return nil, nil, errMalformedURL
+	}
+
+	// The stream of the request ends when the request context is canceled.
+	// The context passed to DialContext only bounds the handshake.
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	var local, remote net.Addr = http2Addr(""), http2Addr(u.Host)
+	streamCtx = httptrace.WithClientTrace(streamCtx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			local, remote = info.Conn.LocalAddr(), info.Conn.RemoteAddr()
+		},
+	})
+
+	pr, pw := io.Pipe()
+	req := &http.Request{
+		Method:     http.MethodConnect,
+		URL:        u,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(http.Header),
+		Body:       pr,
+		Host:       u.Host,
+	}
+	req = req.WithContext(streamCtx)
+
+	// Set the cookies present in the cookie jar of the dialer
+	if d.Jar != nil {
+		for _, cookie := range d.Jar.Cookies(u) {
+			req.AddCookie(cookie)
+		}
+	}
+
+	req.Header[":protocol"] = []string{"websocket"}
+	req.Header["Sec-WebSocket-Version"] = []string{"13"}
+	if len(d.Subprotocols) > 0 {
+		req.Header["Sec-WebSocket-Protocol"] = []string{strings.Join(d.Subprotocols, ", ")}
+	}
+	for k, vs := range requestHeader {
+		switch {
+		case k == "Host":
+			if len(vs) > 0 {
+				req.Host = vs[0]
+			}
+		case k == "Upgrade" ||
+			k == "Connection" ||
+			k == "Sec-Websocket-Key" ||
+			k == "Sec-Websocket-Version" ||
+			k == "Sec-Websocket-Extensions" ||
+			(k == "Sec-Websocket-Protocol" && len(d.Subprotocols) > 0):
+			cancel()
+			return nil, nil, errors.New("websocket: duplicate header not allowed: " + k)
+		case k == "Sec-Websocket-Protocol":
+			req.Header["Sec-WebSocket-Protocol"] = vs
+		default:
+			req.Header[k] = vs
+		}
+	}
+
+	extensions := withCompression(d.EnableCompression, d.EnableContextTakeover, d.Extensions)
+	if len(extensions) > 0 {
+		req.Header["Sec-WebSocket-Extensions"] = []string{offerExtensions(extensions)}
+	}
+
+	// Abort the handshake when ctx is done or the handshake timeout expires.
+	stop := context.AfterFunc(ctx, cancel)
+	var timer *time.Timer
+	if d.HandshakeTimeout != 0 {
+		timer = time.AfterFunc(d.HandshakeTimeout, cancel)
+	}
+	resp, err := d.HTTP2Transport.RoundTrip(req)
+	aborted := !stop()
+	if timer != nil && !timer.Stop() {
+		aborted = true
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	body := resp.Body
+	stream := newHTTP2Conn(body, pw, nil, func() {
+		pw.CloseWithError(os.ErrDeadlineExceeded)
+		body.Close()
+		cancel()
+	}, func() error {
+		pw.Close()
+		err := body.Close()
+		cancel()
+		return err
+	}, local, remote)
+
+	// Close the stream when returning an error. The variable stream is set
+	// to nil before the success return at the end of the function.
+	defer func() {
+		if stream != nil {
+			_ = stream.Close()
+		}
+	}()
+
+	if aborted {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, context.DeadlineExceeded
+	}
+
+	if d.Jar != nil {
+		if rc := resp.Cookies(); len(rc) > 0 {
+			d.Jar.SetCookies(u, rc)
+		}
+	}
+
+	wsTrace := ContextClientTrace(ctx)
+	if wsTrace != nil && wsTrace.HandshakeResponse != nil {
+		wsTrace.HandshakeResponse(resp.StatusCode, resp.Header)
+	}
+	err = nil
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = ErrHandshakeStatus
+	}
+	if wsTrace != nil && wsTrace.HandshakeValidated != nil {
+		wsTrace.HandshakeValidated(err)
+	}
+	if err != nil {
+		// Before closing the stream on return from this function, slurp up
+		// some of the response to aid application debugging.
+		buf := make([]byte, maxHandshakeErrorBody)
+		n, _ := io.ReadFull(resp.Body, buf)
+		resp.Body = io.NopCloser(bytes.NewReader(buf[:n]))
+		return nil, resp, &BadHandshakeError{Cause: err, StatusCode: resp.StatusCode, Header: resp.Header, Body: buf[:n]}
+	}
+
+	negotiated, err := configureExtensions(parseExtensions(resp.Header), extensions)
+	if wsTrace != nil && wsTrace.ExtensionsNegotiated != nil {
+		wsTrace.ExtensionsNegotiated(strings.Join(resp.Header.Values("Sec-Websocket-Extensions"), ", "), err)
+	}
+	if err != nil {
+		return nil, resp, &BadHandshakeError{Cause: err, StatusCode: resp.StatusCode, Header: resp.Header}
+	}
+
+	conn := newConn(stream, false, d.ReadBufferSize, d.WriteBufferSize, d.WriteBufferPool, nil, nil)
+	conn.setExtensions(negotiated)
+	conn.setInterceptors(d.Interceptors, d.InterceptControl)
+	conn.codecs = d.Codecs
+
+	resp.Body = io.NopCloser(bytes.NewReader([]byte{}))
+	conn.subprotocol = resp.Header.Get("Sec-Websocket-Protocol")
+	if wsTrace != nil {
+		if wsTrace.SubprotocolSelected != nil {
+			wsTrace.SubprotocolSelected(conn.subprotocol)
+		}
+		conn.setTrace(wsTrace.ConnTrace)
+	}
+
+	// Success! Set stream to nil to stop the deferred function above from
+	// closing the stream.
+	stream = nil
+
+	conn.startObserving(d.Observer)
+	conn.StartKeepAlive(d.KeepAlive)
+
+	return conn, resp, nil
+}
+
+// http2Addr is the address of an HTTP/2 stream.
+type http2Addr string
+
+func (a http2Addr) Network() string { return "tcp" }
+
+func (a http2Addr) String() string { return string(a) }
+
+// http2Conn adapts an HTTP/2 stream to the net.Conn interface.
+type http2Conn struct {
+	r             io.Reader
+	w             io.Writer
+	flush         func() error
+	close         func() error
+	local, remote net.Addr
+	closed        atomic.Bool
+	readDeadline  streamDeadline
+	writeDeadline streamDeadline
+
+	// done, if not nil, is closed when the stream of a server connection
+	// ends. The response writer must not be used after the stream ends.
+	done <-chan struct{}
+	wmu  sync.Mutex // serializes the writes with the check of done
+}
+
+func newHTTP2Conn(r io.Reader, w io.Writer, flush func() error, abort func(), close func() error, local, remote net.Addr) *http2Conn {
+	c := &http2Conn{r: r, w: w, flush: flush, close: close, local: local, remote: remote}
+	c.readDeadline.abort = abort
+	c.writeDeadline.abort = abort
+	return c
+}
+
+func (c *http2Conn) Read(p []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	if err := c.readDeadline.begin(); err != nil {
+		return 0, err
+	}
+	n, err := c.r.Read(p)
+	return n, c.readDeadline.end(err)
+}
+
+func (c *http2Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed.Load() || c.finished() {
+		return 0, net.ErrClosed
+	}
+	if err := c.writeDeadline.begin(); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	if err == nil && c.flush != nil {
+		err = c.flush()
+	}
+	return n, c.writeDeadline.end(err)
+}
+
+// finished reports whether the stream of a server connection ended.
+func (c *http2Conn) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *http2Conn) Close() error {
+	if c.closed.Swap(true) {
+		return net.ErrClosed
+	}
+	c.readDeadline.set(time.Time{})
+	c.writeDeadline.set(time.Time{})
+	return c.close()
+}
+
+func (c *http2Conn) LocalAddr() net.Addr { return c.local }
+
+func (c *http2Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *http2Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *http2Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *http2Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// streamDeadline implements a deadline of an HTTP/2 stream. A pending read
+// or write of a stream cannot be interrupted, so the stream is aborted when
+// the deadline expires during an operation.
+type streamDeadline struct {
+	abort func()
+
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	pending  int
+}
+
+func (d *streamDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deadline = t
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if !t.IsZero() {
+		d.timer = time.AfterFunc(time.Until(t), d.expire)
+	}
+}
+
+func (d *streamDeadline) exceeded() bool {
+	return !d.deadline.IsZero() && !time.Now().Before(d.deadline)
+}
+
+func (d *streamDeadline) expire() {
+	d.mu.Lock()
+	abort := d.pending > 0 && d.exceeded()
+	d.mu.Unlock()
+	if abort {
+		d.abort()
+	}
+}
+
+// begin starts an operation. It returns an error if the deadline is
+// exceeded.
+func (d *streamDeadline) begin() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.exceeded() {
+		return os.ErrDeadlineExceeded
+	}
+	d.pending++
+	return nil
+}
+
+// end ends an operation. It returns os.ErrDeadlineExceeded if the operation
+// failed because the deadline expired, or err.
+func (d *streamDeadline) end(err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending--
+	if err != nil && d.exceeded() {
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// runWithExtendedConnect runs the test in a subprocess when the extended
+// CONNECT protocol of the HTTP/2 server is not enabled with GODEBUG. It
+// reports whether the caller should run the test.
+func runWithExtendedConnect(t *testing.T) bool {
+	t.Helper()
+	godebug := os.Getenv("GODEBUG")
+	if strings.Contains(godebug, "http2xconnect=1") {
+		return true
+	}
+	if godebug != "" {
+		godebug += ","
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), "GODEBUG="+godebug+"http2xconnect=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	return false
+}
+
+// newHTTP2Server returns a TLS server for handler running the HTTP/2 server
+// of golang.org/x/net/http2, and a transport connecting to the server.
+func newHTTP2Server(t *testing.T, handler http.Handler) (*httptest.Server, *http2.Transport) {
+	t.Helper()
+	s := httptest.NewUnstartedServer(handler)
+	if err := http2.ConfigureServer(s.Config, &http2.Server{}); err != nil {
+		t.Fatal(err)
+	}
+	s.TLS = s.Config.TLSConfig
+	s.StartTLS()
+	transport := &http2.Transport{TLSClientConfig: s.Client().Transport.(*http.Transport).TLSClientConfig.Clone()}
+	return s, transport
+}
+
+func TestHTTP2(t *testing.T) {
+	if !runWithExtendedConnect(t) {
+		return
+	}
+	upgrader := Upgrader{Subprotocols: []string{"p1"}, EnableCompression: true}
+	s, transport := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, http.Header{"X-Test": {"1"}})
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			mt, p, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+	defer transport.CloseIdleConnections()
+
+	var reused atomic.Bool
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused.Store(info.Reused) },
+	})
+	dialer := Dialer{HTTP2Transport: transport, Subprotocols: []string{"p1"}, EnableCompression: true}
+	url := makeWsProto(s.URL) + "/ws"
+	var conns []*Conn
+	for i := 0; i < 2; i++ {
+		ws, resp, err := dialer.DialContext(ctx, url, nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer ws.Close()
+		if resp.ProtoMajor != 2 || resp.Header.Get("X-Test") != "1" {
+			t.Errorf("response = %s %v, want HTTP/2 with X-Test", resp.Proto, resp.Header)
+		}
+		if ws.Subprotocol() != "p1" || ws.newCompressionWriter == nil {
+			t.Errorf("subprotocol = %q, compression = %v, want p1 with compression", ws.Subprotocol(), ws.newCompressionWriter != nil)
+		}
+		conns = append(conns, ws)
+	}
+	if !reused.Load() {
+		t.Error("second connection did not reuse the HTTP/2 connection")
+	}
+
+	for _, ws := range conns {
+		if err := ws.WriteMessage(TextMessage, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, p, err := ws.ReadMessage(); err != nil || string(p) != "hello" {
+			t.Fatalf("ReadMessage() = %q, %v, want hello", p, err)
+		}
+		if err := ws.CloseGracefully(CloseNormalClosure, "", time.Second); err != nil {
+			t.Errorf("CloseGracefully() returned %v", err)
+		}
+	}
+}
+
+func TestHTTP2BadHandshake(t *testing.T) {
+	if !runWithExtendedConnect(t) {
+		return
+	}
+	upgrader := Upgrader{CheckOrigin: func(r *http.Request) bool { return false }}
+	s, transport := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = upgrader.Upgrade(w, r, nil)
+	}))
+	defer s.Close()
+	defer transport.CloseIdleConnections()
+
+	dialer := Dialer{HTTP2Transport: transport}
+	_, resp, err := dialer.Dial(makeWsProto(s.URL), nil)
+	var herr *BadHandshakeError
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusForbidden || resp == nil {
+		t.Fatalf("Dial() returned %v, want *BadHandshakeError with status 403", err)
+	}
+}
+
+func TestHTTP2ReadDeadline(t *testing.T) {
+	if !runWithExtendedConnect(t) {
+		return
+	}
+	done := make(chan struct{})
+	s, transport := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		<-done
+	}))
+	defer s.Close()
+	defer transport.CloseIdleConnections()
+	defer close(done)
+
+	dialer := Dialer{HTTP2Transport: transport}
+	ws, _, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if _, ok := ws.RemoteAddr().(*net.TCPAddr); !ok {
+		t.Errorf("RemoteAddr() = %v, want TCP address", ws.RemoteAddr())
+	}
+	if err := ws.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.ReadMessage(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadMessage() returned %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestHTTP2HandlerReturn(t *testing.T) {
+	if !runWithExtendedConnect(t) {
+		return
+	}
+	conns := make(chan *Conn, 1)
+	upgrader := Upgrader{KeepAlive: KeepAlive{Interval: time.Millisecond, Timeout: time.Hour}}
+	s, transport := newHTTP2Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler returns while the keepalive is running.
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			conns <- ws
+		}
+	}))
+	defer s.Close()
+	defer transport.CloseIdleConnections()
+
+	dialer := Dialer{HTTP2Transport: transport}
+	ws, _, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sc := <-conns
+
+	// The client reads until the stream ends.
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := sc.WriteMessage(TextMessage, []byte("x"))
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("WriteMessage() after the handler returned: %v, want %v", err, net.ErrClosed)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Let the keepalive run after the handler returned.
+	time.Sleep(20 * time.Millisecond)
+}
@@ -0,0 +1,313 @@
+package websocket
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHubQueueSize    = 16
+	defaultHubWriteTimeout = 10 * time.Second
+)
+
+// ErrSlowConsumer is passed to Hub.OnEvict when a subscriber is evicted
+// because its queue of pending messages is full.
+var ErrSlowConsumer = errors.New("websocket: slow consumer")
+
+// Hub broadcasts messages to the connections subscribed to named topics.
+//
+// A broadcast message is prepared once with NewPreparedMessage and written to
+// all subscribers concurrently. Each subscribed connection has a queue of
+// pending messages and a goroutine writing them. A subscriber is evicted when
+// its queue is full or when a write fails.
+//
+// The hub writes to subscribed connections from its own goroutines. The
+// application must not write to a subscribed connection other than with
+// WriteControl and Close, unless the connection's write queue is enabled with
+// EnableWriteQueue.
+//
+// The zero value is ready to use. It is safe to call Hub's methods
+// concurrently.
+type Hub struct {
+	// QueueSize specifies the number of pending messages per subscriber. If
+	// QueueSize is zero, then a default of 16 is used.
+	QueueSize int
+
+	// WriteTimeout specifies the write deadline for a message. If
+	// WriteTimeout is zero, then a default of 10 seconds is used. The write
+	// deadline is not set on connections with the write queue enabled.
+	WriteTimeout time.Duration
+
+	// OnEvict is called when a subscriber is evicted from all topics with
+	// ErrSlowConsumer or the error returned by the write. If OnEvict is nil,
+	// the hub closes the connection, after sending a close message with
+	// ClosePolicyViolation to a slow consumer. OnEvict and the default close
+	// run on a new goroutine so that a slow subscriber does not delay the
+	// broadcast.
+	OnEvict func(c *Conn, err error)
+
+	mu          sync.Mutex
+	topics      map[string]*hubTopic
+	subscribers map[*Conn]*hubSubscriber
+}
+
+// TopicStats holds the statistics of a topic.
+//
+// The hub discards a topic and its statistics when the last subscriber
+// leaves, including when it is evicted. The counters start from zero when the
+// topic gets a new subscriber.
+type TopicStats struct {
+	// Subscribers is the current number of subscribers.
+	Subscribers int
+
+	// Published is the number of messages broadcast to the topic.
+	Published uint64
+
+	// Delivered is the number of messages written to subscribers.
+	Delivered uint64
+
+	// Evicted is the number of subscribers evicted from the topic.
+	Evicted uint64
+}
+
+type hubTopic struct {
+	subscribers map[*hubSubscriber]struct{}
+	published   atomic.Uint64
+	delivered   atomic.Uint64
+	evicted     atomic.Uint64
+}
+
+type hubMessage struct {
+	topic *hubTopic
+	pm    *PreparedMessage
+}
+
+type hubSubscriber struct {
+	c      *Conn
+	topics map[*hubTopic]string
+	send   chan hubMessage
+	closed bool
+	done   chan struct{}
+}
+
+// Subscribe subscribes the connection to the topic.
+func (h *Hub) Subscribe(topic string, c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.topics == nil {
+		h.topics = make(map[string]*hubTopic)
+		h.subscribers = make(map[*Conn]*hubSubscriber)
+	}
+	t := h.topics[topic]
+	if t == nil {
+		t = &hubTopic{subscribers: make(map[*hubSubscriber]struct{})}
+		h.topics[topic] = t
+	}
+	s := h.subscribers[c]
+	if s == nil || s.closed {
+		var prev chan struct{}
+		if s != nil {
+			prev = s.done
+		}
+		queueSize := h.QueueSize
+		if queueSize <= 0 {
+			queueSize = defaultHubQueueSize
+		}
+		s = &hubSubscriber{
+			c:      c,
+			topics: make(map[*hubTopic]string),
+			send:   make(chan hubMessage, queueSize),
+			done:   make(chan struct{}),
+		}
+		h.subscribers[c] = s
+		go h.writeLoop(s, prev)
+	}
+	s.topics[t] = topic
+	t.subscribers[s] = struct{}{}
+}
+
+// Unsubscribe unsubscribes the connection from the topic. Messages already
+// queued for the connection are written.
+func (h *Hub) Unsubscribe(topic string, c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.subscribers[c]
+	t := h.topics[topic]
+	if s == nil || s.closed || t == nil {
+		return
+	}
+	if _, ok := s.topics[t]; !ok {
+		return
+	}
+	h.removeLocked(s, t)
+	if len(s.topics) == 0 {
+		h.closeLocked(s)
+	}
+}
+
+// UnsubscribeAll unsubscribes the connection from all topics. Applications
+// should call UnsubscribeAll when the connection is closed.
+func (h *Hub) UnsubscribeAll(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.subscribers[c]
+	if s == nil || s.closed {
+		return
+	}
+	for t := range s.topics {
+		h.removeLocked(s, t)
+	}
+	h.closeLocked(s)
+}
+
+// Broadcast prepares a message and sends it to the subscribers of the topic.
+func (h *Hub) Broadcast(topic string, messageType int, data []byte) error {
+	pm, err := NewPreparedMessage(messageType, data)
+	if err != nil {
+		return err
+	}
+	h.BroadcastPrepared(topic, pm)
+	return nil
+}
+
+// BroadcastPrepared sends a prepared message to the subscribers of the topic.
+// BroadcastPrepared does not wait for the message to be written.
+func (h *Hub) BroadcastPrepared(topic string, pm *PreparedMessage) {
+	var slow []*hubSubscriber
+
+	h.mu.Lock()
+	t := h.topics[topic]
+	if t == nil {
+		h.mu.Unlock()
+		return
+	}
+	t.published.Add(1)
+	for s := range t.subscribers {
+		select {
+		case s.send <- hubMessage{topic: t, pm: pm}:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, s := range slow {
+		h.evict(s, ErrSlowConsumer)
+	}
+}
+
+// Stats returns the statistics of the topic. Stats returns the zero value
+// for a topic without subscribers: the statistics of a topic are discarded
+// when its last subscriber leaves or is evicted.
+func (h *Hub) Stats(topic string) TopicStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topics[topic]
+	if t == nil {
+		return TopicStats{}
+	}
+	return TopicStats{
+		Subscribers: len(t.subscribers),
+		Published:   t.published.Load(),
+		Delivered:   t.delivered.Load(),
+		Evicted:     t.evicted.Load(),
+	}
+}
+
+// Topics returns the sorted names of the topics with subscribers.
+func (h *Hub) Topics() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	topics := make([]string, 0, len(h.topics))
+	for name := range h.topics {
+		topics = append(topics, name)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// removeLocked removes the subscriber from the topic and discards the topic
+// if it has no subscribers left.
+func (h *Hub) removeLocked(s *hubSubscriber, t *hubTopic) {
+	name := s.topics[t]
+	delete(s.topics, t)
+	delete(t.subscribers, s)
+	if len(t.subscribers) == 0 {
+		delete(h.topics, name)
+	}
+}
+
+// closeLocked stops the subscriber's goroutine once the queued messages are
+// written.
+func (h *Hub) closeLocked(s *hubSubscriber) {
+	s.closed = true
+	close(s.send)
+}
+
+func (h *Hub) evict(s *hubSubscriber, err error) {
+	h.mu.Lock()
+	if s.closed {
+		h.mu.Unlock()
+		return
+	}
+	for t := range s.topics {
+		t.evicted.Add(1)
+		h.removeLocked(s, t)
+	}
+	h.closeLocked(s)
+	h.mu.Unlock()
+
+	// The close message waits for the subscriber's pending write, which can
+	// take up to WriteTimeout.
+	go func() {
+		if h.OnEvict != nil {
+			h.OnEvict(s.c, err)
+			return
+		}
+		if err == ErrSlowConsumer {
+			_ = s.c.WriteControl(CloseMessage, FormatCloseMessage(ClosePolicyViolation, "slow consumer"), time.Now().Add(writeWait))
+		}
+		// After a write error, the write side of the connection is broken.
+		_ = s.c.Close()
+	}()
+}
+
+// writeLoop writes the messages queued for a subscriber. The loop waits for
+// the goroutine of a previous subscription of the connection to exit.
+func (h *Hub) writeLoop(s *hubSubscriber, prev chan struct{}) {
+	defer func() {
+		h.mu.Lock()
+		if h.subscribers[s.c] == s {
+			delete(h.subscribers, s.c)
+		}
+		h.mu.Unlock()
+		close(s.done)
+	}()
+
+	if prev != nil {
+		<-prev
+	}
+
+	writeTimeout := h.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = defaultHubWriteTimeout
+	}
+	for m := range s.send {
+		if s.c.writeQueue == nil {
+			_ = s.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+		if err := s.c.WritePreparedMessage(m.pm); err != nil {
+			h.evict(s, err)
+			return
+		}
+		m.topic.delivered.Add(1)
+	}
+}
@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newPipeConns returns a connected server and client connection.
+func newPipeConns() (server, client *Conn) {
+	p1, p2 := net.Pipe()
+	return newConn(p1, true, 1024, 1024, nil, nil, nil), newConn(p2, false, 1024, 1024, nil, nil, nil)
+}
+
+func TestHubBroadcast(t *testing.T) {
+	var h Hub
+	var clients []*Conn
+	for i := 0; i < 3; i++ {
+		s, c := newPipeConns()
+		defer s.Close()
+		defer c.Close()
+		h.Subscribe("a", s)
+		if i > 0 {
+			h.Subscribe("b", s)
+		}
+		clients = append(clients, c)
+	}
+
+	if got, want := h.Topics(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Topics() = %v, want %v", got, want)
+	}
+
+	if err := h.Broadcast("a", TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Broadcast("b", BinaryMessage, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Broadcast("c", TextMessage, []byte("nobody")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range clients {
+		want := []string{"hello", "world"}
+		if i == 0 {
+			want = want[:1]
+		}
+		for _, w := range want {
+			_, p, err := c.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() returned %v", err)
+			}
+			if string(p) != w {
+				t.Fatalf("ReadMessage() = %q, want %q", p, w)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Stats("b").Delivered != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := h.Stats("b"), (TopicStats{Subscribers: 2, Published: 1, Delivered: 2}); got != want {
+		t.Errorf("Stats(b) = %+v, want %+v", got, want)
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	var h Hub
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+
+	h.Subscribe("a", s)
+	h.Subscribe("b", s)
+	h.Unsubscribe("a", s)
+	if got, want := h.Topics(), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Topics() = %v, want %v", got, want)
+	}
+	h.UnsubscribeAll(s)
+	if got := h.Topics(); len(got) != 0 {
+		t.Fatalf("Topics() = %v, want none", got)
+	}
+
+	// Subscribing again after the subscription was closed must work.
+	h.Subscribe("a", s)
+	if err := h.Broadcast("a", TextMessage, []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if _, p, err := c.ReadMessage(); err != nil || string(p) != "again" {
+		t.Fatalf("ReadMessage() = %q, %v, want again", p, err)
+	}
+}
+
+func TestHubSlowConsumer(t *testing.T) {
+	evicted := make(chan *Conn, 1)
+	h := Hub{
+		QueueSize: 1,
+		OnEvict: func(c *Conn, err error) {
+			if err != ErrSlowConsumer {
+				t.Errorf("OnEvict err = %v, want %v", err, ErrSlowConsumer)
+			}
+			evicted <- c
+		},
+	}
+
+	// Nobody reads the slow connection.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	slow := newTestConn(nil, pw, true)
+	h.Subscribe("a", slow)
+	h.Subscribe("a", newTestConn(nil, io.Discard, true))
+
+	for i := 1; i <= 3; i++ {
+		if err := h.Broadcast("a", TextMessage, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		// Wait for the fast connection to drain its queue.
+		deadline := time.Now().Add(5 * time.Second)
+		for h.Stats("a").Delivered != uint64(i) {
+			if time.Now().After(deadline) {
+				t.Fatal("message not delivered")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	select {
+	case c := <-evicted:
+		if c != slow {
+			t.Errorf("evicted the wrong connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow consumer not evicted")
+	}
+	stats := h.Stats("a")
+	if stats.Subscribers != 1 || stats.Evicted != 1 || stats.Published != 3 {
+		t.Errorf("Stats(a) = %+v, want 1 subscriber, 1 evicted, 3 published", stats)
+	}
+}
+
+func TestHubEvictDoesNotBlockBroadcast(t *testing.T) {
+	h := Hub{QueueSize: 1}
+
+	// The write of the first message blocks the slow connection's writer.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	slow := newTestConn(nil, pw, true)
+	h.Subscribe("a", slow)
+
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		if err := h.Broadcast("a", TextMessage, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d > writeWait/2 {
+			t.Fatalf("Broadcast %d took %v", i, d)
+		}
+		if i == 0 {
+			// Wait for the writer to take the first message.
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				h.mu.Lock()
+				n := len(h.subscribers[slow].send)
+				h.mu.Unlock()
+				if n == 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("message not taken")
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	if stats := h.Stats("a"); stats != (TopicStats{}) {
+		t.Errorf("Stats(a) = %+v, want zero value after eviction", stats)
+	}
+}
+
+// evictTestConn counts the writes to the connection, which all fail, and
+// records when the connection is closed.
+type evictTestConn struct {
+	fakeNetConn
+	writes atomic.Int32
+	closed chan struct{}
+}
+
+func (c *evictTestConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return 0, errors.New("broken pipe")
+}
+
+func (c *evictTestConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestHubEvictWriteError(t *testing.T) {
+	var h Hub
+	nc := &evictTestConn{closed: make(chan struct{})}
+	c := newConn(nc, true, 1024, 1024, nil, nil, nil)
+	h.Subscribe("a", c)
+	if err := h.Broadcast("a", TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-nc.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after write error")
+	}
+	// No close message is written after the failed write.
+	if n := nc.writes.Load(); n != 1 {
+		t.Errorf("%d writes, want 1", n)
+	}
+	if stats := h.Stats("a"); stats != (TopicStats{}) {
+		t.Errorf("Stats(a) = %+v, want zero value after eviction", stats)
+	}
+}
@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrDropMessage is returned by an Interceptor to discard a message without
+// failing the connection.
+var ErrDropMessage = errors.New("websocket: message dropped by interceptor")
+
+// MessageDirection is the direction of a message passed to an Interceptor.
+type MessageDirection int
+
+const (
+	// InboundMessage denotes a message received from the peer.
+	InboundMessage MessageDirection = iota
+
+	// OutboundMessage denotes a message sent to the peer.
+	OutboundMessage
+)
+
+// Interceptor observes or transforms a message sent or received on a
+// connection. Interceptors are registered with the Interceptors field of
+// Upgrader, FastHTTPUpgrader, Dialer and FastHTTPDialer.
+//
+// The interceptor returns the data passed to the next interceptor, which is
+// the data of the message when the interceptor is the last of the chain. The
+// interceptor must not modify the data argument in place.
+//
+// The interceptor returns ErrDropMessage to discard the message. Any other
+// error rejects the message: the read or write method returns the error and,
+// if the error is a *CloseError, a close message with the error's code and
+// text is sent to the peer. A rejected inbound message fails the read side of
+// the connection.
+//
+// Interceptors are called concurrently for inbound and outbound messages and
+// must be safe for concurrent use.
+type Interceptor func(c *Conn, dir MessageDirection, messageType int, data []byte) ([]byte, error)
+
+// setInterceptors sets the interceptors of a connection after the handshake.
+func (c *Conn) setInterceptors(interceptors []Interceptor, control bool) {
+	c.interceptors = interceptors
+	c.interceptControl = control && len(interceptors) > 0
+}
+
+// intercepts reports whether the messages of the given type are intercepted.
+func (c *Conn) intercepts(messageType int) bool {
+	if isControl(messageType) {
+		return c.interceptControl
+	}
+	return isData(messageType) && len(c.interceptors) > 0
+}
+
+// intercept runs the message through the interceptors. Inbound messages pass
+// through the interceptors in order and outbound messages in reverse order, so
+// that the first interceptor is the closest to the network.
+func (c *Conn) intercept(dir MessageDirection, messageType int, data []byte) ([]byte, error) {
+	var err error
+	if dir == InboundMessage {
+		for _, f := range c.interceptors {
+			if data, err = f(c, dir, messageType, data); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for i := len(c.interceptors) - 1; i >= 0; i-- {
+			if data, err = c.interceptors[i](c, dir, messageType, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
+
+// rejectMessage sends a close message to the peer if err is a *CloseError.
+func (c *Conn) rejectMessage(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		_ = c.writeControl(CloseMessage, FormatCloseMessage(closeErr.Code, closeErr.Text), time.Now().Add(writeWait))
+	}
+	return err
+}
+
+// readInterceptedMessage reads the current message from r and runs it
+// through the interceptors.
+func (c *Conn) readInterceptedMessage(messageType int, r io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err = c.intercept(InboundMessage, messageType, data)
+	if err != nil {
+		if err == ErrDropMessage {
+			return nil, err
+		}
+		return nil, c.rejectMessage(err)
+	}
+	return bytes.NewReader(data), nil
+}
+
+// interceptWriter buffers an outbound message for the interceptors.
+type interceptWriter struct {
+	c           *Conn
+	messageType int
+	buf         bytes.Buffer
+	closed      bool
+}
+
+func (w *interceptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriteClosed
+	}
+	return w.buf.Write(p)
+}
+
+func (w *interceptWriter) Close() error {
+	if w.closed {
+		return errWriteClosed
+	}
+	w.closed = true
+	if w.c.writer == w {
+		w.c.writer = nil
+	}
+	return w.c.writeMessage(w.messageType, w.buf.Bytes())
+}
@@ -0,0 +1,224 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tagInterceptor appends the tag to the trace and to the data of text
+// messages.
+func tagInterceptor(tag string, trace *[]string) Interceptor {
+	return func(c *Conn, dir MessageDirection, messageType int, data []byte) ([]byte, error) {
+		*trace = append(*trace, tag)
+		if messageType != TextMessage {
+			return data, nil
+		}
+		return append(append([]byte(nil), data...), tag...), nil
+	}
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	var trace []string
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, false)
+	wc.setInterceptors([]Interceptor{tagInterceptor("a", &trace), tagInterceptor("b", &trace)}, false)
+	rc := newTestConn(&connBuf, io.Discard, true)
+	rc.setInterceptors([]Interceptor{tagInterceptor("c", &trace), tagInterceptor("d", &trace)}, false)
+
+	if err := wc.WriteMessage(TextMessage, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	w, err := wc.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "y")
+	io.WriteString(w, "z")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"xbacd", "yzbacd"} {
+		_, p, err := rc.ReadMessage()
+		if err != nil || string(p) != want {
+			t.Fatalf("ReadMessage() = %q, %v, want %q", p, err, want)
+		}
+	}
+	if got, want := strings.Join(trace, ""), "babacdcd"; got != want {
+		t.Errorf("interceptors called in order %q, want %q", got, want)
+	}
+}
+
+func TestInterceptorDrop(t *testing.T) {
+	drop := func(c *Conn, dir MessageDirection, messageType int, data []byte) ([]byte, error) {
+		if string(data) == "drop" {
+			return nil, ErrDropMessage
+		}
+		return data, nil
+	}
+
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, false)
+	wc.setInterceptors([]Interceptor{drop}, false)
+	if err := wc.WriteMessage(TextMessage, []byte("drop")); err != nil {
+		t.Fatalf("WriteMessage() returned %v", err)
+	}
+	if connBuf.Len() != 0 {
+		t.Fatalf("dropped message written")
+	}
+
+	wc = newTestConn(nil, &connBuf, false)
+	for _, s := range []string{"drop", "keep"} {
+		if err := wc.WriteMessage(TextMessage, []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rc := newTestConn(&connBuf, io.Discard, true)
+	rc.setInterceptors([]Interceptor{drop}, false)
+	_, p, err := rc.ReadMessage()
+	if err != nil || string(p) != "keep" {
+		t.Fatalf("ReadMessage() = %q, %v, want keep", p, err)
+	}
+}
+
+func TestInterceptorReject(t *testing.T) {
+	reject := func(c *Conn, dir MessageDirection, messageType int, data []byte) ([]byte, error) {
+		if messageType == BinaryMessage {
+			return nil, &CloseError{Code: CloseUnsupportedData, Text: "no binary"}
+		}
+		return data, nil
+	}
+
+	var connBuf, closeBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, false)
+	if err := wc.WriteMessage(BinaryMessage, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	rc := newTestConn(&connBuf, &closeBuf, true)
+	rc.setInterceptors([]Interceptor{reject}, false)
+	if _, _, err := rc.NextReader(); !IsCloseError(err, CloseUnsupportedData) {
+		t.Fatalf("NextReader() returned %v, want close error", err)
+	}
+	if _, _, err := rc.NextReader(); !IsCloseError(err, CloseUnsupportedData) {
+		t.Fatalf("second NextReader() returned %v, want close error", err)
+	}
+	pc := newTestConn(&closeBuf, io.Discard, false)
+	if _, _, err := pc.NextReader(); !IsCloseError(err, CloseUnsupportedData) {
+		t.Fatalf("peer NextReader() returned %v, want close error", err)
+	}
+
+	// An outbound message is rejected without writing the message.
+	connBuf.Reset()
+	wc = newTestConn(nil, &connBuf, true)
+	wc.setInterceptors([]Interceptor{reject}, false)
+	if err := wc.WriteMessage(BinaryMessage, []byte{1}); !IsCloseError(err, CloseUnsupportedData) {
+		t.Fatalf("WriteMessage() returned %v, want close error", err)
+	}
+	if err := wc.WriteMessage(TextMessage, []byte("x")); err != ErrCloseSent {
+		t.Fatalf("WriteMessage() after reject returned %v, want %v", err, ErrCloseSent)
+	}
+	f, err := newTestConn(&connBuf, io.Discard, false).ReadFrame()
+	if err != nil || f.Opcode != CloseMessage {
+		t.Fatalf("ReadFrame() = %d, %v, want close frame", f.Opcode, err)
+	}
+}
+
+func TestInterceptorControl(t *testing.T) {
+	var trace []string
+	record := func(c *Conn, dir MessageDirection, messageType int, data []byte) ([]byte, error) {
+		trace = append(trace, string(data))
+		if messageType == PingMessage && string(data) == "drop" {
+			return nil, ErrDropMessage
+		}
+		return bytes.ToUpper(data), nil
+	}
+
+	var connBuf, pongBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, false)
+	wc.setInterceptors([]Interceptor{record}, true)
+	for _, s := range []string{"drop", "ping"} {
+		if err := wc.WriteControl(PingMessage, []byte(s), time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wc.WriteMessage(TextMessage, []byte("text")); err != nil {
+		t.Fatal(err)
+	}
+
+	var pings []string
+	rc := newTestConn(&connBuf, &pongBuf, true)
+	rc.setInterceptors([]Interceptor{record}, true)
+	rc.SetPingHandler(func(data string) error {
+		pings = append(pings, data)
+		return nil
+	})
+	_, p, err := rc.ReadMessage()
+	if err != nil || string(p) != "TEXT" {
+		t.Fatalf("ReadMessage() = %q, %v, want TEXT", p, err)
+	}
+	if len(pings) != 1 || pings[0] != "PING" {
+		t.Errorf("pings = %q, want [PING]", pings)
+	}
+	if got, want := strings.Join(trace, ","), "drop,ping,text,PING,TEXT"; got != want {
+		t.Errorf("intercepted %q, want %q", got, want)
+	}
+
+	// Control messages are not intercepted by default.
+	trace = nil
+	wc.setInterceptors([]Interceptor{record}, false)
+	if err := wc.WriteControl(PingMessage, []byte("ping"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(trace) != 0 {
+		t.Errorf("intercepted %q, want none", trace)
+	}
+}
+
+func TestInterceptorHandshake(t *testing.T) {
+	upper := func(c *Conn, dir MessageDirection, messageType int, data []byte) ([]byte, error) {
+		if dir == InboundMessage {
+			return bytes.ToUpper(data), nil
+		}
+		return data, nil
+	}
+	upgrader := Upgrader{Interceptors: []Interceptor{upper}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		op, p, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = ws.WriteMessage(op, append(p, '!'))
+	}))
+	defer s.Close()
+
+	suffix := func(c *Conn, dir MessageDirection, messageType int, data []byte) ([]byte, error) {
+		if dir == OutboundMessage {
+			return append(append([]byte(nil), data...), '?'), nil
+		}
+		return data, nil
+	}
+	dialer := cstDialer
+	dialer.Interceptors = []Interceptor{suffix}
+	ws, _, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(TextMessage, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	_, p, err := ws.ReadMessage()
+	if err != nil || string(p) != "HI?!" {
+		t.Fatalf("ReadMessage() = %q, %v, want HI?!", p, err)
+	}
+}
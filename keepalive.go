@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrKeepAliveTimeout is returned when reading from a connection that was
+// closed because the peer did not answer a keepalive ping in time.
+var ErrKeepAliveTimeout = errors.New("websocket: keepalive timeout")
+
+// KeepAlive configures the keepalive of a connection. The keepalive sends a
+// ping at a regular interval and considers the peer dead when the pong does
+// not arrive within a timeout.
+//
+// Pongs are processed by the read methods of the connection. The application
+// must read the connection for the keepalive to work.
+type KeepAlive struct {
+	// Interval specifies the time between a pong and the next ping. The
+	// keepalive is disabled if Interval is zero.
+	Interval time.Duration
+
+	// Timeout specifies the time to wait for the pong. If Timeout is zero,
+	// then Interval is used.
+	Timeout time.Duration
+
+	// OnTimeout is called when the peer does not answer a ping in time. If
+	// OnTimeout is nil, the connection sends a close message with CloseCode
+	// and closes the underlying network connection.
+	OnTimeout func(c *Conn)
+
+	// CloseCode specifies the close code sent when the peer is considered
+	// dead. If CloseCode is zero, then CloseGoingAway is used.
+	CloseCode int
+}
+
+// keepAlive holds the state of the keepalive of a connection.
+type keepAlive struct {
+	config   KeepAlive
+	c        *Conn
+	pending  atomic.Int64 // payload of the unanswered ping, zero if none
+	rtt      atomic.Int64
+	timedOut atomic.Bool
+	pong     chan struct{}
+	done     chan struct{}
+	stop     sync.Once
+}
+
+// StartKeepAlive starts the keepalive of the connection with the given
+// configuration. StartKeepAlive is called by Upgrade and Dial when the
+// KeepAlive field of the upgrader or dialer is set. The keepalive stops when
+// the connection is closed or when sending a ping fails.
+//
+// StartKeepAlive must be called at most once per connection, before the
+// application starts reading the connection.
+func (c *Conn) StartKeepAlive(config KeepAlive) {
+	if config.Interval <= 0 || c.keepAlive != nil {
+		return
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = config.Interval
+	}
+	if config.CloseCode == 0 {
+		config.CloseCode = CloseGoingAway
+	}
+	k := &keepAlive{
+		config: config,
+		c:      c,
+		pong:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	c.keepAlive = k
+	go k.run()
+}
+
+// RTT returns the round-trip time measured by the last keepalive ping, or
+// zero if no pong was received yet.
+func (c *Conn) RTT() time.Duration {
+	if c == nil || c.keepAlive == nil {
+		return 0
+	}
+	return time.Duration(c.keepAlive.rtt.Load())
+}
+
+func (k *keepAlive) run() {
+	timer := time.NewTimer(k.config.Interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-k.done:
+			return
+		}
+
+		sent := time.Now().UnixNano()
+		var payload [8]byte
+		binary.BigEndian.PutUint64(payload[:], uint64(sent))
+		k.pending.Store(sent)
+		if err := k.c.WriteControl(PingMessage, payload[:], time.Now().Add(writeWait)); err != nil {
+			return
+		}
+
+		timer.Reset(k.config.Timeout)
+		select {
+		case <-k.pong:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(k.config.Interval)
+		case <-timer.C:
+			k.timeout()
+			return
+		case <-k.done:
+			return
+		}
+	}
+}
+
+// handlePong is called by the read methods for every pong received.
+func (k *keepAlive) handlePong(payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+	sent := int64(binary.BigEndian.Uint64(payload))
+	if sent == 0 || !k.pending.CompareAndSwap(sent, 0) {
+		return
+	}
+	rtt := time.Now().UnixNano() - sent
+	k.rtt.Store(rtt)
+	if k.c.observer != nil {
+		k.c.observer.ObserveRTT(k.c, time.Duration(rtt))
+	}
+	select {
+	case k.pong <- struct{}{}:
+	default:
+	}
+}
+
+func (k *keepAlive) timeout() {
+	k.timedOut.Store(true)
+	if k.config.OnTimeout != nil {
+		k.config.OnTimeout(k.c)
+		return
+	}
+	_ = k.c.WriteControl(CloseMessage, FormatCloseMessage(k.config.CloseCode, "keepalive timeout"), time.Now().Add(writeWait))
+	_ = k.c.Close()
+}
+
+// keepAliveReadError returns ErrKeepAliveTimeout in place of the read error
+// caused by closing the connection to a dead peer.
+func (c *Conn) keepAliveReadError(err error) error {
+	if k := c.keepAlive; k != nil && k.timedOut.Load() && k.config.OnTimeout == nil {
+		return ErrKeepAliveTimeout
+	}
+	return err
+}
+
+func (k *keepAlive) close() {
+	k.stop.Do(func() { close(k.done) })
+}
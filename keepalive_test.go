@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveRTT(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := cstUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	dialer := cstDialer
+	dialer.KeepAlive = KeepAlive{Interval: 5 * time.Millisecond, Timeout: time.Second}
+	ws, _, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ws.RTT() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("RTT not measured")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	for _, onTimeout := range []bool{false, true} {
+		timedOut := make(chan struct{})
+		readErr := make(chan error, 1)
+		upgrader := cstUpgrader
+		upgrader.KeepAlive = KeepAlive{Interval: 5 * time.Millisecond, Timeout: 20 * time.Millisecond, CloseCode: CloseServiceRestart}
+		if onTimeout {
+			upgrader.KeepAlive.OnTimeout = func(c *Conn) {
+				close(timedOut)
+				c.Close()
+			}
+		}
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+			_, _, err = ws.ReadMessage()
+			readErr <- err
+		}))
+
+		// The client does not read the connection and never answers pings.
+		ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+
+		select {
+		case err := <-readErr:
+			if !onTimeout && !errors.Is(err, ErrKeepAliveTimeout) {
+				t.Errorf("ReadMessage() returned %v, want %v", err, ErrKeepAliveTimeout)
+			}
+			if onTimeout && err == nil {
+				t.Errorf("ReadMessage() returned nil error")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("peer not detected as dead")
+		}
+
+		if onTimeout {
+			<-timedOut
+		} else {
+			for {
+				_, _, err := ws.ReadMessage()
+				if err != nil {
+					if !IsCloseError(err, CloseServiceRestart) {
+						t.Errorf("client ReadMessage() returned %v, want close %d", err, CloseServiceRestart)
+					}
+					break
+				}
+			}
+		}
+		ws.Close()
+		s.Close()
+	}
+}
@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is an Observer maintaining aggregate counters for the observed
+// connections and per-connection counters for the live connections.
+//
+// The aggregate counters are expvar variables and Metrics implements
+// expvar.Var, which allows publishing the counters with expvar.Publish:
+//
+//	var metrics websocket.Metrics
+//	expvar.Publish("websocket", &metrics)
+//	upgrader := websocket.Upgrader{Observer: &metrics}
+//
+// The zero value is ready to use. A Metrics must not be copied after first
+// use.
+type Metrics struct {
+	// ConnsOpened counts the connections that completed the handshake.
+	ConnsOpened expvar.Int
+
+	// ConnsActive counts the connections not yet closed.
+	ConnsActive expvar.Int
+
+	// MessagesRead and MessagesWritten count the text and binary messages.
+	MessagesRead    expvar.Int
+	MessagesWritten expvar.Int
+
+	// BytesRead and BytesWritten count the message bytes as seen by the
+	// application, before compression.
+	BytesRead    expvar.Int
+	BytesWritten expvar.Int
+
+	// FramesRead and FramesWritten count the frames of all types.
+	FramesRead    expvar.Int
+	FramesWritten expvar.Int
+
+	// WireBytesRead and WireBytesWritten count the frame payload bytes as
+	// sent on the network, after compression.
+	WireBytesRead    expvar.Int
+	WireBytesWritten expvar.Int
+
+	// RTTSamples and RTTTotal count the round-trip times measured by
+	// keepalives and their sum in nanoseconds.
+	RTTSamples expvar.Int
+	RTTTotal   expvar.Int
+
+	// ClosesRead and ClosesWritten count the close messages by close code.
+	ClosesRead    expvar.Map
+	ClosesWritten expvar.Map
+
+	// ProtocolErrors counts the connections failed because of a protocol
+	// error.
+	ProtocolErrors expvar.Int
+
+	// HandshakeErrors counts the failed handshakes by HTTP status.
+	HandshakeErrors expvar.Map
+
+	conns sync.Map // *Conn -> *connCounters
+}
+
+// ConnStats holds the counters of a connection.
+type ConnStats struct {
+	MessagesRead     int64
+	MessagesWritten  int64
+	BytesRead        int64
+	BytesWritten     int64
+	WireBytesRead    int64
+	WireBytesWritten int64
+
+	// RTT is the last round-trip time measured by the keepalive.
+	RTT time.Duration
+}
+
+type connCounters struct {
+	messagesRead     atomic.Int64
+	messagesWritten  atomic.Int64
+	bytesRead        atomic.Int64
+	bytesWritten     atomic.Int64
+	wireBytesRead    atomic.Int64
+	wireBytesWritten atomic.Int64
+	rtt              atomic.Int64
+}
+
+// ConnStats returns the counters of a live connection. The second result is
+// false if the connection is not observed by m or is closed.
+func (m *Metrics) ConnStats(c *Conn) (ConnStats, bool) {
+	v, ok := m.conns.Load(c)
+	if !ok {
+		return ConnStats{}, false
+	}
+	cc := v.(*connCounters)
+	return ConnStats{
+		MessagesRead:     cc.messagesRead.Load(),
+		MessagesWritten:  cc.messagesWritten.Load(),
+		BytesRead:        cc.bytesRead.Load(),
+		BytesWritten:     cc.bytesWritten.Load(),
+		WireBytesRead:    cc.wireBytesRead.Load(),
+		WireBytesWritten: cc.wireBytesWritten.Load(),
+		RTT:              time.Duration(cc.rtt.Load()),
+	}, true
+}
+
+func (m *Metrics) counters(c *Conn) *connCounters {
+	if v, ok := m.conns.Load(c); ok {
+		return v.(*connCounters)
+	}
+	// The connection is closed. Count in a throwaway value.
+	return &connCounters{}
+}
+
+// ObserveConnOpen implements Observer.
+func (m *Metrics) ObserveConnOpen(c *Conn) {
+	m.conns.Store(c, &connCounters{})
+	m.ConnsOpened.Add(1)
+	m.ConnsActive.Add(1)
+}
+
+// ObserveConnClose implements Observer.
+func (m *Metrics) ObserveConnClose(c *Conn) {
+	m.conns.Delete(c)
+	m.ConnsActive.Add(-1)
+}
+
+// ObserveFrame implements Observer.
+func (m *Metrics) ObserveFrame(c *Conn, dir MessageDirection, frameType int, size int64) {
+	cc := m.counters(c)
+	if dir == InboundMessage {
+		m.FramesRead.Add(1)
+		m.WireBytesRead.Add(size)
+		cc.wireBytesRead.Add(size)
+	} else {
+		m.FramesWritten.Add(1)
+		m.WireBytesWritten.Add(size)
+		cc.wireBytesWritten.Add(size)
+	}
+}
+
+// ObserveMessage implements Observer.
+func (m *Metrics) ObserveMessage(c *Conn, dir MessageDirection, messageType int, size int64) {
+	cc := m.counters(c)
+	if dir == InboundMessage {
+		m.MessagesRead.Add(1)
+		m.BytesRead.Add(size)
+		cc.messagesRead.Add(1)
+		cc.bytesRead.Add(size)
+	} else {
+		m.MessagesWritten.Add(1)
+		m.BytesWritten.Add(size)
+		cc.messagesWritten.Add(1)
+		cc.bytesWritten.Add(size)
+	}
+}
+
+// ObserveClose implements Observer.
+func (m *Metrics) ObserveClose(c *Conn, dir MessageDirection, code int) {
+	if dir == InboundMessage {
+		m.ClosesRead.Add(strconv.Itoa(code), 1)
+	} else {
+		m.ClosesWritten.Add(strconv.Itoa(code), 1)
+	}
+}
+
+// ObserveRTT implements Observer.
+func (m *Metrics) ObserveRTT(c *Conn, rtt time.Duration) {
+	m.RTTSamples.Add(1)
+	m.RTTTotal.Add(int64(rtt))
+	m.counters(c).rtt.Store(int64(rtt))
+}
+
+// ObserveProtocolError implements Observer.
+func (m *Metrics) ObserveProtocolError(c *Conn, err error) {
+	m.ProtocolErrors.Add(1)
+}
+
+// ObserveHandshakeError implements Observer.
+func (m *Metrics) ObserveHandshakeError(status int, err error) {
+	m.HandshakeErrors.Add(strconv.Itoa(status), 1)
+}
+
+// String returns the aggregate counters as a JSON object. String implements
+// expvar.Var.
+func (m *Metrics) String() string {
+	vars := []struct {
+		name string
+		v    expvar.Var
+	}{
+		{"connsOpened", &m.ConnsOpened},
+		{"connsActive", &m.ConnsActive},
+		{"messagesRead", &m.MessagesRead},
+		{"messagesWritten", &m.MessagesWritten},
+		{"bytesRead", &m.BytesRead},
+		{"bytesWritten", &m.BytesWritten},
+		{"framesRead", &m.FramesRead},
+		{"framesWritten", &m.FramesWritten},
+		{"wireBytesRead", &m.WireBytesRead},
+		{"wireBytesWritten", &m.WireBytesWritten},
+		{"rttSamples", &m.RTTSamples},
+		{"rttTotal", &m.RTTTotal},
+		{"closesRead", &m.ClosesRead},
+		{"closesWritten", &m.ClosesWritten},
+		{"protocolErrors", &m.ProtocolErrors},
+		{"handshakeErrors", &m.HandshakeErrors},
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range vars {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.Quote(v.name))
+		b.WriteString(": ")
+		b.WriteString(v.v.String())
+	}
+	b.WriteByte('}')
+	return b.String()
+}
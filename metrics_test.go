@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMetricsConn(t *testing.T) {
+	var m Metrics
+	var connBuf, closeBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, false)
+	wc.startObserving(&m)
+
+	if err := wc.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := wc.NextWriter(BinaryMessage)
+	w.Write(make([]byte, 200))
+	w.Close()
+	if err := wc.WriteControl(PingMessage, []byte("ping"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wc.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, ok := m.ConnStats(wc)
+	if !ok {
+		t.Fatal("ConnStats() returned false for live connection")
+	}
+	want := ConnStats{MessagesWritten: 2, BytesWritten: 205, WireBytesWritten: 211}
+	if stats != want {
+		t.Errorf("writer ConnStats() = %+v, want %+v", stats, want)
+	}
+
+	rc := newTestConn(&connBuf, &closeBuf, true)
+	rc.startObserving(&m)
+	for i := 0; i < 2; i++ {
+		if _, _, err := rc.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := rc.ReadMessage(); !IsCloseError(err, CloseGoingAway) {
+		t.Fatalf("ReadMessage() returned %v, want close error", err)
+	}
+	stats, _ = m.ConnStats(rc)
+	want = ConnStats{MessagesRead: 2, BytesRead: 205, WireBytesRead: 211, WireBytesWritten: 6}
+	if stats != want {
+		t.Errorf("reader ConnStats() = %+v, want %+v", stats, want)
+	}
+
+	wc.Close()
+	rc.Close()
+	rc.Close()
+	if _, ok := m.ConnStats(wc); ok {
+		t.Error("ConnStats() returned true for closed connection")
+	}
+
+	for _, c := range []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"ConnsOpened", m.ConnsOpened.Value(), 2},
+		{"ConnsActive", m.ConnsActive.Value(), 0},
+		{"MessagesRead", m.MessagesRead.Value(), 2},
+		{"MessagesWritten", m.MessagesWritten.Value(), 2},
+		{"FramesRead", m.FramesRead.Value(), 4},
+		// The reader answered the ping and the close message.
+		{"FramesWritten", m.FramesWritten.Value(), 6},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %d, want %d", c.name, c.got, c.want)
+		}
+	}
+	if got := m.ClosesRead.Get("1001"); got == nil || got.String() != "1" {
+		t.Errorf("ClosesRead[1001] = %v, want 1", got)
+	}
+	if got := m.ClosesWritten.Get("1001"); got == nil || got.String() != "2" {
+		t.Errorf("ClosesWritten[1001] = %v, want 2", got)
+	}
+
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(m.String()), &v); err != nil {
+		t.Fatalf("String() returned invalid JSON %q: %v", m.String(), err)
+	}
+	if v["messagesRead"] != 2.0 {
+		t.Errorf("String() messagesRead = %v, want 2", v["messagesRead"])
+	}
+}
+
+func TestMetricsCompression(t *testing.T) {
+	var m Metrics
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, true)
+	wc.enableDeflate(deflateParams{})
+	wc.startObserving(&m)
+	data := bytes.Repeat([]byte("compress me "), 100)
+	if err := wc.WriteMessage(TextMessage, data); err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := m.ConnStats(wc)
+	if stats.BytesWritten != int64(len(data)) || stats.WireBytesWritten >= stats.BytesWritten {
+		t.Errorf("ConnStats() = %+v, want %d message bytes and fewer wire bytes", stats, len(data))
+	}
+}
+
+func TestMetricsProtocolError(t *testing.T) {
+	var m Metrics
+	var connBuf bytes.Buffer
+	wc := newTestConn(nil, &connBuf, false)
+	wc.WriteMessage(TextMessage, []byte{0xff})
+	rc := newTestConn(&connBuf, io.Discard, true)
+	rc.startObserving(&m)
+	if _, _, err := rc.ReadMessage(); !errors.Is(err, errInvalidUTF8) {
+		t.Fatalf("ReadMessage() returned %v, want %v", err, errInvalidUTF8)
+	}
+	if m.ProtocolErrors.Value() != 1 {
+		t.Errorf("ProtocolErrors = %d, want 1", m.ProtocolErrors.Value())
+	}
+	if got := m.ClosesWritten.Get("1007"); got == nil || got.String() != "1" {
+		t.Errorf("ClosesWritten[1007] = %v, want 1", got)
+	}
+}
+
+func TestMetricsHandshake(t *testing.T) {
+	var m Metrics
+	upgrader := Upgrader{Observer: &m}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.ReadMessage()
+	}))
+	defer s.Close()
+
+	var dm Metrics
+	dialer := cstDialer
+	dialer.Observer = &dm
+	if _, _, err := dialer.Dial(makeWsProto(s.URL), http.Header{"Origin": {"http://other.example.com"}}); !errors.Is(err, ErrBadHandshake) {
+		t.Fatalf("Dial() returned %v, want %v", err, ErrBadHandshake)
+	}
+	if got := m.HandshakeErrors.Get("403"); got == nil || got.String() != "1" {
+		t.Errorf("upgrader HandshakeErrors[403] = %v, want 1", got)
+	}
+	if got := dm.HandshakeErrors.Get("403"); got == nil || got.String() != "1" {
+		t.Errorf("dialer HandshakeErrors[403] = %v, want 1", got)
+	}
+
+	ws, _, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dm.ConnsActive.Value() != 1 {
+		t.Errorf("dialer ConnsActive = %d, want 1", dm.ConnsActive.Value())
+	}
+	ws.Close()
+	if dm.ConnsActive.Value() != 0 {
+		t.Errorf("dialer ConnsActive = %d, want 0", dm.ConnsActive.Value())
+	}
+	if !strings.Contains(dm.String(), `"connsOpened": 1`) {
+		t.Errorf("String() = %s, want connsOpened 1", dm.String())
+	}
+}
+
+func TestMetricsFastHTTPHandlerReturn(t *testing.T) {
+	var m Metrics
+	upgrader := FastHTTPUpgrader{Observer: &m}
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		// The handler returns without closing the connection.
+		_ = upgrader.Upgrade(ctx, func(c *Conn) {})
+	})
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Fatal("ReadMessage() returned nil error")
+	}
+	if m.ConnsOpened.Value() != 1 || m.ConnsActive.Value() != 0 {
+		t.Errorf("ConnsOpened = %d, ConnsActive = %d, want 1, 0", m.ConnsOpened.Value(), m.ConnsActive.Value())
+	}
+}
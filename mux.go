@@ -0,0 +1,584 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MuxSubprotocol is the subprotocol of connections carrying multiplexed
+// streams. Add MuxSubprotocol to the Subprotocols of the upgrader and of the
+// dialer to negotiate multiplexing.
+const MuxSubprotocol = "wsmux.v1"
+
+const (
+	// muxInitialWindow is the initial flow-control window of a stream
+	// defined by the protocol.
+	muxInitialWindow = 256 << 10
+
+	// muxMaxChunk is the maximum payload of a data frame.
+	muxMaxChunk = 16 << 10
+
+	defaultMuxAcceptBacklog = 16
+)
+
+// Frame types of the multiplexing protocol. Each frame is sent as a binary
+// message starting with the frame type and the 32-bit stream ID.
+const (
+	muxFrameOpen   = 0 // opens the stream
+	muxFrameData   = 1 // flags byte followed by message data
+	muxFrameWindow = 2 // 32-bit flow-control window increment
+	muxFrameClose  = 3 // close code and text, as in a close message
+)
+
+// muxFin is set in the flags of the data frame ending a message. The other
+// bits hold the message type.
+const muxFin = 0x80
+
+var (
+	// ErrMuxNotNegotiated is returned by NewMux when the connection's
+	// subprotocol is not MuxSubprotocol.
+	ErrMuxNotNegotiated = errors.New("websocket: mux subprotocol not negotiated")
+
+	errMuxProtocol = errors.New("websocket: mux protocol error")
+)
+
+// MuxConfig configures a Mux.
+type MuxConfig struct {
+	// Window specifies the number of bytes a peer may send on a stream
+	// before the data is read by the application. If Window is less than 256
+	// KiB, then 256 KiB is used.
+	Window int
+
+	// AcceptBacklog specifies the number of streams opened by the peer and
+	// waiting for Accept. Streams opened when the backlog is full are closed
+	// with CloseTryAgainLater. If AcceptBacklog is zero, then a default of 16
+	// is used.
+	AcceptBacklog int
+}
+
+// Mux carries independent logical streams over a connection. Each stream
+// has its own messages, flow-control window and close handshake.
+//
+// Both peers of the connection must use a Mux. A goroutine owned by the Mux
+// reads the connection; the application must not read or write the
+// connection other than with WriteControl and Close after NewMux returns.
+//
+// It is safe to call Mux's methods concurrently.
+type Mux struct {
+	c       *Conn
+	window  int
+	accepts chan *MuxStream
+	done    chan struct{}
+
+	wmu sync.Mutex // serializes writes to the connection
+
+	mu           sync.Mutex
+	streams      map[uint32]*MuxStream
+	nextID       uint32
+	lastRemoteID uint32
+	err          error
+}
+
+// NewMux starts multiplexing streams over c. The subprotocol of c must be
+// MuxSubprotocol.
+func NewMux(c *Conn, config MuxConfig) (*Mux, error) {
+	if c.Subprotocol() != MuxSubprotocol {
+		return nil, ErrMuxNotNegotiated
+	}
+	if config.Window < muxInitialWindow {
+		config.Window = muxInitialWindow
+	}
+	if config.AcceptBacklog <= 0 {
+		config.AcceptBacklog = defaultMuxAcceptBacklog
+	}
+	m := &Mux{
+		c:       c,
+		window:  config.Window,
+		accepts: make(chan *MuxStream, config.AcceptBacklog),
+		done:    make(chan struct{}),
+		streams: make(map[uint32]*MuxStream),
+		nextID:  2,
+	}
+	if !c.isServer {
+		// Clients open odd streams and servers open even streams.
+		m.nextID = 1
+	}
+	go m.run()
+	return m, nil
+}
+
+// Open opens a new stream.
+func (m *Mux) Open() (*MuxStream, error) {
+	// Hold the write lock to send the open frames in the order of the IDs.
+	m.wmu.Lock()
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		m.wmu.Unlock()
+		return nil, m.err
+	}
+	s := m.newStreamLocked(m.nextID)
+	m.nextID += 2
+	m.mu.Unlock()
+	err := m.c.WriteMessage(BinaryMessage, muxFrame(muxFrameOpen, s.id, 0))
+	m.wmu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.extendWindow(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Accept waits for the next stream opened by the peer.
+func (m *Mux) Accept() (*MuxStream, error) {
+	select {
+	case s := <-m.accepts:
+		if err := s.extendWindow(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case <-m.done:
+		return nil, m.err
+	}
+}
+
+// Close closes the connection. The pending and future operations of the
+// streams return net.ErrClosed.
+func (m *Mux) Close() error {
+	m.fail(net.ErrClosed)
+	return m.c.Close()
+}
+
+func (m *Mux) newStreamLocked(id uint32) *MuxStream {
+	s := &MuxStream{m: m, id: id, sendWindow: muxInitialWindow, recvWindow: muxInitialWindow}
+	s.cond.L = &s.mu
+	m.streams[id] = s
+	return s
+}
+
+func (m *Mux) stream(id uint32) *MuxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[id]
+}
+
+func (m *Mux) remove(id uint32) {
+	m.mu.Lock()
+	delete(m.streams, id)
+	m.mu.Unlock()
+}
+
+// muxFrame returns a frame with room for n bytes after the header.
+func muxFrame(frameType byte, id uint32, n int) []byte {
+	p := make([]byte, 5, 5+n)
+	p[0] = frameType
+	binary.BigEndian.PutUint32(p[1:], id)
+	return p
+}
+
+func (m *Mux) writeFrame(p []byte) error {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	return m.c.WriteMessage(BinaryMessage, p)
+}
+
+func (m *Mux) writeClose(id uint32, code int, text string) error {
+	return m.writeFrame(append(muxFrame(muxFrameClose, id, 2+len(text)), FormatCloseMessage(code, text)...))
+}
+
+// fail ends the streams with err.
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.err = err
+	close(m.done)
+	streams := m.streams
+	m.streams = nil
+	m.mu.Unlock()
+
+	for _, s := range streams {
+		s.mu.Lock()
+		if s.remoteErr == nil {
+			s.remoteErr = err
+		}
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+// run reads the frames of the connection.
+func (m *Mux) run() {
+	for {
+		messageType, p, err := m.c.ReadMessage()
+		if err == nil {
+			if messageType != BinaryMessage {
+				err = errMuxProtocol
+			} else {
+				err = m.handleFrame(p)
+			}
+			if err != nil {
+				_ = m.c.WriteControl(CloseMessage, FormatCloseMessage(CloseProtocolError, err.Error()), time.Now().Add(writeWait))
+				m.c.Close()
+			}
+		}
+		if err != nil {
+			m.fail(err)
+			return
+		}
+	}
+}
+
+func (m *Mux) handleFrame(p []byte) error {
+	if len(p) < 5 {
+		return errMuxProtocol
+	}
+	frameType, id, body := p[0], binary.BigEndian.Uint32(p[1:]), p[5:]
+
+	if frameType == muxFrameOpen {
+		m.mu.Lock()
+		if m.err != nil {
+			m.mu.Unlock()
+			return nil
+		}
+		if id%2 == m.nextID%2 || id <= m.lastRemoteID {
+			m.mu.Unlock()
+			return errMuxProtocol
+		}
+		m.lastRemoteID = id
+		s := m.newStreamLocked(id)
+		m.mu.Unlock()
+		select {
+		case m.accepts <- s:
+		default:
+			m.remove(id)
+			// Write from another goroutine to keep reading when the peer
+			// is blocked on writes.
+			go m.writeClose(id, CloseTryAgainLater, "accept backlog full")
+		}
+		return nil
+	}
+
+	s := m.stream(id)
+	if s == nil {
+		// The stream is closed.
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch frameType {
+	case muxFrameData:
+		if len(body) < 1 {
+			return errMuxProtocol
+		}
+		data := body[1:]
+		if len(data) > s.recvWindow {
+			return errMuxProtocol
+		}
+		s.recvWindow -= len(data)
+		if !s.localClosed {
+			s.chunks = append(s.chunks, muxChunk{messageType: int(body[0] &^ muxFin), data: data, fin: body[0]&muxFin != 0})
+		}
+	case muxFrameWindow:
+		if len(body) != 4 {
+			return errMuxProtocol
+		}
+		s.sendWindow += int(binary.BigEndian.Uint32(body))
+	case muxFrameClose:
+		closeErr := &CloseError{Code: CloseNoStatusReceived}
+		if len(body) >= 2 {
+			closeErr.Code = int(binary.BigEndian.Uint16(body))
+			closeErr.Text = string(body[2:])
+		}
+		s.remoteErr = closeErr
+		if s.localClosed {
+			m.remove(id)
+		}
+	default:
+		return errMuxProtocol
+	}
+	s.cond.Broadcast()
+	return nil
+}
+
+// muxChunk is the data of a data frame.
+type muxChunk struct {
+	messageType int
+	data        []byte
+	fin         bool
+}
+
+// MuxStream is a logical stream of a Mux. A stream supports one concurrent
+// reader and one concurrent writer, like Conn.
+type MuxStream struct {
+	m  *Mux
+	id uint32
+
+	// The fields below are protected by mu. The cond is signaled when
+	// data, window or errors change.
+	mu          sync.Mutex
+	cond        sync.Cond
+	chunks      []muxChunk
+	recvWindow  int // bytes the peer may send
+	consumed    int // bytes read since the last window update
+	sendWindow  int // bytes this side may send
+	remoteErr   error
+	localClosed bool
+
+	// The fields below are used by the reader only.
+	reader    *muxReader
+	inMessage bool // the current message has unread chunks
+}
+
+// ID returns the ID of the stream. Streams opened by the client have odd IDs
+// and streams opened by the server have even IDs.
+func (s *MuxStream) ID() uint32 {
+	return s.id
+}
+
+// extendWindow grows the receive window to the window of the Mux.
+func (s *MuxStream) extendWindow() error {
+	n := s.m.window - muxInitialWindow
+	if n == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	s.recvWindow += n
+	s.mu.Unlock()
+	return s.m.writeFrame(binary.BigEndian.AppendUint32(muxFrame(muxFrameWindow, s.id, 4), uint32(n)))
+}
+
+// next returns the next chunk of data received on the stream.
+func (s *MuxStream) next() (muxChunk, error) {
+	s.mu.Lock()
+	for len(s.chunks) == 0 && s.remoteErr == nil && !s.localClosed {
+		s.cond.Wait()
+	}
+	if s.localClosed {
+		s.mu.Unlock()
+		return muxChunk{}, net.ErrClosed
+	}
+	if len(s.chunks) == 0 {
+		err := s.remoteErr
+		s.mu.Unlock()
+		return muxChunk{}, err
+	}
+	ch := s.chunks[0]
+	s.chunks[0] = muxChunk{}
+	s.chunks = s.chunks[1:]
+	s.consumed += len(ch.data)
+	credit := 0
+	if s.consumed >= s.m.window/2 && s.remoteErr == nil {
+		credit = s.consumed
+		s.consumed = 0
+		s.recvWindow += credit
+	}
+	s.mu.Unlock()
+
+	if credit > 0 {
+		if err := s.m.writeFrame(binary.BigEndian.AppendUint32(muxFrame(muxFrameWindow, s.id, 4), uint32(credit))); err != nil {
+			return muxChunk{}, err
+		}
+	}
+	return ch, nil
+}
+
+// NextReader returns the next data message received on the stream. The
+// message type is either TextMessage or BinaryMessage. The unread part of
+// the previous message is discarded.
+//
+// After the peer closes the stream, NextReader returns a *CloseError with
+// the close code and text sent by the peer.
+func (s *MuxStream) NextReader() (messageType int, r io.Reader, err error) {
+	s.reader = nil
+	for s.inMessage {
+		ch, err := s.next()
+		if err != nil {
+			return noFrame, nil, err
+		}
+		s.inMessage = !ch.fin
+	}
+	ch, err := s.next()
+	if err != nil {
+		return noFrame, nil, err
+	}
+	s.inMessage = !ch.fin
+	s.reader = &muxReader{s: s, buf: ch.data, eof: ch.fin}
+	return ch.messageType, s.reader, nil
+}
+
+// ReadMessage is a helper method for getting a reader using NextReader and
+// reading from that reader to a buffer.
+func (s *MuxStream) ReadMessage() (messageType int, p []byte, err error) {
+	var r io.Reader
+	messageType, r, err = s.NextReader()
+	if err != nil {
+		return messageType, nil, err
+	}
+	p, err = io.ReadAll(r)
+	return messageType, p, err
+}
+
+// muxReader reads a message of a stream.
+type muxReader struct {
+	s   *MuxStream
+	buf []byte
+	eof bool // buf holds the last chunk of the message
+}
+
+func (r *muxReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if r.s.reader != r {
+			return 0, errors.New("websocket: read from stale mux stream reader")
+		}
+		ch, err := r.s.next()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.buf, r.eof = ch.data, ch.fin
+		r.s.inMessage = !ch.fin
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// writeErrLocked returns the error of writes to the stream. The caller
+// holds s.mu.
+func (s *MuxStream) writeErrLocked() error {
+	if s.localClosed {
+		return ErrCloseSent
+	}
+	return s.remoteErr
+}
+
+// send sends message data within the flow-control window of the stream.
+func (s *MuxStream) send(messageType int, p []byte, fin bool) error {
+	for {
+		s.mu.Lock()
+		for s.sendWindow == 0 && len(p) > 0 && s.writeErrLocked() == nil {
+			s.cond.Wait()
+		}
+		if err := s.writeErrLocked(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		n := min(len(p), s.sendWindow, muxMaxChunk)
+		s.sendWindow -= n
+		s.mu.Unlock()
+
+		last := fin && n == len(p)
+		flags := byte(messageType)
+		if last {
+			flags |= muxFin
+		}
+		frame := append(muxFrame(muxFrameData, s.id, 1+n), flags)
+		if err := s.m.writeFrame(append(frame, p[:n]...)); err != nil {
+			return err
+		}
+		p = p[n:]
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+// NextWriter returns a writer for the next message to send on the stream.
+// The writer's Close method sends the end of the message. The message type
+// is either TextMessage or BinaryMessage.
+//
+// Writes block while the flow-control window of the stream is exhausted.
+func (s *MuxStream) NextWriter(messageType int) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, errBadWriteOpCode
+	}
+	s.mu.Lock()
+	err := s.writeErrLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &muxWriter{s: s, messageType: messageType}, nil
+}
+
+// WriteMessage is a helper method for getting a writer using NextWriter,
+// writing the message and closing the writer.
+func (s *MuxStream) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errBadWriteOpCode
+	}
+	return s.send(messageType, data, true)
+}
+
+// muxWriter writes a message to a stream.
+type muxWriter struct {
+	s           *MuxStream
+	messageType int
+	buf         []byte
+	closed      bool
+}
+
+func (w *muxWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriteClosed
+	}
+	n := len(p)
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= muxMaxChunk {
+		chunk := len(w.buf) - len(w.buf)%muxMaxChunk
+		if err := w.s.send(w.messageType, w.buf[:chunk], false); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[chunk:]...)
+	}
+	return n, nil
+}
+
+func (w *muxWriter) Close() error {
+	if w.closed {
+		return errWriteClosed
+	}
+	w.closed = true
+	return w.s.send(w.messageType, w.buf, true)
+}
+
+// Close sends a close frame with CloseNormalClosure to the peer. The pending
+// and future reads of the stream return net.ErrClosed and writes return
+// ErrCloseSent.
+func (s *MuxStream) Close() error {
+	return s.CloseWithCode(CloseNormalClosure, "")
+}
+
+// CloseWithCode closes the stream like Close with the given close code and
+// text.
+func (s *MuxStream) CloseWithCode(code int, text string) error {
+	s.mu.Lock()
+	if s.localClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	s.chunks = nil
+	remoteClosed := s.remoteErr != nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	if remoteClosed {
+		s.m.remove(s.id)
+	}
+	return s.m.writeClose(s.id, code, text)
+}
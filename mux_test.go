@@ -0,0 +1,156 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+func newMuxPair(t *testing.T, config MuxConfig) (server, client *Mux) {
+	t.Helper()
+	s, c := newPipeConns()
+	s.subprotocol, c.subprotocol = MuxSubprotocol, MuxSubprotocol
+	server, err := NewMux(s, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewMux(c, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server, client
+}
+
+func TestMuxNotNegotiated(t *testing.T) {
+	s, c := newPipeConns()
+	defer s.Close()
+	defer c.Close()
+	if _, err := NewMux(c, MuxConfig{}); err != ErrMuxNotNegotiated {
+		t.Fatalf("NewMux() returned %v, want %v", err, ErrMuxNotNegotiated)
+	}
+}
+
+func TestMuxStreams(t *testing.T) {
+	server, client := newMuxPair(t, MuxConfig{})
+
+	go func() {
+		for {
+			s, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer s.Close()
+				for {
+					mt, r, err := s.NextReader()
+					if err != nil {
+						return
+					}
+					w, err := s.NextWriter(mt)
+					if err != nil {
+						return
+					}
+					if _, err := io.Copy(w, r); err != nil {
+						return
+					}
+					if err := w.Close(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	// The large message exceeds the flow-control window of the stream.
+	large := bytes.Repeat([]byte("0123456789"), muxInitialWindow/5)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := client.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if s.ID()%2 != 1 {
+				t.Errorf("ID() = %d, want odd ID for client stream", s.ID())
+			}
+			for _, msg := range [][]byte{[]byte(fmt.Sprint("hello ", i)), large, {}} {
+				// Write concurrently with reading because the echoed
+				// message is sent before the large message is received.
+				werr := make(chan error, 1)
+				go func() { werr <- s.WriteMessage(BinaryMessage, msg) }()
+				mt, p, err := s.ReadMessage()
+				if err := <-werr; err != nil {
+					t.Error(err)
+					return
+				}
+				if err != nil || mt != BinaryMessage || !bytes.Equal(p, msg) {
+					t.Errorf("ReadMessage() = %d, %d bytes, %v, want %d, %d bytes", mt, len(p), err, BinaryMessage, len(msg))
+					return
+				}
+			}
+			if err := s.Close(); err != nil {
+				t.Error(err)
+			}
+			if _, _, err := s.ReadMessage(); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("ReadMessage() after Close returned %v, want %v", err, net.ErrClosed)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMuxClose(t *testing.T) {
+	server, client := newMuxPair(t, MuxConfig{Window: 1 << 20})
+
+	s, err := server.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ID()%2 != 0 {
+		t.Errorf("ID() = %d, want even ID for server stream", s.ID())
+	}
+	if err := s.WriteMessage(TextMessage, []byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CloseWithCode(CloseGoingAway, "done"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteMessage(TextMessage, []byte("x")); err != ErrCloseSent {
+		t.Errorf("WriteMessage() after Close returned %v, want %v", err, ErrCloseSent)
+	}
+
+	cs, err := client.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt, p, err := cs.ReadMessage(); err != nil || mt != TextMessage || string(p) != "bye" {
+		t.Fatalf("ReadMessage() = %d, %q, %v, want %d, bye", mt, p, err, TextMessage)
+	}
+	_, _, err = cs.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Text != "done" {
+		t.Fatalf("ReadMessage() returned %v, want close error %d", err, CloseGoingAway)
+	}
+	if err := cs.WriteMessage(TextMessage, []byte("x")); !errors.As(err, &closeErr) {
+		t.Errorf("WriteMessage() after remote close returned %v, want close error", err)
+	}
+
+	client.Close()
+	if _, err := server.Accept(); err == nil {
+		t.Error("Accept() after connection close returned nil error")
+	}
+	if _, err := client.Open(); err != net.ErrClosed {
+		t.Errorf("Open() after Close returned %v, want %v", err, net.ErrClosed)
+	}
+}
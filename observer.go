@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Observer receives events from connections. Observers are registered with
+// the Observer field of Upgrader, FastHTTPUpgrader, Dialer and
+// FastHTTPDialer. The Metrics type is an Observer maintaining counters.
+//
+// The methods are called synchronously from the read and write methods of the
+// connection and concurrently for the reads and writes of a connection. The
+// methods must be safe for concurrent use and should not block.
+type Observer interface {
+	// ObserveConnOpen is called when a connection completes the handshake.
+	ObserveConnOpen(c *Conn)
+
+	// ObserveConnClose is called when the connection's Close method is first
+	// called, or when the handler of a connection upgraded by
+	// FastHTTPUpgrader returns without closing the connection.
+	ObserveConnClose(c *Conn)
+
+	// ObserveFrame is called for each frame read or written. The frame type
+	// is one of the message types or ContinuationFrame. The size is the
+	// length of the frame payload as sent on the network, which is the
+	// compressed size when compression is used.
+	ObserveFrame(c *Conn, dir MessageDirection, frameType int, size int64)
+
+	// ObserveMessage is called for each text and binary message written and
+	// for each message read to the end by the application. The size is the
+	// length of the message data as seen by the application, which is the
+	// uncompressed size when compression is used.
+	ObserveMessage(c *Conn, dir MessageDirection, messageType int, size int64)
+
+	// ObserveClose is called for each close message read or written with the
+	// close code of the message, or CloseNoStatusReceived when the message
+	// has no code.
+	ObserveClose(c *Conn, dir MessageDirection, code int)
+
+	// ObserveRTT is called with the round-trip time measured by the
+	// connection's keepalive.
+	ObserveRTT(c *Conn, rtt time.Duration)
+
+	// ObserveProtocolError is called when the connection fails because the
+	// peer violated the protocol or the read limit.
+	ObserveProtocolError(c *Conn, err error)
+
+	// ObserveHandshakeError is called when a handshake fails. The status is
+	// the HTTP status of the handshake response, or zero if no response was
+	// sent or received.
+	ObserveHandshakeError(status int, err error)
+}
+
+// startObserving registers the observer of a connection after the
+// handshake.
+func (c *Conn) startObserving(o Observer) {
+	if o == nil {
+		return
+	}
+	c.observer = o
+	o.ObserveConnOpen(c)
+}
+
+func (c *Conn) observeFrame(dir MessageDirection, frameType int, size int64) {
+	if c.observer != nil {
+		c.observer.ObserveFrame(c, dir, frameType, size)
+	}
+}
+
+func (c *Conn) observeMessage(dir MessageDirection, messageType int, size int64) {
+	if c.observer != nil {
+		c.observer.ObserveMessage(c, dir, messageType, size)
+	}
+}
+
+func (c *Conn) observeProtocolError(err error) {
+	if c.observer != nil {
+		c.observer.ObserveProtocolError(c, err)
+	}
+}
+
+// framePayloadLength returns the payload length encoded in a frame header.
+func framePayloadLength(frame []byte) int64 {
+	n := int64(frame[1] & 0x7f)
+	switch n {
+	case 126:
+		n = int64(binary.BigEndian.Uint16(frame[2:]))
+	case 127:
+		n = int64(binary.BigEndian.Uint64(frame[2:]))
+	}
+	return n
+}
+
+// observeReader reports a received message when it is read to the end.
+type observeReader struct {
+	c           *Conn
+	messageType int
+	r           io.ReadCloser
+	n           int64
+	done        bool
+}
+
+func (r *observeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err == io.EOF && !r.done {
+		r.done = true
+		r.c.observeMessage(InboundMessage, r.messageType, r.n)
+	}
+	return n, err
+}
+
+func (r *observeReader) Close() error {
+	return r.r.Close()
+}
+
+// observeWriter reports a sent message when it is closed.
+type observeWriter struct {
+	c           *Conn
+	messageType int
+	w           io.WriteCloser
+	n           int64
+}
+
+func (w *observeWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *observeWriter) Close() error {
+	err := w.w.Close()
+	if err == nil {
+		w.c.observeMessage(OutboundMessage, w.messageType, w.n)
+	}
+	return err
+}
@@ -85,7 +85,7 @@ func (pm *PreparedMessage) frame(key prepareKey) (int, []byte, error) {
 			writeBuf:               make([]byte, defaultWriteBufferSize+maxFrameHeaderSize),
 		}
 		if key.compress {
-			c.newCompressionWriter = compressNoContextTakeover
+			c.enableDeflate(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
 		}
 		err = c.WriteMessage(pm.messageType, pm.data)
 		frame.data = nc.buf.Bytes()
@@ -44,7 +44,7 @@ func TestPreparedMessage(t *testing.T) {
 		var buf bytes.Buffer
 		c := newTestConn(nil, &buf, tt.isServer)
 		if tt.enableWriteCompression {
-			c.newCompressionWriter = compressNoContextTakeover
+			c.enableDeflate(deflateParams{serverNoContextTakeover: true, clientNoContextTakeover: true})
 		}
 		if err := c.SetCompressionLevel(tt.compressionLevel); err != nil {
 			t.Fatal(err)
@@ -0,0 +1,470 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackoffMin    = 500 * time.Millisecond
+	defaultBackoffMax    = 30 * time.Second
+	defaultBackoffFactor = 2
+	defaultBackoffJitter = 0.5
+)
+
+// ErrNotConnected is returned when writing to a ReconnectingConn that is not
+// connected and does not buffer messages.
+var ErrNotConnected = errors.New("websocket: not connected")
+
+var errNotStarted = errors.New("websocket: ReconnectingConn not started")
+
+// ConnState is the state of a ReconnectingConn.
+type ConnState int
+
+const (
+	// StateDisconnected is the state before the first connection and while
+	// waiting to reconnect.
+	StateDisconnected ConnState = iota
+
+	// StateConnecting is the state while dialing the server and running the
+	// OnConnect hook.
+	StateConnecting
+
+	// StateConnected is the state while the connection is established.
+	StateConnected
+
+	// StateClosed is the final state after Close is called or after the
+	// ReconnectingConn gives up reconnecting.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Backoff configures the delay between reconnection attempts. The delay
+// starts at Min and is multiplied by Factor after each failed attempt, up to
+// Max.
+type Backoff struct {
+	// Min specifies the delay before the first reconnection attempt. If Min
+	// is zero, then a default of 500 milliseconds is used.
+	Min time.Duration
+
+	// Max specifies the maximum delay. If Max is zero, then a default of 30
+	// seconds is used.
+	Max time.Duration
+
+	// Factor specifies the multiplier of the delay after each failed
+	// attempt. If Factor is less than one, then a default of 2 is used.
+	Factor float64
+
+	// Jitter specifies the fraction of the delay that is randomized. The
+	// delay is chosen uniformly between delay*(1-Jitter) and delay. If Jitter
+	// is zero, then a default of 0.5 is used. A negative Jitter disables the
+	// randomization.
+	Jitter float64
+}
+
+// delay returns the delay after the given number of consecutive failed
+// attempts.
+func (b Backoff) delay(failures int) time.Duration {
+	min, max, factor, jitter := b.Min, b.Max, b.Factor, b.Jitter
+	if min <= 0 {
+		min = defaultBackoffMin
+	}
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+	if factor < 1 {
+		factor = defaultBackoffFactor
+	}
+	if jitter == 0 {
+		jitter = defaultBackoffJitter
+	}
+	d := float64(min)
+	for i := 1; i < failures && d < float64(max); i++ {
+		d *= factor
+	}
+	if d > float64(max) {
+		d = float64(max)
+	}
+	if jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= d * jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// ReconnectingConn is a client connection that dials the server again when
+// the connection is lost.
+//
+// The connection is dialed by Start and redialed with exponential backoff
+// when reading fails, unless the server closed the connection with
+// CloseNormalClosure or Close was called. Dialing stops without retrying when
+// the URL is malformed or when the server rejects the handshake with a 4xx
+// status other than 408 (Request Timeout) and 429 (Too Many Requests). The
+// OnConnect hook runs after each handshake so that the application can
+// restore the state of the session, such as subscriptions.
+//
+// Messages written while disconnected are buffered up to BufferSize and sent
+// in order after the next OnConnect hook. Messages are read with ReadMessage
+// from whichever connection is established.
+//
+// The fields must not be changed after Start is called. It is safe to call
+// the WriteMessage, State, Conn and Close methods concurrently. The
+// application must call ReadMessage from a single goroutine.
+type ReconnectingConn struct {
+	// Dialer specifies the dialer of the connections. If Dialer is nil,
+	// DefaultDialer is used.
+	Dialer *Dialer
+
+	// URL specifies the URL of the server.
+	URL string
+
+	// Header specifies the request header of the handshakes.
+	Header http.Header
+
+	// Backoff configures the delay between reconnection attempts.
+	Backoff Backoff
+
+	// MaxAttempts specifies the number of consecutive failed attempts after
+	// which the ReconnectingConn gives up and closes. If MaxAttempts is zero,
+	// attempts are not limited.
+	MaxAttempts int
+
+	// BufferSize specifies the number of messages buffered while
+	// disconnected. If BufferSize is zero, then writes fail with
+	// ErrNotConnected while disconnected. Writes fail with ErrWriteQueueFull
+	// when the buffer is full.
+	BufferSize int
+
+	// OnConnect is called with each new connection before buffered messages
+	// are sent. The connection is read concurrently with the hook, so the
+	// hook may wait for responses received with ReadMessage. If OnConnect
+	// returns an error, the connection is closed and redialed.
+	OnConnect func(c *Conn) error
+
+	// OnStateChange is called when the state changes. The error is the
+	// reason of the disconnection for StateDisconnected and StateClosed, or
+	// nil.
+	OnStateChange func(state ConnState, err error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	msgs   chan readResult
+	done   chan struct{}
+
+	wmu     sync.Mutex // serializes writes to the connection
+	mu      sync.Mutex
+	state   ConnState
+	conn    *Conn
+	pending []queuedMessage
+	err     error // error returned by ReadMessage once closed
+}
+
+type readResult struct {
+	messageType int
+	data        []byte
+}
+
+// Start starts dialing the server in a new goroutine. Start must be called
+// once.
+func (rc *ReconnectingConn) Start() {
+	rc.ctx, rc.cancel = context.WithCancel(context.Background())
+	rc.msgs = make(chan readResult)
+	rc.done = make(chan struct{})
+	go rc.run()
+}
+
+// State returns the current state.
+func (rc *ReconnectingConn) State() ConnState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.state
+}
+
+// Conn returns the current connection, or nil if not connected.
+func (rc *ReconnectingConn) Conn() *Conn {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.conn
+}
+
+// ReadMessage reads the next data message from the current connection,
+// waiting for a connection if needed. Once closed, ReadMessage returns
+// net.ErrClosed after Close, or the error that stopped the reconnection.
+// ReadMessage returns an error if Start has not been called.
+func (rc *ReconnectingConn) ReadMessage() (messageType int, p []byte, err error) {
+	if rc.done == nil {
+		return noFrame, nil, errNotStarted
+	}
+	select {
+	case m := <-rc.msgs:
+		return m.messageType, m.data, nil
+	case <-rc.done:
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		return noFrame, nil, rc.err
+	}
+}
+
+// WriteMessage writes a message to the current connection, or buffers the
+// message when disconnected.
+func (rc *ReconnectingConn) WriteMessage(messageType int, data []byte) error {
+	rc.wmu.Lock()
+	defer rc.wmu.Unlock()
+	rc.mu.Lock()
+	c := rc.conn
+	if c == nil {
+		defer rc.mu.Unlock()
+		switch {
+		case rc.state == StateClosed:
+			return net.ErrClosed
+		case rc.BufferSize == 0:
+			return ErrNotConnected
+		case len(rc.pending) >= rc.BufferSize:
+			return ErrWriteQueueFull
+		}
+		rc.pending = append(rc.pending, queuedMessage{messageType: messageType, data: append([]byte(nil), data...)})
+		return nil
+	}
+	rc.mu.Unlock()
+	return c.WriteMessage(messageType, data)
+}
+
+// Close stops reconnecting, sends a close message with CloseNormalClosure on
+// the current connection and closes it.
+func (rc *ReconnectingConn) Close() error {
+	rc.mu.Lock()
+	if rc.state == StateClosed {
+		rc.mu.Unlock()
+		return nil
+	}
+	c := rc.conn
+	rc.conn = nil
+	rc.pending = nil
+	rc.finishLocked(net.ErrClosed)
+	rc.mu.Unlock()
+
+	rc.notify(StateClosed, nil)
+	if rc.cancel != nil {
+		rc.cancel()
+	}
+	if c == nil {
+		return nil
+	}
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(writeWait))
+	return c.Close()
+}
+
+// finishLocked moves to StateClosed. The caller holds rc.mu.
+func (rc *ReconnectingConn) finishLocked(err error) {
+	rc.state = StateClosed
+	rc.err = err
+	if rc.done != nil {
+		close(rc.done)
+	}
+}
+
+// setState changes the state unless closed and reports whether the state
+// was changed.
+func (rc *ReconnectingConn) setState(state ConnState, err error) bool {
+	rc.mu.Lock()
+	if rc.state == StateClosed {
+		rc.mu.Unlock()
+		return false
+	}
+	rc.state = state
+	if state == StateClosed {
+		rc.conn = nil
+		rc.pending = nil
+		rc.finishLocked(err)
+	}
+	rc.mu.Unlock()
+	rc.notify(state, err)
+	return true
+}
+
+func (rc *ReconnectingConn) notify(state ConnState, err error) {
+	if rc.OnStateChange != nil {
+		rc.OnStateChange(state, err)
+	}
+}
+
+// run dials and redials the server until closed.
+func (rc *ReconnectingConn) run() {
+	defer rc.cancel()
+	d := rc.Dialer
+	if d == nil {
+		d = DefaultDialer
+	}
+	failures := 0
+	for {
+		if !rc.setState(StateConnecting, nil) {
+			return
+		}
+		c, _, err := d.DialContext(rc.ctx, rc.URL, rc.Header)
+		if err != nil && !retryDial(err) {
+			rc.setState(StateClosed, err)
+			return
+		}
+		established := false
+		if err == nil {
+			established, err = rc.serve(c)
+			if !shouldReconnect(err) {
+				rc.setState(StateClosed, err)
+				return
+			}
+		}
+		if established {
+			failures = 0
+		} else {
+			failures++
+			if rc.MaxAttempts > 0 && failures >= rc.MaxAttempts {
+				rc.setState(StateClosed, err)
+				return
+			}
+		}
+		if !rc.setState(StateDisconnected, err) {
+			return
+		}
+		t := time.NewTimer(rc.Backoff.delay(failures))
+		select {
+		case <-t.C:
+		case <-rc.ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// serve runs a connection until reading fails. It reports whether the
+// connection was established and returns the error that ended it.
+func (rc *ReconnectingConn) serve(c *Conn) (bool, error) {
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	go func() {
+		readErr <- rc.readLoop(c, stop)
+	}()
+
+	err := rc.connected(c)
+	established := err == nil
+	if established {
+		err = <-readErr
+	} else {
+		// Drop a message waiting for ReadMessage.
+		close(stop)
+		c.Close()
+		<-readErr
+	}
+	rc.mu.Lock()
+	if rc.conn == c {
+		rc.conn = nil
+	}
+	rc.mu.Unlock()
+	c.Close()
+	return established, err
+}
+
+// connected runs the OnConnect hook and sends the buffered messages.
+func (rc *ReconnectingConn) connected(c *Conn) error {
+	if rc.OnConnect != nil {
+		if err := rc.OnConnect(c); err != nil {
+			return err
+		}
+	}
+
+	if err := rc.flush(c); err != nil {
+		return err
+	}
+	// Close may have been called since flush.
+	rc.mu.Lock()
+	connected := rc.state == StateConnected
+	rc.mu.Unlock()
+	if connected {
+		rc.notify(StateConnected, nil)
+	}
+	return nil
+}
+
+// flush makes c the current connection and sends the buffered messages
+// before the messages written concurrently.
+func (rc *ReconnectingConn) flush(c *Conn) error {
+	rc.wmu.Lock()
+	defer rc.wmu.Unlock()
+	rc.mu.Lock()
+	if rc.state == StateClosed {
+		rc.mu.Unlock()
+		return net.ErrClosed
+	}
+	pending := rc.pending
+	rc.pending = nil
+	rc.conn = c
+	rc.state = StateConnected
+	rc.mu.Unlock()
+
+	for _, m := range pending {
+		if err := c.WriteMessage(m.messageType, m.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLoop delivers the messages of a connection to ReadMessage until stop
+// is closed.
+func (rc *ReconnectingConn) readLoop(c *Conn, stop chan struct{}) error {
+	for {
+		messageType, p, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		select {
+		case rc.msgs <- readResult{messageType: messageType, data: p}:
+		case <-rc.done:
+			return net.ErrClosed
+		case <-stop:
+			return net.ErrClosed
+		}
+	}
+}
+
+// retryDial reports whether a failed dial is attempted again. Malformed URLs
+// and handshakes rejected by the server for a reason other than load or
+// timing fail again.
+func retryDial(err error) bool {
+	var urlErr *url.Error
+	if errors.Is(err, errMalformedURL) || errors.As(err, &urlErr) {
+		return false
+	}
+	var herr *BadHandshakeError
+	if errors.As(err, &herr) && herr.StatusCode >= 400 && herr.StatusCode < 500 {
+		return herr.StatusCode == http.StatusRequestTimeout || herr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// shouldReconnect reports whether a connection that failed with err is
+// redialed.
+func shouldReconnect(err error) bool {
+	var closeErr *CloseError
+	return !errors.As(err, &closeErr) || closeErr.Code != CloseNormalClosure
+}
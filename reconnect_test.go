@@ -0,0 +1,270 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 5 * time.Second, Jitter: -1}
+	for failures, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := b.delay(failures); got != want {
+			t.Errorf("delay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+	b.Jitter = 0
+	for i := 0; i < 100; i++ {
+		if got := b.delay(3); got < 2*time.Second || got > 4*time.Second {
+			t.Fatalf("delay(3) = %v, want between 2s and 4s", got)
+		}
+	}
+}
+
+func TestReconnectingConn(t *testing.T) {
+	var conns atomic.Int32
+	upgrader := Upgrader{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		n := conns.Add(1)
+		for {
+			mt, p, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(p) == "bye" {
+				_ = ws.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err := ws.WriteMessage(mt, []byte(fmt.Sprintf("%d:%s", n, p))); err != nil {
+				return
+			}
+			if n == 1 {
+				// Drop the first connection without a close message.
+				ws.UnderlyingConn().Close()
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	states := make(chan ConnState, 16)
+	rc := &ReconnectingConn{
+		Dialer:     &cstDialer,
+		URL:        makeWsProto(s.URL),
+		Backoff:    Backoff{Min: 100 * time.Millisecond, Jitter: -1},
+		BufferSize: 1,
+		OnConnect: func(c *Conn) error {
+			return c.WriteMessage(TextMessage, []byte("sub"))
+		},
+		OnStateChange: func(state ConnState, err error) { states <- state },
+	}
+	rc.Start()
+	defer rc.Close()
+
+	read := func(want string) {
+		t.Helper()
+		_, p, err := rc.ReadMessage()
+		if err != nil || string(p) != want {
+			t.Fatalf("ReadMessage() = %q, %v, want %q", p, err, want)
+		}
+	}
+	read("1:sub")
+	for state := range states {
+		if state == StateDisconnected {
+			break
+		}
+	}
+	if err := rc.WriteMessage(TextMessage, []byte("buffered")); err != nil {
+		t.Fatalf("WriteMessage() while disconnected returned %v", err)
+	}
+	if err := rc.WriteMessage(TextMessage, []byte("dropped")); !errors.Is(err, ErrWriteQueueFull) {
+		t.Fatalf("WriteMessage() with full buffer returned %v, want %v", err, ErrWriteQueueFull)
+	}
+	read("2:sub")
+	read("2:buffered")
+
+	if err := rc.WriteMessage(TextMessage, []byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := rc.ReadMessage()
+	if !IsCloseError(err, CloseNormalClosure) {
+		t.Fatalf("ReadMessage() returned %v, want close error %d", err, CloseNormalClosure)
+	}
+	if err := rc.WriteMessage(TextMessage, []byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("WriteMessage() after close returned %v, want %v", err, net.ErrClosed)
+	}
+
+	var got []ConnState
+	for state := range states {
+		got = append(got, state)
+		if state == StateClosed {
+			break
+		}
+	}
+	want := []ConnState{StateConnecting, StateConnected, StateClosed}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+}
+
+func TestReconnectingConnMaxAttempts(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	url := makeWsProto(s.URL)
+	s.Close()
+
+	var attempts atomic.Int32
+	rc := &ReconnectingConn{
+		Dialer:      &cstDialer,
+		URL:         url,
+		Backoff:     Backoff{Min: time.Millisecond},
+		MaxAttempts: 3,
+		OnStateChange: func(state ConnState, err error) {
+			if state == StateConnecting {
+				attempts.Add(1)
+			}
+		},
+	}
+	if err := rc.WriteMessage(TextMessage, []byte("x")); err != ErrNotConnected {
+		t.Errorf("WriteMessage() before Start returned %v, want %v", err, ErrNotConnected)
+	}
+	if _, _, err := rc.ReadMessage(); err != errNotStarted {
+		t.Errorf("ReadMessage() before Start returned %v, want %v", err, errNotStarted)
+	}
+	rc.Start()
+	if _, _, err := rc.ReadMessage(); err == nil || errors.Is(err, net.ErrClosed) {
+		t.Fatalf("ReadMessage() returned %v, want dial error", err)
+	}
+	if rc.State() != StateClosed || attempts.Load() != 3 {
+		t.Errorf("state = %v after %d attempts, want closed after 3", rc.State(), attempts.Load())
+	}
+}
+
+func TestReconnectingConnClose(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	connected := make(chan struct{})
+	rc := &ReconnectingConn{
+		Dialer: &cstDialer,
+		URL:    makeWsProto(s.URL),
+		OnStateChange: func(state ConnState, err error) {
+			if state == StateConnected {
+				close(connected)
+			}
+		},
+	}
+	rc.Start()
+	<-connected
+	if rc.Conn() == nil {
+		t.Fatal("Conn() returned nil while connected")
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rc.ReadMessage(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("ReadMessage() returned %v, want %v", err, net.ErrClosed)
+	}
+	if rc.State() != StateClosed || rc.Conn() != nil {
+		t.Errorf("state = %v, conn = %v after Close, want closed", rc.State(), rc.Conn())
+	}
+}
+
+func TestReconnectingConnPermanentError(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	defer s.Close()
+
+	for _, url := range []string{makeWsProto(s.URL), s.URL} {
+		var attempts atomic.Int32
+		rc := &ReconnectingConn{
+			Dialer:  &cstDialer,
+			URL:     url,
+			Backoff: Backoff{Min: time.Millisecond},
+			OnStateChange: func(state ConnState, err error) {
+				if state == StateConnecting {
+					attempts.Add(1)
+				}
+			},
+		}
+		rc.Start()
+		if _, _, err := rc.ReadMessage(); err == nil || errors.Is(err, net.ErrClosed) {
+			t.Fatalf("%s: ReadMessage() returned %v, want dial error", url, err)
+		}
+		if attempts.Load() != 1 {
+			t.Errorf("%s: %d attempts, want 1", url, attempts.Load())
+		}
+	}
+}
+
+func TestReconnectingConnOnConnectError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if err := ws.WriteMessage(TextMessage, []byte("hello")); err != nil {
+			return
+		}
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	var attempts atomic.Int32
+	connected := make(chan struct{})
+	rc := &ReconnectingConn{
+		Dialer:  &cstDialer,
+		URL:     makeWsProto(s.URL),
+		Backoff: Backoff{Min: time.Millisecond},
+		OnConnect: func(c *Conn) error {
+			if attempts.Add(1) == 1 {
+				// Fail after the message of the server is read.
+				time.Sleep(50 * time.Millisecond)
+				return errors.New("subscribe failed")
+			}
+			return nil
+		},
+		OnStateChange: func(state ConnState, err error) {
+			if state == StateConnected {
+				close(connected)
+			}
+		},
+	}
+	rc.Start()
+	defer rc.Close()
+
+	// The application does not read while the first connection is torn down.
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not reconnected after OnConnect error")
+	}
+	if _, p, err := rc.ReadMessage(); err != nil || string(p) != "hello" {
+		t.Errorf("ReadMessage() = %q, %v, want hello", p, err)
+	}
+}
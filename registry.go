@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ErrShuttingDown is returned by Upgrade when the upgrader's registry is
+// shutting down.
+var ErrShuttingDown = errors.New("websocket: server shutting down")
+
+// ConnRegistry tracks the live connections created by the upgraders that
+// reference it in their Registry field.
+//
+// A connection created by Upgrader is tracked until its Close method is
+// called. A connection created by FastHTTPUpgrader is tracked until its
+// handler returns.
+//
+// The zero value is ready to use. It is safe to call ConnRegistry's methods
+// concurrently.
+type ConnRegistry struct {
+	// CloseCode specifies the close code sent to the connections by Shutdown,
+	// typically CloseGoingAway or CloseServiceRestart. If CloseCode is zero,
+	// then CloseGoingAway is used.
+	CloseCode int
+
+	// CloseReason specifies the close reason sent to the connections by
+	// Shutdown.
+	CloseReason string
+
+	mu           sync.Mutex
+	cond         sync.Cond
+	conns        map[*Conn]struct{}
+	shuttingDown bool
+}
+
+func (r *ConnRegistry) init() {
+	if r.conns == nil {
+		r.conns = make(map[*Conn]struct{})
+		r.cond.L = &r.mu
+	}
+}
+
+// add starts tracking the connection. It returns false if the registry is
+// shutting down.
+func (r *ConnRegistry) add(c *Conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.init()
+	if r.shuttingDown {
+		return false
+	}
+	r.conns[c] = struct{}{}
+	c.registry = r
+	return true
+}
+
+func (r *ConnRegistry) remove(c *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.conns[c]; ok {
+		delete(r.conns, c)
+		r.cond.Broadcast()
+	}
+}
+
+func (r *ConnRegistry) isShuttingDown() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.shuttingDown
+}
+
+// Len returns the number of live connections.
+func (r *ConnRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Conns returns the live connections.
+func (r *ConnRegistry) Conns() []*Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conns := make([]*Conn, 0, len(r.conns))
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Shutdown rejects new upgrades with ErrShuttingDown, sends a close message
+// with CloseCode to the live connections and waits for the connections to be
+// released as described in the ConnRegistry documentation. The close messages
+// are sent concurrently, each with a deadline of one second or the deadline
+// of ctx if sooner. If ctx is done first, Shutdown closes the remaining
+// connections and returns the context's error. The registry cannot be reused
+// after Shutdown is called.
+func (r *ConnRegistry) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.init()
+	r.shuttingDown = true
+	conns := make([]*Conn, 0, len(r.conns))
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+
+	code := r.CloseCode
+	if code == 0 {
+		code = CloseGoingAway
+	}
+	message := FormatCloseMessage(code, r.CloseReason)
+	deadline := time.Now().Add(writeWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	// A close message waits for the pending write of the connection. Send the
+	// messages concurrently so that stuck peers do not delay the others.
+	for _, c := range conns {
+		go func(c *Conn) {
+			_ = c.WriteControl(CloseMessage, message, deadline)
+		}(c)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		r.mu.Lock()
+		r.cond.Broadcast()
+		r.mu.Unlock()
+	})
+	defer stop()
+
+	r.mu.Lock()
+	for len(r.conns) > 0 && ctx.Err() == nil {
+		r.cond.Wait()
+	}
+	conns = conns[:0]
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+
+	if len(conns) == 0 {
+		return nil
+	}
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	return ctx.Err()
+}
+
+// ShutdownServer shuts down the fasthttp server with ShutdownWithContext and
+// the connections of the registry with Shutdown. Hijacked connections are not
+// tracked by the fasthttp server.
+func (r *ConnRegistry) ShutdownServer(ctx context.Context, s *fasthttp.Server) error {
+	r.mu.Lock()
+	r.init()
+	r.shuttingDown = true
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var serverErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serverErr = s.ShutdownWithContext(ctx)
+	}()
+	err := r.Shutdown(ctx)
+	wg.Wait()
+	if serverErr != nil {
+		return serverErr
+	}
+	return err
+}
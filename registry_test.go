@@ -0,0 +1,164 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// waitLen waits until the registry tracks n connections.
+func waitLen(t *testing.T, r *ConnRegistry, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %d, want %d", r.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRegistryShutdownServer(t *testing.T) {
+	var registry ConnRegistry
+	upgrader := FastHTTPUpgrader{Registry: &registry}
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		_ = upgrader.Upgrade(ctx, fastHTTPEchoHandler)
+	})
+
+	const n = 3
+	clientErr := make(chan error, n)
+	for i := 0; i < n; i++ {
+		ws, _, err := cstDialer.Dial(s.URL, nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer ws.Close()
+		go func() {
+			for {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					clientErr <- err
+					return
+				}
+			}
+		}()
+	}
+	waitLen(t, &registry, n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := registry.ShutdownServer(ctx, s.Server); err != nil {
+		t.Fatalf("ShutdownServer() returned %v", err)
+	}
+	if registry.Len() != 0 {
+		t.Errorf("Len() = %d after shutdown, want 0", registry.Len())
+	}
+	for i := 0; i < n; i++ {
+		if err := <-clientErr; !IsCloseError(err, CloseGoingAway) {
+			t.Errorf("client ReadMessage() returned %v, want close %d", err, CloseGoingAway)
+		}
+	}
+}
+
+func TestRegistryShutdown(t *testing.T) {
+	registry := ConnRegistry{CloseCode: CloseServiceRestart}
+	upgrader := Upgrader{Registry: &registry}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	clientErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				clientErr <- err
+				return
+			}
+		}
+	}()
+	waitLen(t, &registry, 1)
+
+	if err := registry.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() returned %v", err)
+	}
+	if err := <-clientErr; !IsCloseError(err, CloseServiceRestart) {
+		t.Errorf("client ReadMessage() returned %v, want close %d", err, CloseServiceRestart)
+	}
+
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Dial() after shutdown returned %v, %v, want %v, status %d", resp, err, ErrBadHandshake, http.StatusServiceUnavailable)
+	}
+}
+
+func TestRegistryShutdownTimeout(t *testing.T) {
+	var registry ConnRegistry
+	upgrader := Upgrader{Registry: &registry}
+	conns := make(chan *Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The application never reads or closes the connection.
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			conns <- ws
+		}
+	}))
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sc := <-conns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := registry.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if registry.Len() != 0 {
+		t.Errorf("Len() = %d after shutdown, want 0", registry.Len())
+	}
+	if err := sc.WriteMessage(TextMessage, []byte("x")); err == nil {
+		t.Errorf("WriteMessage() on closed connection returned nil error")
+	}
+}
+
+func TestRegistryShutdownStuckPeers(t *testing.T) {
+	var registry ConnRegistry
+	for i := 0; i < 5; i++ {
+		c, _ := newPipeConns()
+		// Hold the write lock as a write blocked on the peer does.
+		<-c.mu
+		registry.add(c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := registry.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > writeWait/2 {
+		t.Errorf("Shutdown() took %v", d)
+	}
+}
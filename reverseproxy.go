@@ -0,0 +1,338 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultProxyCloseTimeout = 5 * time.Second
+	defaultProxyMaxFrameSize = 1 << 20
+)
+
+// ProxyDirection is the direction of a frame forwarded by ReverseProxy.
+type ProxyDirection int
+
+const (
+	// ClientToBackend denotes a frame received from the client.
+	ClientToBackend ProxyDirection = iota
+
+	// BackendToClient denotes a frame received from the backend.
+	BackendToClient
+)
+
+// ProxyRequest is the handshake request sent by ReverseProxy to the backend.
+type ProxyRequest struct {
+	// URL is the WebSocket URL of the backend.
+	URL string
+
+	// Header holds the headers sent to the backend. The subprotocols
+	// requested by the client are set separately.
+	Header http.Header
+}
+
+// ReverseProxy forwards WebSocket connections to a backend.
+//
+// The proxy dials the backend before upgrading the client connection,
+// forwarding the subprotocols requested by the client and the headers listed
+// in ForwardHeaders. The client connection is upgraded with the subprotocol
+// selected by the backend. The proxy then forwards frames in both directions
+// without reassembling messages. Close frames are forwarded so that the
+// client and the backend perform the closing handshake with each other.
+// Ping and pong frames are forwarded without being answered by the proxy.
+//
+// Because frames are forwarded as received, the proxy does not negotiate
+// extensions such as permessage-deflate on either connection.
+type ReverseProxy struct {
+	// Backend is the WebSocket URL of the backend. The path and the query of
+	// the client's request are appended to the URL.
+	Backend string
+
+	// Rewrite, if not nil, modifies the request sent to the backend.
+	// Returning an error rejects the client with status 502 Bad Gateway.
+	Rewrite func(r *ProxyRequest) error
+
+	// ForwardHeaders specifies the request headers copied from the client's
+	// request to the backend request. If ForwardHeaders is nil, then the
+	// Authorization, Cookie and User-Agent headers are forwarded. The client
+	// address is always appended to the X-Forwarded-For header.
+	ForwardHeaders []string
+
+	// Dialer specifies the dialer used to connect to the backend. If Dialer
+	// is nil, then DefaultDialer is used.
+	Dialer *Dialer
+
+	// Upgrader specifies the parameters for upgrading client connections in
+	// ServeHTTP. The Subprotocols, EnableCompression and Extensions fields
+	// are ignored.
+	Upgrader Upgrader
+
+	// FastHTTPUpgrader specifies the parameters for upgrading client
+	// connections in ServeFastHTTP. The Subprotocols, EnableCompression and
+	// Extensions fields are ignored.
+	FastHTTPUpgrader FastHTTPUpgrader
+
+	// Intercept, if not nil, is called for each forwarded frame. The function
+	// may inspect or modify the frame. Return false to drop the frame.
+	// Intercept is called concurrently for the two directions of a
+	// connection.
+	//
+	// Without Intercept, the payload of data frames is streamed from one
+	// connection to the other. With Intercept, each frame is read into
+	// memory before the function is called.
+	Intercept func(dir ProxyDirection, f *Frame) bool
+
+	// MaxFrameSize specifies the maximum payload size in bytes of the data
+	// frames passed to Intercept. A peer sending a larger frame is closed
+	// with CloseMessageTooBig. If MaxFrameSize is zero, then a default of 1
+	// MiB is used. MaxFrameSize does not apply when Intercept is nil.
+	MaxFrameSize int64
+
+	// CloseTimeout specifies the time to wait for the closing handshake to
+	// complete after a close frame is forwarded. If CloseTimeout is zero, then
+	// a default of 5 seconds is used.
+	CloseTimeout time.Duration
+
+	// ErrorLog, if not nil, is called with the errors that end a proxied
+	// connection other than a close frame.
+	ErrorLog func(err error)
+}
+
+var defaultForwardHeaders = []string{"Authorization", "Cookie", "User-Agent"}
+
+// ServeHTTP proxies a WebSocket connection upgraded with Upgrader.
+func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := make(http.Header)
+	for _, name := range p.forwardHeaders() {
+		for _, v := range r.Header.Values(name) {
+			header.Add(name, v)
+		}
+	}
+	backend, err := p.dial(r.Context(), r.URL.Path, r.URL.RawQuery, header, Subprotocols(r), r.RemoteAddr)
+	if err != nil {
+		p.logError(err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	u := p.Upgrader
+	u.Subprotocols = nil
+	u.EnableCompression = false
+	u.Extensions = nil
+	var responseHeader http.Header
+	if sp := backend.Subprotocol(); sp != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {sp}}
+	}
+	client, err := u.Upgrade(w, r, responseHeader)
+	if err != nil {
+		backend.Close()
+		return
+	}
+	p.proxy(client, backend)
+}
+
+// ServeFastHTTP proxies a WebSocket connection upgraded with
+// FastHTTPUpgrader.
+func (p *ReverseProxy) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
+	header := make(http.Header)
+	for _, name := range p.forwardHeaders() {
+		for _, v := range ctx.Request.Header.PeekAll(name) {
+			header.Add(name, string(v))
+		}
+	}
+	var subprotocols []string
+	for _, sp := range parseDataHeader(ctx.Request.Header.Peek("Sec-Websocket-Protocol")) {
+		subprotocols = append(subprotocols, string(sp))
+	}
+	// The request context is not used for dialing because fasthttp reuses it
+	// after the handler returns.
+	backend, err := p.dial(context.Background(), string(ctx.Path()), string(ctx.URI().QueryString()), header, subprotocols, ctx.RemoteAddr().String())
+	if err != nil {
+		p.logError(err)
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusBadGateway), fasthttp.StatusBadGateway)
+		return
+	}
+
+	u := p.FastHTTPUpgrader
+	u.Subprotocols = nil
+	u.EnableCompression = false
+	u.Extensions = nil
+	if sp := backend.Subprotocol(); sp != "" {
+		ctx.Response.Header.Set("Sec-Websocket-Protocol", sp)
+	}
+	err = u.Upgrade(ctx, func(client *Conn) {
+		p.proxy(client, backend)
+	})
+	if err != nil {
+		backend.Close()
+	}
+}
+
+func (p *ReverseProxy) forwardHeaders() []string {
+	if p.ForwardHeaders == nil {
+		return defaultForwardHeaders
+	}
+	return p.ForwardHeaders
+}
+
+func (p *ReverseProxy) logError(err error) {
+	if p.ErrorLog != nil {
+		p.ErrorLog(err)
+	}
+}
+
+// dial connects to the backend for a client request.
+func (p *ReverseProxy) dial(ctx context.Context, path, rawQuery string, header http.Header, subprotocols []string, remoteAddr string) (*Conn, error) {
+	u, err := url.Parse(p.Backend)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = singleJoiningSlash(u.Path, path)
+	u.RawPath = ""
+	switch {
+	case u.RawQuery == "":
+		u.RawQuery = rawQuery
+	case rawQuery != "":
+		u.RawQuery += "&" + rawQuery
+	}
+
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		if prior := header.Values("X-Forwarded-For"); len(prior) > 0 {
+			host = strings.Join(prior, ", ") + ", " + host
+		}
+		header.Set("X-Forwarded-For", host)
+	}
+
+	req := &ProxyRequest{URL: u.String(), Header: header}
+	if p.Rewrite != nil {
+		if err := p.Rewrite(req); err != nil {
+			return nil, err
+		}
+	}
+
+	d := DefaultDialer
+	if p.Dialer != nil {
+		d = p.Dialer
+	}
+	dialer := *d
+	dialer.Subprotocols = subprotocols
+	dialer.EnableCompression = false
+	dialer.Extensions = nil
+	conn, resp, err := dialer.DialContext(ctx, req.URL, req.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("websocket: backend handshake failed: %w", err)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// proxy forwards frames between the client and the backend until both
+// directions end.
+func (p *ReverseProxy) proxy(client, backend *Conn) {
+	defer client.Close()
+	defer backend.Close()
+
+	for _, c := range []*Conn{client, backend} {
+		// Control frames are forwarded to the other side, which answers them.
+		c.SetPingHandler(func(string) error { return nil })
+		c.SetCloseHandler(func(int, string) error { return nil })
+	}
+
+	errc := make(chan error, 2)
+	go p.pipe(ClientToBackend, client, backend, errc)
+	go p.pipe(BackendToClient, backend, client, errc)
+
+	err := <-errc
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		// One side failed without a closing handshake. Tell the other side.
+		p.logError(err)
+		message := FormatCloseMessage(CloseGoingAway, "")
+		deadline := time.Now().Add(writeWait)
+		_ = client.WriteControl(CloseMessage, message, deadline)
+		_ = backend.WriteControl(CloseMessage, message, deadline)
+		client.Close()
+		backend.Close()
+		<-errc
+		return
+	}
+
+	// Wait for the other side to answer the forwarded close frame.
+	timeout := p.CloseTimeout
+	if timeout <= 0 {
+		timeout = defaultProxyCloseTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-errc:
+	case <-timer.C:
+		client.Close()
+		backend.Close()
+		<-errc
+	}
+}
+
+// pipe forwards the frames read from src to dst.
+func (p *ReverseProxy) pipe(dir ProxyDirection, src, dst *Conn, errc chan<- error) {
+	for {
+		var err error
+		if p.Intercept == nil {
+			err = src.forwardFrame(dst)
+		} else {
+			err = p.interceptFrame(dir, src, dst)
+		}
+		if err != nil {
+			errc <- err
+			return
+		}
+	}
+}
+
+// interceptFrame reads a frame from src, passes it to the Intercept function
+// and writes it to dst.
+func (p *ReverseProxy) interceptFrame(dir ProxyDirection, src, dst *Conn) error {
+	f, err := src.nextFrame()
+	if err != nil {
+		return err
+	}
+	if !isControl(f.Opcode) {
+		limit := p.MaxFrameSize
+		if limit <= 0 {
+			limit = defaultProxyMaxFrameSize
+		}
+		if src.readRemaining > limit {
+			_ = src.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(writeWait))
+			return ErrReadLimit
+		}
+		if f.Payload, err = src.readFramePayload(); err != nil {
+			return err
+		}
+	}
+	if !p.Intercept(dir, &f) {
+		return nil
+	}
+	return dst.WriteFrame(f)
+}
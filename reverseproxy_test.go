@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newProxyBackend returns a backend server echoing messages. The backend
+// sends the close code received from the client to closeCode.
+func newProxyBackend(t *testing.T, closeCode chan<- int) *httptest.Server {
+	upgrader := Upgrader{Subprotocols: []string{"p2", "p1"}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/base"+cstPath || r.URL.RawQuery != cstRawQuery {
+			t.Errorf("backend path = %s?%s, want /base%s", r.URL.Path, r.URL.RawQuery, cstRequestURI)
+		}
+		if r.Header.Get("Authorization") != "Bearer t" {
+			t.Errorf("backend Authorization = %q, want Bearer t", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("X-Forwarded-For") == "" {
+			t.Errorf("backend X-Forwarded-For not set")
+		}
+		if r.Header.Get("X-Secret") != "" {
+			t.Errorf("backend X-Secret forwarded")
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Logf("Upgrade: %v", err)
+			return
+		}
+		defer ws.Close()
+		for {
+			op, p, err := ws.ReadMessage()
+			if err != nil {
+				if e, ok := err.(*CloseError); ok {
+					closeCode <- e.Code
+				}
+				return
+			}
+			if err := ws.WriteMessage(op, p); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func testReverseProxy(t *testing.T, url string, closeCode <-chan int) {
+	header := http.Header{"Authorization": {"Bearer t"}, "X-Secret": {"s"}}
+	dialer := cstDialer
+	dialer.Subprotocols = []string{"p1", "p3"}
+	ws, _, err := dialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if ws.Subprotocol() != "p1" {
+		t.Errorf("Subprotocol() = %q, want p1", ws.Subprotocol())
+	}
+
+	pong := make(chan string, 1)
+	ws.SetPongHandler(func(data string) error {
+		pong <- data
+		return nil
+	})
+	if err := ws.WriteControl(PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fragmented message is forwarded frame by frame.
+	for _, f := range []Frame{
+		{Opcode: TextMessage, Payload: []byte("hello, ")},
+		{Opcode: ContinuationFrame, Fin: true, Payload: []byte("world")},
+	} {
+		if err := ws.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, p, err := ws.ReadMessage()
+	if err != nil || string(p) != "HELLO, WORLD" {
+		t.Fatalf("ReadMessage() = %q, %v, want HELLO, WORLD", p, err)
+	}
+	select {
+	case data := <-pong:
+		if data != "ping" {
+			t.Errorf("pong = %q, want ping", data)
+		}
+	default:
+		t.Errorf("pong not received")
+	}
+
+	if err := ws.CloseGracefully(CloseNormalClosure, "", time.Second); err != nil {
+		t.Errorf("CloseGracefully() returned %v", err)
+	}
+	if code := <-closeCode; code != CloseNormalClosure {
+		t.Errorf("backend close code = %d, want %d", code, CloseNormalClosure)
+	}
+}
+
+func newTestReverseProxy(backendURL string) *ReverseProxy {
+	return &ReverseProxy{
+		Backend: makeWsProto(backendURL) + "/base",
+		Intercept: func(dir ProxyDirection, f *Frame) bool {
+			if dir == ClientToBackend && (f.Opcode == TextMessage || f.Opcode == ContinuationFrame) {
+				f.Payload = bytes.ToUpper(f.Payload)
+			}
+			return true
+		},
+	}
+}
+
+func TestReverseProxy(t *testing.T) {
+	closeCode := make(chan int, 1)
+	backend := newProxyBackend(t, closeCode)
+	defer backend.Close()
+
+	s := httptest.NewServer(newTestReverseProxy(backend.URL))
+	defer s.Close()
+	testReverseProxy(t, makeWsProto(s.URL)+cstRequestURI, closeCode)
+}
+
+func TestReverseProxyFastHTTP(t *testing.T) {
+	closeCode := make(chan int, 1)
+	backend := newProxyBackend(t, closeCode)
+	defer backend.Close()
+
+	s := newFastHTTPServer(t, newTestReverseProxy(backend.URL).ServeFastHTTP)
+	defer s.Close()
+	testReverseProxy(t, s.URL, closeCode)
+}
+
+func TestReverseProxyBadBackend(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	defer backend.Close()
+
+	logged := make(chan error, 1)
+	p := &ReverseProxy{
+		Backend:  makeWsProto(backend.URL),
+		ErrorLog: func(err error) { logged <- err },
+	}
+	s := httptest.NewServer(p)
+	defer s.Close()
+
+	_, resp, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Dial() returned %v, want status %d", err, http.StatusBadGateway)
+	}
+	var herr *BadHandshakeError
+	if err := <-logged; !errors.As(err, &herr) || herr.StatusCode != http.StatusNotFound {
+		t.Errorf("logged %v, want *BadHandshakeError with status %d", err, http.StatusNotFound)
+	}
+}
+
+func TestReverseProxyStream(t *testing.T) {
+	closeCode := make(chan int, 1)
+	backend := newProxyBackend(t, closeCode)
+	defer backend.Close()
+	s := httptest.NewServer(&ReverseProxy{Backend: makeWsProto(backend.URL) + "/base"})
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL)+cstRequestURI, http.Header{"Authorization": {"Bearer t"}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	// The frame is larger than the copy buffer of the proxy.
+	message := bytes.Repeat([]byte("0123456789"), 10000)
+	if err := ws.WriteMessage(BinaryMessage, message); err != nil {
+		t.Fatal(err)
+	}
+	if _, p, err := ws.ReadMessage(); err != nil || !bytes.Equal(p, message) {
+		t.Fatalf("ReadMessage() = %d bytes, %v, want %d bytes", len(p), err, len(message))
+	}
+	if err := ws.CloseGracefully(CloseNormalClosure, "", time.Second); err != nil {
+		t.Errorf("CloseGracefully() returned %v", err)
+	}
+	if code := <-closeCode; code != CloseNormalClosure {
+		t.Errorf("backend close code = %d, want %d", code, CloseNormalClosure)
+	}
+}
+
+func TestReverseProxyMaxFrameSize(t *testing.T) {
+	backend := newProxyBackend(t, make(chan int, 1))
+	defer backend.Close()
+	p := newTestReverseProxy(backend.URL)
+	p.MaxFrameSize = 100
+	s := httptest.NewServer(p)
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(makeWsProto(s.URL)+cstRequestURI, http.Header{"Authorization": {"Bearer t"}})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(TextMessage, bytes.Repeat([]byte("x"), 101)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ws.ReadMessage(); !IsCloseError(err, CloseMessageTooBig) {
+		t.Fatalf("ReadMessage() returned %v, want close error %d", err, CloseMessageTooBig)
+	}
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+// SubprotocolRoute configures the connections of a subprotocol served by a
+// SubprotocolRouter.
+type SubprotocolRoute struct {
+	// Handler serves the connections of the subprotocol. The router closes
+	// the connection when Handler returns.
+	Handler func(c *Conn)
+
+	// Codec, if not nil, specifies the codec of WriteValue and ReadValue on
+	// the connections. The codec decodes messages from untrusted clients;
+	// see the security note of GobCodec.
+	Codec Codec
+
+	// ReadLimit, if not zero, specifies the maximum size in bytes of the
+	// messages read from the connections. See Conn.SetReadLimit.
+	ReadLimit int64
+}
+
+// SubprotocolRouter serves each negotiated subprotocol with its own handler,
+// so that one endpoint can serve several protocols or protocol versions side
+// by side. The router serves both net/http and fasthttp requests.
+//
+// By default, the router selects the first subprotocol requested by the
+// client that has a route. The Select and SelectFastHTTP functions override
+// the selection. A route registered for the empty subprotocol serves the
+// clients that request no subprotocol or no routed subprotocol. If no route
+// is selected, the router rejects the handshake with http.StatusBadRequest.
+//
+// The BeforeUpgrade hooks of the upgraders may select another subprotocol.
+// The connection is then served by the route of that subprotocol, and the
+// handshake is rejected with http.StatusInternalServerError if the
+// subprotocol has no route.
+//
+// Routes must be registered before serving requests.
+type SubprotocolRouter struct {
+	// Upgrader upgrades the net/http requests. The Subprotocols field of the
+	// upgrader is ignored.
+	Upgrader Upgrader
+
+	// FastHTTPUpgrader upgrades the fasthttp requests. The Subprotocols
+	// field of the upgrader is ignored.
+	FastHTTPUpgrader FastHTTPUpgrader
+
+	// Select, if not nil, selects the subprotocol of a net/http request from
+	// the subprotocols requested by the client, in the client's order of
+	// preference. Select returns the empty string to select the route of the
+	// empty subprotocol. The selected subprotocol must be requested by the
+	// client and have a route.
+	Select func(r *http.Request, offered []string) string
+
+	// SelectFastHTTP is like Select for fasthttp requests.
+	SelectFastHTTP func(ctx *fasthttp.RequestCtx, offered []string) string
+
+	routes map[string]SubprotocolRoute
+}
+
+// Handle registers the route of a subprotocol. Use the empty subprotocol to
+// register the route of the clients without a routed subprotocol.
+func (rt *SubprotocolRouter) Handle(subprotocol string, route SubprotocolRoute) {
+	if rt.routes == nil {
+		rt.routes = make(map[string]SubprotocolRoute)
+	}
+	rt.routes[subprotocol] = route
+}
+
+// route returns the selected subprotocol if it has a route, or the empty
+// subprotocol when no valid subprotocol is selected. It reports whether the
+// returned subprotocol has a route.
+func (rt *SubprotocolRouter) route(offered []string, selected string, custom bool) (string, bool) {
+	if !custom {
+		selected = ""
+		for _, subprotocol := range offered {
+			if _, ok := rt.routes[subprotocol]; ok {
+				selected = subprotocol
+				break
+			}
+		}
+	}
+	if _, ok := rt.routes[selected]; ok && (selected == "" || containsString(offered, selected)) {
+		return selected, true
+	}
+	_, ok := rt.routes[""]
+	return "", ok
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRoute rejects a handshake when the subprotocol selected by a
+// BeforeUpgrade hook has no route.
+func (rt *SubprotocolRouter) checkRoute(subprotocol string) error {
+	if _, ok := rt.routes[subprotocol]; !ok {
+		return &HandshakeRejection{
+			Status: http.StatusInternalServerError,
+			Body:   []byte("websocket: no route for the subprotocol selected by BeforeUpgrade"),
+		}
+	}
+	return nil
+}
+
+// serveConn serves the connection with the route of the negotiated
+// subprotocol.
+func (rt *SubprotocolRouter) serveConn(c *Conn) {
+	route := rt.routes[c.Subprotocol()]
+	route.serve(c)
+}
+
+// serve runs the handler of a route.
+func (route *SubprotocolRoute) serve(c *Conn) {
+	defer c.Close()
+	if route.Codec != nil {
+		c.SetCodec(route.Codec)
+	}
+	if route.ReadLimit != 0 {
+		c.SetReadLimit(route.ReadLimit)
+	}
+	if route.Handler != nil {
+		route.Handler(c)
+	}
+}
+
+// ServeHTTP upgrades the request and serves the connection with the route of
+// the selected subprotocol.
+func (rt *SubprotocolRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := rt.Upgrader
+	offered := Subprotocols(r)
+	var selected string
+	if rt.Select != nil {
+		selected = rt.Select(r, offered)
+	}
+	subprotocol, ok := rt.route(offered, selected, rt.Select != nil)
+	if !ok {
+		_, _ = u.returnError(w, r, http.StatusBadRequest, "websocket: no supported subprotocol")
+		return
+	}
+	u.Subprotocols = []string{subprotocol}
+	if before := u.BeforeUpgrade; before != nil {
+		u.BeforeUpgrade = func(r *http.Request, resp *UpgradeResponse) error {
+			if err := before(r, resp); err != nil {
+				return err
+			}
+			return rt.checkRoute(resp.Subprotocol)
+		}
+	}
+	c, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	rt.serveConn(c)
+}
+
+// ServeFastHTTP upgrades the request and serves the connection with the
+// route of the selected subprotocol.
+func (rt *SubprotocolRouter) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
+	u := rt.FastHTTPUpgrader
+	var offered []string
+	for _, p := range parseDataHeader(ctx.Request.Header.Peek("Sec-Websocket-Protocol")) {
+		offered = append(offered, string(p))
+	}
+	var selected string
+	if rt.SelectFastHTTP != nil {
+		selected = rt.SelectFastHTTP(ctx, offered)
+	}
+	subprotocol, ok := rt.route(offered, selected, rt.SelectFastHTTP != nil)
+	if !ok {
+		_ = u.responseError(ctx, http.StatusBadRequest, "websocket: no supported subprotocol")
+		return
+	}
+	u.Subprotocols = []string{subprotocol}
+	if before := u.BeforeUpgrade; before != nil {
+		u.BeforeUpgrade = func(ctx *fasthttp.RequestCtx, resp *UpgradeResponse) error {
+			if err := before(ctx, resp); err != nil {
+				return err
+			}
+			return rt.checkRoute(resp.Subprotocol)
+		}
+	}
+	_ = u.Upgrade(ctx, rt.serveConn)
+}
@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func newTestRouter() *SubprotocolRouter {
+	rt := &SubprotocolRouter{}
+	reply := func(text string) func(c *Conn) {
+		return func(c *Conn) {
+			_ = c.WriteMessage(TextMessage, []byte(text+" "+c.Subprotocol()))
+		}
+	}
+	rt.Handle("v1", SubprotocolRoute{Handler: reply("v1")})
+	rt.Handle("v2", SubprotocolRoute{
+		Codec: GobCodec,
+		Handler: func(c *Conn) {
+			_ = c.WriteValue(c.Codec() == GobCodec)
+		},
+	})
+	rt.Handle("", SubprotocolRoute{Handler: reply("default")})
+	return rt
+}
+
+func TestSubprotocolRouter(t *testing.T) {
+	rt := newTestRouter()
+	rt.Select = func(r *http.Request, offered []string) string {
+		if r.URL.Query().Get("legacy") != "" {
+			return "v1"
+		}
+		if len(offered) == 0 {
+			return ""
+		}
+		return offered[0]
+	}
+	s := httptest.NewServer(rt)
+	defer s.Close()
+	fs := newFastHTTPServer(t, rt.ServeFastHTTP)
+	defer fs.Close()
+
+	tests := []struct {
+		url          string
+		subprotocols []string
+		want         string
+	}{
+		{makeWsProto(s.URL), []string{"v1", "v2"}, "v1 v1"},
+		{makeWsProto(s.URL) + "?legacy=1", []string{"v2", "v1"}, "v1 v1"},
+		{makeWsProto(s.URL), []string{"v3"}, "default "},
+		{fs.URL, []string{"v3", "v1"}, "v1 v1"},
+		{fs.URL, nil, "default "},
+	}
+	for _, tt := range tests {
+		dialer := cstDialer
+		dialer.Subprotocols = tt.subprotocols
+		ws, _, err := dialer.Dial(tt.url, nil)
+		if err != nil {
+			t.Fatalf("Dial(%s, %v): %v", tt.url, tt.subprotocols, err)
+		}
+		_, p, err := ws.ReadMessage()
+		if err != nil || string(p) != tt.want {
+			t.Errorf("Dial(%s, %v): ReadMessage() = %q, %v, want %q", tt.url, tt.subprotocols, p, err, tt.want)
+		}
+		ws.Close()
+	}
+
+	dialer := cstDialer
+	dialer.Subprotocols = []string{"v2"}
+	dialer.Codecs = map[string]Codec{"v2": GobCodec}
+	ws, _, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	var gob bool
+	if err := ws.ReadValue(&gob); err != nil || !gob {
+		t.Errorf("ReadValue() = %v, %v, want route codec", gob, err)
+	}
+}
+
+func TestSubprotocolRouterReject(t *testing.T) {
+	rt := &SubprotocolRouter{}
+	rt.Handle("v1", SubprotocolRoute{})
+	s := httptest.NewServer(rt)
+	defer s.Close()
+	fs := newFastHTTPServer(t, rt.ServeFastHTTP)
+	defer fs.Close()
+
+	_, _, err := cstDialer.Dial(makeWsProto(s.URL), nil)
+	var herr *BadHandshakeError
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Dial() returned %v, want status 400", err)
+	}
+	_, _, err = cstDialer.Dial(fs.URL, nil)
+	if !errors.As(err, &herr) || herr.StatusCode != fasthttp.StatusBadRequest {
+		t.Errorf("Dial() returned %v, want status 400", err)
+	}
+}
+
+func TestSubprotocolRouterBeforeUpgrade(t *testing.T) {
+	rt := &SubprotocolRouter{}
+	for _, p := range []string{"a", "b"} {
+		p := p
+		rt.Handle(p, SubprotocolRoute{Handler: func(c *Conn) {
+			_ = c.WriteMessage(TextMessage, []byte(p+" "+c.Subprotocol()))
+		}})
+	}
+	// The hook selects the last subprotocol offered by the client.
+	rt.Upgrader.BeforeUpgrade = func(r *http.Request, resp *UpgradeResponse) error {
+		offered := Subprotocols(r)
+		resp.Subprotocol = offered[len(offered)-1]
+		return nil
+	}
+	rt.FastHTTPUpgrader.BeforeUpgrade = func(ctx *fasthttp.RequestCtx, resp *UpgradeResponse) error {
+		offered := parseDataHeader(ctx.Request.Header.Peek("Sec-Websocket-Protocol"))
+		resp.Subprotocol = string(offered[len(offered)-1])
+		return nil
+	}
+	s := httptest.NewServer(rt)
+	defer s.Close()
+	fs := newFastHTTPServer(t, rt.ServeFastHTTP)
+	defer fs.Close()
+
+	for _, url := range []string{makeWsProto(s.URL), fs.URL} {
+		dialer := cstDialer
+		dialer.Subprotocols = []string{"a", "b"}
+		ws, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial(%s): %v", url, err)
+		}
+		if _, p, err := ws.ReadMessage(); err != nil || string(p) != "b b" {
+			t.Errorf("Dial(%s): ReadMessage() = %q, %v, want %q", url, p, err, "b b")
+		}
+		ws.Close()
+
+		dialer.Subprotocols = []string{"a", "c"}
+		_, _, err = dialer.Dial(url, nil)
+		var herr *BadHandshakeError
+		if !errors.As(err, &herr) || herr.StatusCode != http.StatusInternalServerError {
+			t.Errorf("Dial(%s) with unrouted subprotocol returned %v, want status 500", url, err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Error codes defined by JSON-RPC 2.0.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+// RPCError is a JSON-RPC 2.0 error object. Handlers return an *RPCError to
+// send a specific error code to the caller. Call returns an *RPCError when
+// the peer responds with an error.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("websocket: rpc error %d: %s", e.Code, e.Message)
+}
+
+// RPCRequest is a request or notification received from the peer.
+type RPCRequest struct {
+	// Method is the name of the method.
+	Method string
+
+	// Params holds the JSON encoding of the parameters, or nil if the
+	// parameters are omitted.
+	Params json.RawMessage
+
+	// Notification is true if the peer does not expect a response.
+	Notification bool
+}
+
+// DecodeParams stores the parameters of the request in the value pointed to
+// by v. DecodeParams returns an *RPCError with code RPCInvalidParams if the
+// parameters cannot be decoded.
+func (r *RPCRequest) DecodeParams(v interface{}) error {
+	if err := json.Unmarshal(r.Params, v); err != nil {
+		return &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// RPCHandler handles the requests and notifications received by an RPCConn.
+//
+// The handler is called in a new goroutine for each request. The result is
+// encoded as JSON and sent to the caller, or ignored for notifications. If
+// the handler returns an error that is not an *RPCError, the caller receives
+// an error with code RPCInternalError and the error's message. The context
+// is canceled when the connection is closed.
+type RPCHandler func(ctx context.Context, rc *RPCConn, req *RPCRequest) (result interface{}, err error)
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response.
+type rpcMessage struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+var rpcNullID = json.RawMessage("null")
+
+// RPCConn is a JSON-RPC 2.0 endpoint on a connection. Both peers can send
+// requests and notifications, so that a server can notify its clients.
+//
+// An RPCConn owns the connection once created: a goroutine reads the
+// messages of the connection, and the application must not read or write
+// the connection other than with WriteControl and Close. Batch requests are
+// not supported.
+//
+// It is safe to call RPCConn's methods concurrently.
+type RPCConn struct {
+	c       *Conn
+	handler RPCHandler
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	wmu sync.Mutex // serializes writes to the connection
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[string]chan *rpcMessage
+	err     error
+}
+
+// NewRPCConn starts serving JSON-RPC on c. The handler handles the requests
+// and notifications sent by the peer. If handler is nil, then requests are
+// answered with RPCMethodNotFound and notifications are ignored.
+func NewRPCConn(c *Conn, handler RPCHandler) *RPCConn {
+	rc := &RPCConn{
+		c:       c,
+		handler: handler,
+		pending: make(map[string]chan *rpcMessage),
+	}
+	rc.ctx, rc.cancel = context.WithCancel(context.Background())
+	go rc.run()
+	return rc
+}
+
+// Call sends a request and waits for the response. The JSON encoding of the
+// response's result is stored in the value pointed to by result, unless
+// result is nil. If the peer responds with an error, Call returns an
+// *RPCError.
+//
+// If ctx is done before the response is received, Call returns the
+// context's error and the response is discarded. Calls waiting for a
+// response when the connection fails return the error of the connection.
+func (rc *RPCConn) Call(ctx context.Context, method string, params, result interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rawParams, err := marshalRPCParams(params)
+	if err != nil {
+		return err
+	}
+	ch := make(chan *rpcMessage, 1)
+	rc.mu.Lock()
+	if rc.err != nil {
+		rc.mu.Unlock()
+		return rc.err
+	}
+	rc.nextID++
+	id := json.RawMessage(strconv.FormatUint(rc.nextID, 10))
+	rc.pending[string(id)] = ch
+	rc.mu.Unlock()
+
+	if err := rc.write(&rpcMessage{Version: "2.0", ID: id, Method: method, Params: rawParams}); err != nil {
+		rc.forget(id)
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-ctx.Done():
+		rc.forget(id)
+		return ctx.Err()
+	case <-rc.ctx.Done():
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		return rc.err
+	}
+}
+
+// Notify sends a notification. The peer does not respond to notifications.
+func (rc *RPCConn) Notify(method string, params interface{}) error {
+	rawParams, err := marshalRPCParams(params)
+	if err != nil {
+		return err
+	}
+	return rc.write(&rpcMessage{Version: "2.0", Method: method, Params: rawParams})
+}
+
+// Done returns a channel that is closed when the connection fails or is
+// closed.
+func (rc *RPCConn) Done() <-chan struct{} {
+	return rc.ctx.Done()
+}
+
+// Err returns the error that ended the connection, or nil while the
+// connection is running.
+func (rc *RPCConn) Err() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.err
+}
+
+// Close closes the connection. The pending and future calls return
+// net.ErrClosed.
+func (rc *RPCConn) Close() error {
+	rc.fail(net.ErrClosed)
+	return rc.c.Close()
+}
+
+func marshalRPCParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	return json.Marshal(params)
+}
+
+func (rc *RPCConn) forget(id json.RawMessage) {
+	rc.mu.Lock()
+	delete(rc.pending, string(id))
+	rc.mu.Unlock()
+}
+
+func (rc *RPCConn) write(m *rpcMessage) error {
+	rc.wmu.Lock()
+	defer rc.wmu.Unlock()
+	return rc.c.WriteJSON(m)
+}
+
+// fail ends the pending calls with err.
+func (rc *RPCConn) fail(err error) {
+	rc.mu.Lock()
+	if rc.err == nil {
+		rc.err = err
+		rc.pending = nil
+	}
+	rc.mu.Unlock()
+	rc.cancel()
+}
+
+// run reads the messages of the connection.
+func (rc *RPCConn) run() {
+	for {
+		var m rpcMessage
+		err := rc.c.ReadJSON(&m)
+		if err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			switch {
+			case errors.As(err, &syntaxErr) || err == io.ErrUnexpectedEOF:
+				// A failed read of the connection is returned by the next
+				// ReadJSON.
+				rc.respondError(rpcNullID, RPCParseError, "parse error")
+				continue
+			case errors.As(err, &typeErr):
+				rc.respondError(rpcNullID, RPCInvalidRequest, "invalid request")
+				continue
+			}
+			rc.fail(err)
+			return
+		}
+		rc.dispatch(&m)
+	}
+}
+
+func (rc *RPCConn) dispatch(m *rpcMessage) {
+	if m.Version != "2.0" {
+		rc.respondError(rpcIDOrNull(m.ID), RPCInvalidRequest, "invalid request")
+		return
+	}
+	if m.Method == "" {
+		// The message is a response.
+		rc.mu.Lock()
+		ch := rc.pending[string(m.ID)]
+		delete(rc.pending, string(m.ID))
+		rc.mu.Unlock()
+		if ch != nil {
+			ch <- m
+		}
+		return
+	}
+	req := &RPCRequest{Method: m.Method, Params: m.Params, Notification: m.ID == nil}
+	go rc.serve(m.ID, req)
+}
+
+// serve runs the handler for a request and sends the response.
+func (rc *RPCConn) serve(id json.RawMessage, req *RPCRequest) {
+	if rc.handler == nil {
+		if !req.Notification {
+			rc.respondError(id, RPCMethodNotFound, "method not found: "+req.Method)
+		}
+		return
+	}
+	result, err := rc.handler(rc.ctx, rc, req)
+	if req.Notification {
+		return
+	}
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: RPCInternalError, Message: err.Error()}
+		}
+		_ = rc.write(&rpcMessage{Version: "2.0", ID: id, Error: rpcErr})
+		return
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		rc.respondError(id, RPCInternalError, err.Error())
+		return
+	}
+	_ = rc.write(&rpcMessage{Version: "2.0", ID: id, Result: raw})
+}
+
+func (rc *RPCConn) respondError(id json.RawMessage, code int, message string) {
+	_ = rc.write(&rpcMessage{Version: "2.0", ID: id, Error: &RPCError{Code: code, Message: message}})
+}
+
+func rpcIDOrNull(id json.RawMessage) json.RawMessage {
+	if id == nil {
+		return rpcNullID
+	}
+	return id
+}
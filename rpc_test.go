@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRPCCall(t *testing.T) {
+	s, c := newPipeConns()
+	server := NewRPCConn(s, func(ctx context.Context, rc *RPCConn, req *RPCRequest) (interface{}, error) {
+		switch req.Method {
+		case "add":
+			var args [2]int
+			if err := req.DecodeParams(&args); err != nil {
+				return nil, err
+			}
+			return args[0] + args[1], nil
+		case "fail":
+			return nil, errors.New("failed")
+		case "wait":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, &RPCError{Code: RPCMethodNotFound, Message: "method not found"}
+	})
+	defer server.Close()
+	client := NewRPCConn(c, nil)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sum int
+			if err := client.Call(context.Background(), "add", []int{i, 1}, &sum); err != nil || sum != i+1 {
+				t.Errorf("Call(add, %d, 1) = %d, %v, want %d", i, sum, err, i+1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	tests := []struct {
+		method string
+		params interface{}
+		code   int
+	}{
+		{"add", "x", RPCInvalidParams},
+		{"fail", nil, RPCInternalError},
+		{"missing", nil, RPCMethodNotFound},
+	}
+	for _, tt := range tests {
+		err := client.Call(context.Background(), tt.method, tt.params, nil)
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != tt.code {
+			t.Errorf("Call(%s) returned %v, want code %d", tt.method, err, tt.code)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.Call(ctx, "wait", nil, nil); err != context.DeadlineExceeded {
+		t.Errorf("Call(wait) returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	var sum int
+	if err := client.Call(context.Background(), "add", []int{1, 2}, &sum); err != nil || sum != 3 {
+		t.Errorf("Call(add) after timeout = %d, %v, want 3", sum, err)
+	}
+}
+
+func TestRPCNotify(t *testing.T) {
+	s, c := newPipeConns()
+	notes := make(chan string, 1)
+	client := NewRPCConn(c, func(ctx context.Context, rc *RPCConn, req *RPCRequest) (interface{}, error) {
+		var text string
+		if err := req.DecodeParams(&text); err != nil {
+			return nil, err
+		}
+		notes <- fmt.Sprint(req.Method, " ", text, " ", req.Notification)
+		return nil, nil
+	})
+	defer client.Close()
+	server := NewRPCConn(s, func(ctx context.Context, rc *RPCConn, req *RPCRequest) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	if err := server.Notify("news", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-notes, "news hello true"; got != want {
+		t.Errorf("notification = %q, want %q", got, want)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call(context.Background(), "wait", nil, nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	server.Close()
+	if err := <-done; err == nil {
+		t.Error("Call() returned nil error after the peer closed the connection")
+	}
+	<-client.Done()
+	if err := client.Err(); err == nil {
+		t.Error("Err() returned nil after the peer closed the connection")
+	}
+	if err := server.Call(context.Background(), "x", nil, nil); err != net.ErrClosed {
+		t.Errorf("Call() after Close returned %v, want %v", err, net.ErrClosed)
+	}
+}
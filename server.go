@@ -6,6 +6,7 @@ package websocket
 
 import (
 	"bufio"
+	"errors"
 	"net"
 	"net/http"
 	"net/url"
@@ -18,10 +19,14 @@ const badHandshake = "websocket: the client is not using the websocket protocol:
 // HandshakeError describes an error with the handshake from the peer.
 type HandshakeError struct {
 	message string
+	status  int
 }
 
 func (e HandshakeError) Error() string { return e.message }
 
+// StatusCode returns the HTTP status of the response rejecting the handshake.
+func (e HandshakeError) StatusCode() int { return e.status }
+
 // Upgrader specifies parameters for upgrading an HTTP connection to a
 // WebSocket connection.
 //
@@ -68,15 +73,62 @@ type Upgrader struct {
 	// prevent cross-site request forgery.
 	CheckOrigin func(r *http.Request) bool
 
+	// BeforeUpgrade, if not nil, is called after the handshake request is
+	// validated and before the connection is upgraded. The hook may add
+	// response headers and change the selected subprotocol through resp.
+	// Return a *HandshakeRejection to reject the handshake with a custom
+	// response. Other errors reject the handshake with status 403 Forbidden.
+	BeforeUpgrade func(r *http.Request, resp *UpgradeResponse) error
+
 	// EnableCompression specify if the server should attempt to negotiate per
 	// message compression (RFC 7692). Setting this value to true does not
-	// guarantee that compression will be supported. Currently only "no context
-	// takeover" modes are supported.
+	// guarantee that compression will be supported.
 	EnableCompression bool
+
+	// EnableContextTakeover specifies if the server should allow the client
+	// and the server to retain the compression sliding window across
+	// messages. Context takeover improves the compression of small,
+	// repetitive messages at the cost of memory held for the lifetime of the
+	// connection. This field is ignored if EnableCompression is false.
+	EnableContextTakeover bool
+
+	// Extensions specifies the extensions supported by the server in
+	// addition to permessage-deflate. The Upgrade method accepts the
+	// extensions offered by the client in the order of the client's
+	// preference.
+	Extensions []Extension
+
+	// KeepAlive configures the keepalive of upgraded connections. The
+	// keepalive is disabled if KeepAlive.Interval is zero.
+	KeepAlive KeepAlive
+
+	// Interceptors specifies the interceptors of upgraded connections. See
+	// the Interceptor type for details.
+	Interceptors []Interceptor
+
+	// InterceptControl specifies whether the interceptors are called for
+	// control messages. If InterceptControl is false, then the interceptors
+	// are called for text and binary messages only.
+	InterceptControl bool
+
+	// Codecs maps subprotocols to the codecs of WriteValue and ReadValue on
+	// upgraded connections. Connections use JSONCodec when the negotiated
+	// subprotocol is not in Codecs.
+	Codecs map[string]Codec
+
+	// Observer, if not nil, receives the events of upgraded connections and
+	// the handshake errors of the upgrader.
+	Observer Observer
+
+	// Registry, if not nil, tracks the connections created by the upgrader.
+	// Upgrade fails with ErrShuttingDown after the registry's Shutdown method
+	// is called.
+	Registry *ConnRegistry
 }
 
 func (u *Upgrader) returnError(w http.ResponseWriter, r *http.Request, status int, reason string) (*Conn, error) {
-	err := HandshakeError{reason}
+	err := HandshakeError{message: reason, status: status}
+	u.handshakeFailed(r, err)
 	if u.Error != nil {
 		u.Error(w, r, status, err)
 	} else {
@@ -86,6 +138,16 @@ func (u *Upgrader) returnError(w http.ResponseWriter, r *http.Request, status in
 	return nil, err
 }
 
+// handshakeFailed reports a rejected handshake to the observer and the trace.
+func (u *Upgrader) handshakeFailed(r *http.Request, err HandshakeError) {
+	if u.Observer != nil {
+		u.Observer.ObserveHandshakeError(err.status, err)
+	}
+	if trace := ContextServerTrace(r.Context()); trace != nil && trace.UpgradeError != nil {
+		trace.UpgradeError(err.status, err)
+	}
+}
+
 // checkSameOrigin returns true if the origin is not set or is equal to the request host.
 func checkSameOrigin(r *http.Request) bool {
 	origin := r.Header["Origin"]
@@ -123,18 +185,46 @@ func (u *Upgrader) selectSubprotocol(r *http.Request, responseHeader http.Header
 //
 // If the upgrade fails, then Upgrade replies to the client with an HTTP error
 // response.
+//
+// Upgrade accepts HTTP/2 extended CONNECT requests (RFC 8441) when the HTTP/2
+// server enables the extended CONNECT protocol. The HTTP/2 server of
+// golang.org/x/net/http2 v0.31.0 or later, and the server of net/http from Go
+// 1.24, enable the protocol when the GODEBUG environment variable contains
+// http2xconnect=1. Earlier versions reject the requests.
+//
+// An HTTP/2 connection runs on the request stream, which ends when the
+// handler returns. The connection is then closed, and writes from other
+// goroutines, such as the keepalive or the write queue, fail with
+// net.ErrClosed. A write in progress while the handler returns can still use
+// the stream after it ended, so the handler should close the connection or
+// wait for its writers before returning.
 func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
-	if !tokenListContainsValue(r.Header, "Connection", "upgrade") {
-		return u.returnError(w, r, http.StatusBadRequest, badHandshake+"'upgrade' token not found in 'Connection' header")
+	trace := ContextServerTrace(r.Context())
+	if trace != nil && trace.UpgradeReceived != nil {
+		trace.UpgradeReceived()
 	}
 
-	if !tokenListContainsValue(r.Header, "Upgrade", "websocket") {
-		w.Header().Set("Upgrade", "websocket")
-		return u.returnError(w, r, http.StatusUpgradeRequired, badHandshake+"'websocket' token not found in 'Upgrade' header")
-	}
+	isHTTP2 := r.ProtoMajor == 2
+	if isHTTP2 {
+		if r.Method != http.MethodConnect {
+			return u.returnError(w, r, http.StatusMethodNotAllowed, badHandshake+"request method is not CONNECT")
+		}
+		if r.Header.Get(":protocol") != "websocket" {
+			return u.returnError(w, r, http.StatusBadRequest, badHandshake+"':protocol' pseudo-header is not websocket")
+		}
+	} else {
+		if !tokenListContainsValue(r.Header, "Connection", "upgrade") {
+			return u.returnError(w, r, http.StatusBadRequest, badHandshake+"'upgrade' token not found in 'Connection' header")
+		}
 
-	if r.Method != http.MethodGet {
-		return u.returnError(w, r, http.StatusMethodNotAllowed, badHandshake+"request method is not GET")
+		if !tokenListContainsValue(r.Header, "Upgrade", "websocket") {
+			w.Header().Set("Upgrade", "websocket")
+			return u.returnError(w, r, http.StatusUpgradeRequired, badHandshake+"'websocket' token not found in 'Upgrade' header")
+		}
+
+		if r.Method != http.MethodGet {
+			return u.returnError(w, r, http.StatusMethodNotAllowed, badHandshake+"request method is not GET")
+		}
 	}
 
 	if !tokenListContainsValue(r.Header, "Sec-Websocket-Version", "13") {
@@ -142,37 +232,80 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 	}
 
 	if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
-		return u.returnError(w, r, http.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
+		return u.returnError(w, r, http.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported, use the Extensions field")
 	}
 
 	checkOrigin := u.CheckOrigin
 	if checkOrigin == nil {
 		checkOrigin = checkSameOrigin
 	}
-	if !checkOrigin(r) {
+	allowed := checkOrigin(r)
+	if trace != nil && trace.OriginChecked != nil {
+		trace.OriginChecked(allowed)
+	}
+	if !allowed {
 		return u.returnError(w, r, http.StatusForbidden, "websocket: request origin not allowed by Upgrader.CheckOrigin")
 	}
 
+	if u.Registry != nil && u.Registry.isShuttingDown() {
+		return u.returnError(w, r, http.StatusServiceUnavailable, ErrShuttingDown.Error())
+	}
+
 	challengeKey := r.Header.Get("Sec-Websocket-Key")
-	if !isValidChallengeKey(challengeKey) {
+	if !isHTTP2 && !isValidChallengeKey(challengeKey) {
 		return u.returnError(w, r, http.StatusBadRequest, "websocket: not a websocket handshake: 'Sec-WebSocket-Key' header must be Base64 encoded value of 16-byte in length")
 	}
 
 	subprotocol := u.selectSubprotocol(r, responseHeader)
 
-	// Negotiate PMCE
-	var compress bool
-	if u.EnableCompression {
-		for _, ext := range parseExtensions(r.Header) {
-			if ext[""] != "permessage-deflate" {
-				continue
+	if u.BeforeUpgrade != nil {
+		resp := UpgradeResponse{Subprotocol: subprotocol}
+		if err := u.BeforeUpgrade(r, &resp); err != nil {
+			var rejection *HandshakeRejection
+			if errors.As(err, &rejection) {
+				return u.reject(w, r, rejection)
 			}
-			compress = true
-			break
+			return u.returnError(w, r, http.StatusForbidden, err.Error())
+		}
+		if _, ok := resp.Header["Sec-Websocket-Extensions"]; ok {
+			return u.returnError(w, r, http.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported, use the Extensions field")
+		}
+		if !containsSubprotocol(Subprotocols(r), resp.Subprotocol) {
+			return u.returnError(w, r, http.StatusInternalServerError, "websocket: subprotocol selected by BeforeUpgrade not requested by the client")
+		}
+		subprotocol = resp.Subprotocol
+		if len(resp.Header) > 0 {
+			h := make(http.Header, len(responseHeader)+len(resp.Header))
+			for k, vs := range responseHeader {
+				h[k] = vs
+			}
+			for k, vs := range resp.Header {
+				h[k] = append(h[k][:len(h[k]):len(h[k])], vs...)
+			}
+			responseHeader = h
+		}
+	}
+
+	// Negotiate extensions
+	extensionsHeader, extensions := acceptExtensions(parseExtensions(r.Header),
+		withCompression(u.EnableCompression, u.EnableContextTakeover, u.Extensions))
+	if trace != nil {
+		if trace.SubprotocolSelected != nil {
+			trace.SubprotocolSelected(subprotocol)
+		}
+		if trace.ExtensionsNegotiated != nil {
+			trace.ExtensionsNegotiated(extensionsHeader)
 		}
 	}
 
+	if isHTTP2 {
+		return u.acceptHTTP2(w, r, responseHeader, subprotocol, extensionsHeader, extensions, trace)
+	}
+
 	netConn, brw, err := HijackResponse(r, w)
+	if trace != nil && trace.HijackDone != nil {
+		trace.HijackDone(err)
+	}
 	if err != nil {
 		return u.returnError(w, r, http.StatusInternalServerError,
 			"websocket: hijack: "+err.Error())
@@ -213,9 +346,11 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 	c := newConn(netConn, true, u.ReadBufferSize, u.WriteBufferSize, u.WriteBufferPool, br, writeBuf)
 	c.subprotocol = subprotocol
 
-	if compress {
-		c.newCompressionWriter = compressNoContextTakeover
-		c.newDecompressionReader = decompressNoContextTakeover
+	c.setExtensions(extensions)
+	c.setInterceptors(u.Interceptors, u.InterceptControl)
+	c.codecs = u.Codecs
+	if trace != nil {
+		c.setTrace(trace.ConnTrace)
 	}
 
 	// Use larger of hijacked buffer and connection write buffer for header.
@@ -233,8 +368,10 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 		p = append(p, c.subprotocol...)
 		p = append(p, "\r\n"...)
 	}
-	if compress {
-		p = append(p, "Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n"...)
+	if extensionsHeader != "" {
+		p = append(p, "Sec-WebSocket-Extensions: "...)
+		p = append(p, extensionsHeader...)
+		p = append(p, "\r\n"...)
 	}
 	for k, vs := range responseHeader {
 		if k == "Sec-Websocket-Protocol" {
@@ -276,10 +413,17 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeade
 		}
 	}
 
+	if u.Registry != nil && !u.Registry.add(c) {
+		return nil, ErrShuttingDown
+	}
+
 	// Success! Set netConn to nil to stop the deferred function above from
 	// closing the network connection.
 	netConn = nil
 
+	c.startObserving(u.Observer)
+	c.StartKeepAlive(u.KeepAlive)
+
 	return c, nil
 }
 
@@ -5,7 +5,7 @@
 package websocket
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
@@ -16,8 +16,6 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
-var strPermessageDeflate = []byte("permessage-deflate")
-
 var poolWriteBuffer = sync.Pool{
 	New: func() interface{} {
 		return new(writePoolData)
@@ -72,15 +70,62 @@ type FastHTTPUpgrader struct {
 	// prevent cross-site request forgery.
 	CheckOrigin func(ctx *fasthttp.RequestCtx) bool
 
+	// BeforeUpgrade, if not nil, is called after the handshake request is
+	// validated and before the connection is upgraded. The hook may add
+	// response headers and change the selected subprotocol through resp.
+	// Return a *HandshakeRejection to reject the handshake with a custom
+	// response. Other errors reject the handshake with status 403 Forbidden.
+	BeforeUpgrade func(ctx *fasthttp.RequestCtx, resp *UpgradeResponse) error
+
 	// EnableCompression specify if the server should attempt to negotiate per
 	// message compression (RFC 7692). Setting this value to true does not
-	// guarantee that compression will be supported. Currently only "no context
-	// takeover" modes are supported.
+	// guarantee that compression will be supported.
 	EnableCompression bool
+
+	// EnableContextTakeover specifies if the server should allow the client
+	// and the server to retain the compression sliding window across
+	// messages. Context takeover improves the compression of small,
+	// repetitive messages at the cost of memory held for the lifetime of the
+	// connection. This field is ignored if EnableCompression is false.
+	EnableContextTakeover bool
+
+	// Extensions specifies the extensions supported by the server in
+	// addition to permessage-deflate. The Upgrade method accepts the
+	// extensions offered by the client in the order of the client's
+	// preference.
+	Extensions []Extension
+
+	// KeepAlive configures the keepalive of upgraded connections. The
+	// keepalive is disabled if KeepAlive.Interval is zero.
+	KeepAlive KeepAlive
+
+	// Interceptors specifies the interceptors of upgraded connections. See
+	// the Interceptor type for details.
+	Interceptors []Interceptor
+
+	// InterceptControl specifies whether the interceptors are called for
+	// control messages. If InterceptControl is false, then the interceptors
+	// are called for text and binary messages only.
+	InterceptControl bool
+
+	// Codecs maps subprotocols to the codecs of WriteValue and ReadValue on
+	// upgraded connections. Connections use JSONCodec when the negotiated
+	// subprotocol is not in Codecs.
+	Codecs map[string]Codec
+
+	// Observer, if not nil, receives the events of upgraded connections and
+	// the handshake errors of the upgrader.
+	Observer Observer
+
+	// Registry, if not nil, tracks the connections created by the upgrader.
+	// Upgrade fails with ErrShuttingDown after the registry's Shutdown method
+	// is called.
+	Registry *ConnRegistry
 }
 
 func (u *FastHTTPUpgrader) responseError(ctx *fasthttp.RequestCtx, status int, reason string) error {
-	err := HandshakeError{reason}
+	err := HandshakeError{message: reason, status: status}
+	u.handshakeFailed(ctx, err)
 	if u.Error != nil {
 		u.Error(ctx, status, err)
 	} else {
@@ -91,6 +136,16 @@ func (u *FastHTTPUpgrader) responseError(ctx *fasthttp.RequestCtx, status int, r
 	return err
 }
 
+// handshakeFailed reports a rejected handshake to the observer and the trace.
+func (u *FastHTTPUpgrader) handshakeFailed(ctx *fasthttp.RequestCtx, err HandshakeError) {
+	if u.Observer != nil {
+		u.Observer.ObserveHandshakeError(err.status, err)
+	}
+	if trace := ContextServerTrace(ctx); trace != nil && trace.UpgradeError != nil {
+		trace.UpgradeError(err.status, err)
+	}
+}
+
 func (u *FastHTTPUpgrader) selectSubprotocol(ctx *fasthttp.RequestCtx) []byte {
 	if u.Subprotocols != nil {
 		clientProtocols := parseDataHeader(ctx.Request.Header.Peek("Sec-Websocket-Protocol"))
@@ -109,21 +164,6 @@ func (u *FastHTTPUpgrader) selectSubprotocol(ctx *fasthttp.RequestCtx) []byte {
 	return nil
 }
 
-func (u *FastHTTPUpgrader) isCompressionEnable(ctx *fasthttp.RequestCtx) bool {
-	extensions := parseDataHeader(ctx.Request.Header.Peek("Sec-WebSocket-Extensions"))
-
-	// Negotiate PMCE
-	if u.EnableCompression {
-		for _, ext := range extensions {
-			if bytes.HasPrefix(ext, strPermessageDeflate) {
-				return true
-			}
-		}
-	}
-
-	return false
-}
-
 // Upgrade upgrades the HTTP server connection to the WebSocket protocol.
 //
 // The responseHeader is included in the response to the client's upgrade
@@ -132,7 +172,28 @@ func (u *FastHTTPUpgrader) isCompressionEnable(ctx *fasthttp.RequestCtx) bool {
 //
 // If the upgrade fails, then Upgrade replies to the client with an HTTP error
 // response.
+//
+// The connection is valid until the handler returns. When the handler
+// returns, the keepalive and the write queue of the connection are stopped
+// and fasthttp closes the network connection.
 func (u *FastHTTPUpgrader) Upgrade(ctx *fasthttp.RequestCtx, handler FastHTTPHandler) error {
+	return u.upgrade(ctx, handler, false)
+}
+
+// UpgradeWithRequest upgrades the HTTP server connection to the WebSocket
+// protocol like Upgrade. The handler also receives a snapshot of the upgrade
+// request. The snapshot is available from the connection's
+// FastHTTPUpgradeRequest method for the lifetime of the connection.
+func (u *FastHTTPUpgrader) UpgradeWithRequest(ctx *fasthttp.RequestCtx, handler FastHTTPRequestHandler) error {
+	return u.upgrade(ctx, func(c *Conn) { handler(c, c.upgradeRequest) }, true)
+}
+
+func (u *FastHTTPUpgrader) upgrade(ctx *fasthttp.RequestCtx, handler FastHTTPHandler, keepRequest bool) error {
+	trace := ContextServerTrace(ctx)
+	if trace != nil && trace.UpgradeReceived != nil {
+		trace.UpgradeReceived()
+	}
+
 	if !ctx.IsGet() {
 		return u.responseError(ctx, fasthttp.StatusMethodNotAllowed, fmt.Sprintf("%s request method is not GET", badHandshake))
 	}
@@ -150,37 +211,94 @@ func (u *FastHTTPUpgrader) Upgrade(ctx *fasthttp.RequestCtx, handler FastHTTPHan
 	}
 
 	if len(ctx.Response.Header.Peek("Sec-Websocket-Extensions")) > 0 {
-		return u.responseError(ctx, fasthttp.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported")
+		return u.responseError(ctx, fasthttp.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported, use the Extensions field")
 	}
 
 	checkOrigin := u.CheckOrigin
 	if checkOrigin == nil {
 		checkOrigin = fastHTTPcheckSameOrigin
 	}
-	if !checkOrigin(ctx) {
+	allowed := checkOrigin(ctx)
+	if trace != nil && trace.OriginChecked != nil {
+		trace.OriginChecked(allowed)
+	}
+	if !allowed {
 		return u.responseError(ctx, fasthttp.StatusForbidden, "websocket: request origin not allowed by FastHTTPUpgrader.CheckOrigin")
 	}
 
+	if u.Registry != nil && u.Registry.isShuttingDown() {
+		return u.responseError(ctx, fasthttp.StatusServiceUnavailable, ErrShuttingDown.Error())
+	}
+
 	challengeKey := ctx.Request.Header.Peek("Sec-Websocket-Key")
 	if len(challengeKey) == 0 {
 		return u.responseError(ctx, fasthttp.StatusBadRequest, "websocket: not a websocket handshake: `Sec-WebSocket-Key' header is missing or blank")
 	}
 
 	subprotocol := u.selectSubprotocol(ctx)
-	compress := u.isCompressionEnable(ctx)
+
+	if u.BeforeUpgrade != nil {
+		resp := UpgradeResponse{Subprotocol: string(subprotocol)}
+		if err := u.BeforeUpgrade(ctx, &resp); err != nil {
+			var rejection *HandshakeRejection
+			if errors.As(err, &rejection) {
+				return u.reject(ctx, rejection)
+			}
+			return u.responseError(ctx, fasthttp.StatusForbidden, err.Error())
+		}
+		if _, ok := resp.Header["Sec-Websocket-Extensions"]; ok {
+			return u.responseError(ctx, fasthttp.StatusInternalServerError, "websocket: application specific 'Sec-WebSocket-Extensions' headers are unsupported, use the Extensions field")
+		}
+		var requested []string
+		for _, p := range parseDataHeader(ctx.Request.Header.Peek("Sec-Websocket-Protocol")) {
+			requested = append(requested, string(p))
+		}
+		if !containsSubprotocol(requested, resp.Subprotocol) {
+			return u.responseError(ctx, fasthttp.StatusInternalServerError, "websocket: subprotocol selected by BeforeUpgrade not requested by the client")
+		}
+		subprotocol = nil
+		if resp.Subprotocol != "" {
+			subprotocol = []byte(resp.Subprotocol)
+		} else {
+			ctx.Response.Header.Del("Sec-WebSocket-Protocol")
+		}
+		for k, vs := range resp.Header {
+			for _, v := range vs {
+				ctx.Response.Header.Add(k, v)
+			}
+		}
+	}
+	extensionsHeader, extensions := acceptExtensions(parseExtensionValues(ctx.Request.Header.PeekAll("Sec-WebSocket-Extensions")),
+		withCompression(u.EnableCompression, u.EnableContextTakeover, u.Extensions))
+	if trace != nil {
+		if trace.SubprotocolSelected != nil {
+			trace.SubprotocolSelected(string(subprotocol))
+		}
+		if trace.ExtensionsNegotiated != nil {
+			trace.ExtensionsNegotiated(extensionsHeader)
+		}
+	}
 
 	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
 	ctx.Response.Header.Set("Upgrade", "websocket")
 	ctx.Response.Header.Set("Connection", "Upgrade")
 	ctx.Response.Header.Set("Sec-WebSocket-Accept", computeAcceptKeyBytes(challengeKey))
-	if compress {
-		ctx.Response.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	if extensionsHeader != "" {
+		ctx.Response.Header.Set("Sec-WebSocket-Extensions", extensionsHeader)
 	}
 	if subprotocol != nil {
 		ctx.Response.Header.SetBytesV("Sec-WebSocket-Protocol", subprotocol)
 	}
 
+	var upgradeRequest *FastHTTPUpgradeRequest
+	if keepRequest {
+		upgradeRequest = newFastHTTPUpgradeRequest(ctx)
+	}
+
 	ctx.Hijack(func(netConn net.Conn) {
+		if trace != nil && trace.HijackDone != nil {
+			trace.HijackDone(nil)
+		}
 		// var br *bufio.Reader  // Always nil
 		writeBuf := poolWriteBuffer.Get().(*writePoolData)
 
@@ -189,15 +307,29 @@ func (u *FastHTTPUpgrader) Upgrade(ctx *fasthttp.RequestCtx, handler FastHTTPHan
 			c.subprotocol = strconv.B2S(subprotocol)
 		}
 
-		if compress {
-			c.newCompressionWriter = compressNoContextTakeover
-			c.newDecompressionReader = decompressNoContextTakeover
+		c.setExtensions(extensions)
+		c.setInterceptors(u.Interceptors, u.InterceptControl)
+		c.codecs = u.Codecs
+		if trace != nil {
+			c.setTrace(trace.ConnTrace)
 		}
+		c.upgradeRequest = upgradeRequest
 
 		// Clear deadlines set by HTTP server.
 		_ = netConn.SetDeadline(time.Time{})
 
-		handler(c)
+		switch {
+		case u.Registry == nil || u.Registry.add(c):
+			c.startObserving(u.Observer)
+			c.StartKeepAlive(u.KeepAlive)
+			handler(c)
+			// fasthttp closes the network connection when the handler
+			// returns, whether or not the handler closed the connection.
+			c.release()
+		default:
+			// The registry started shutting down during the handshake.
+			_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Now().Add(writeWait))
+		}
 
 		writeBuf.buf = writeBuf.buf[0:0]
 		poolWriteBuffer.Put(writeBuf)
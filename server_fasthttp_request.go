@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/valyala/fasthttp"
+)
+
+// FastHTTPRequestHandler receives a websocket connection and a snapshot of the
+// upgrade request after the handshake has been completed.
+type FastHTTPRequestHandler func(*Conn, *FastHTTPUpgradeRequest)
+
+// FastHTTPUpgradeRequest is an immutable snapshot of the fasthttp request that
+// was upgraded to a WebSocket connection. The fasthttp.RequestCtx is recycled
+// by the server when the handshake completes. The snapshot holds copies of
+// the request data and remains valid for the lifetime of the connection.
+//
+// It is safe to call FastHTTPUpgradeRequest's methods concurrently.
+type FastHTTPUpgradeRequest struct {
+	requestURI string
+	path       string
+	host       string
+	isTLS      bool
+	query      url.Values
+	header     http.Header
+	cookies    map[string]string
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	userValues map[any]any
+}
+
+// newFastHTTPUpgradeRequest copies the request data of ctx. It must be called
+// before the connection is hijacked.
+func newFastHTTPUpgradeRequest(ctx *fasthttp.RequestCtx) *FastHTTPUpgradeRequest {
+	r := &FastHTTPUpgradeRequest{
+		requestURI: string(ctx.RequestURI()),
+		path:       string(ctx.Path()),
+		host:       string(ctx.Host()),
+		isTLS:      ctx.IsTLS(),
+		query:      make(url.Values),
+		header:     make(http.Header),
+		cookies:    make(map[string]string),
+		remoteAddr: ctx.RemoteAddr(),
+		localAddr:  ctx.LocalAddr(),
+		userValues: make(map[any]any),
+	}
+	ctx.QueryArgs().VisitAll(func(key, value []byte) {
+		r.query.Add(string(key), string(value))
+	})
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		r.header.Add(string(key), string(value))
+	})
+	ctx.Request.Header.VisitAllCookie(func(key, value []byte) {
+		r.cookies[string(key)] = string(value)
+	})
+	ctx.VisitUserValuesAll(func(key, value any) {
+		r.userValues[key] = value
+	})
+	return r
+}
+
+// RequestURI returns the request URI, including the query string.
+func (r *FastHTTPUpgradeRequest) RequestURI() string {
+	return r.requestURI
+}
+
+// Path returns the path of the request URI.
+func (r *FastHTTPUpgradeRequest) Path() string {
+	return r.path
+}
+
+// Host returns the requested host.
+func (r *FastHTTPUpgradeRequest) Host() string {
+	return r.host
+}
+
+// IsTLS returns true if the request was received over TLS.
+func (r *FastHTTPUpgradeRequest) IsTLS() bool {
+	return r.isTLS
+}
+
+// QueryArg returns the first value of the query argument with the given key.
+func (r *FastHTTPUpgradeRequest) QueryArg(key string) string {
+	return r.query.Get(key)
+}
+
+// QueryArgs returns a copy of the query arguments.
+func (r *FastHTTPUpgradeRequest) QueryArgs() url.Values {
+	q := make(url.Values, len(r.query))
+	for k, v := range r.query {
+		q[k] = append([]string(nil), v...)
+	}
+	return q
+}
+
+// Header returns the first value of the request header with the given key.
+// The key is case insensitive.
+func (r *FastHTTPUpgradeRequest) Header(key string) string {
+	return r.header.Get(key)
+}
+
+// Headers returns a copy of the request headers.
+func (r *FastHTTPUpgradeRequest) Headers() http.Header {
+	return r.header.Clone()
+}
+
+// Cookie returns the value of the request cookie with the given name.
+func (r *FastHTTPUpgradeRequest) Cookie(name string) string {
+	return r.cookies[name]
+}
+
+// RemoteAddr returns the client network address.
+func (r *FastHTTPUpgradeRequest) RemoteAddr() net.Addr {
+	return r.remoteAddr
+}
+
+// LocalAddr returns the server network address that accepted the request.
+func (r *FastHTTPUpgradeRequest) LocalAddr() net.Addr {
+	return r.localAddr
+}
+
+// UserValue returns the user value set on the fasthttp.RequestCtx with the
+// given key before the upgrade, typically by middleware. Values that
+// implement io.Closer may be closed by fasthttp when the handshake completes.
+func (r *FastHTTPUpgradeRequest) UserValue(key any) any {
+	return r.userValues[key]
+}
+
+// VisitUserValues calls f for each user value.
+func (r *FastHTTPUpgradeRequest) VisitUserValues(f func(key, value any)) {
+	for k, v := range r.userValues {
+		f(k, v)
+	}
+}
+
+// FastHTTPUpgradeRequest returns the snapshot of the upgrade request for
+// connections upgraded with FastHTTPUpgrader.UpgradeWithRequest, or nil.
+func (c *Conn) FastHTTPUpgradeRequest() *FastHTTPUpgradeRequest {
+	if c == nil {
+		return nil
+	}
+	return c.upgradeRequest
+}
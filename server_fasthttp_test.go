@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type fastHTTPServer struct {
+	URL    string
+	Server *fasthttp.Server
+	ln     net.Listener
+}
+
+// newFastHTTPServer starts a fasthttp server with the given handler on a
+// local address.
+func newFastHTTPServer(t *testing.T, handler fasthttp.RequestHandler) *fastHTTPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s := &fastHTTPServer{
+		URL:    "ws://" + ln.Addr().String() + cstRequestURI,
+		Server: &fasthttp.Server{Handler: handler},
+		ln:     ln,
+	}
+	go func() { _ = s.Server.Serve(ln) }()
+	return s
+}
+
+func (s *fastHTTPServer) Close() {
+	_ = s.Server.Shutdown()
+}
+
+// fastHTTPEchoHandler echoes messages until the connection fails.
+func fastHTTPEchoHandler(ws *Conn) {
+	defer ws.Close()
+	for {
+		op, p, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := ws.WriteMessage(op, p); err != nil {
+			return
+		}
+	}
+}
+
+func TestFastHTTPUpgrade(t *testing.T) {
+	upgrader := FastHTTPUpgrader{
+		Subprotocols:      []string{"p1"},
+		EnableCompression: true,
+	}
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		if err := upgrader.Upgrade(ctx, fastHTTPEchoHandler); err != nil {
+			t.Logf("Upgrade: %v", err)
+		}
+	})
+	defer s.Close()
+
+	dialer := cstDialer
+	dialer.EnableCompression = true
+	ws, resp, err := dialer.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	if ws.Subprotocol() != "p1" {
+		t.Errorf("Subprotocol() = %s, want p1", ws.Subprotocol())
+	}
+	if resp.Header.Get("Sec-Websocket-Extensions") == "" {
+		t.Errorf("compression not negotiated")
+	}
+	sendRecv(t, ws)
+}
+
+func TestFastHTTPUpgradeWithRequest(t *testing.T) {
+	type userKey struct{}
+	var upgrader FastHTTPUpgrader
+	done := make(chan *FastHTTPUpgradeRequest, 1)
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue("user", "alice")
+		ctx.SetUserValue(userKey{}, 42)
+		err := upgrader.UpgradeWithRequest(ctx, func(ws *Conn, r *FastHTTPUpgradeRequest) {
+			defer ws.Close()
+			if ws.FastHTTPUpgradeRequest() != r {
+				t.Errorf("FastHTTPUpgradeRequest() = %p, want %p", ws.FastHTTPUpgradeRequest(), r)
+			}
+			done <- r
+		})
+		if err != nil {
+			t.Logf("Upgrade: %v", err)
+		}
+	})
+	defer s.Close()
+
+	header := http.Header{"Cookie": {"session=abc; theme=dark"}, "X-Token": {"t1"}}
+	ws, _, err := cstDialer.Dial(s.URL, header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	r := <-done
+	if r.RequestURI() != cstRequestURI {
+		t.Errorf("RequestURI() = %q, want %q", r.RequestURI(), cstRequestURI)
+	}
+	if r.Path() != cstPath {
+		t.Errorf("Path() = %q, want %q", r.Path(), cstPath)
+	}
+	if r.QueryArg("x") != "y" {
+		t.Errorf("QueryArg(x) = %q, want y", r.QueryArg("x"))
+	}
+	if r.Header("x-token") != "t1" {
+		t.Errorf("Header(x-token) = %q, want t1", r.Header("x-token"))
+	}
+	if r.Cookie("session") != "abc" || r.Cookie("theme") != "dark" {
+		t.Errorf("Cookie() = %q, %q, want abc, dark", r.Cookie("session"), r.Cookie("theme"))
+	}
+	if r.RemoteAddr().String() != ws.LocalAddr().String() {
+		t.Errorf("RemoteAddr() = %v, want %v", r.RemoteAddr(), ws.LocalAddr())
+	}
+	if r.UserValue("user") != "alice" || r.UserValue(userKey{}) != 42 {
+		t.Errorf("UserValue() = %v, %v, want alice, 42", r.UserValue("user"), r.UserValue(userKey{}))
+	}
+
+	r.Headers().Set("X-Token", "changed")
+	r.QueryArgs().Set("x", "changed")
+	if r.Header("X-Token") != "t1" || r.QueryArg("x") != "y" {
+		t.Errorf("snapshot modified through copies")
+	}
+}
+
+func TestFastHTTPHandlerReturnReleasesConn(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	upgrader := FastHTTPUpgrader{KeepAlive: KeepAlive{Interval: time.Hour}}
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		_ = upgrader.Upgrade(ctx, func(c *Conn) {
+			// The handler returns without closing the connection.
+			c.EnableWriteQueue(1, OverflowBlock)
+			conns <- c
+		})
+	})
+	defer s.Close()
+
+	ws, _, err := cstDialer.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	c := <-conns
+
+	select {
+	case <-c.keepAlive.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepalive not stopped")
+	}
+	q := c.writeQueue
+	q.mu.Lock()
+	err = q.err
+	q.mu.Unlock()
+	if err != net.ErrClosed {
+		t.Errorf("write queue error = %v, want %v", err, net.ErrClosed)
+	}
+}
@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ServerTrace is a set of hooks to run at various stages of a server
+// handshake and of the connection it creates. Any particular hook may be
+// nil. Functions may be called concurrently from different goroutines.
+//
+// Attach a ServerTrace to the request context with WithServerTrace for
+// Upgrader, or with SetFastHTTPServerTrace for FastHTTPUpgrader.
+type ServerTrace struct {
+	// UpgradeReceived is called when the upgrader starts processing the
+	// handshake request.
+	UpgradeReceived func()
+
+	// OriginChecked is called with the result of the origin check.
+	OriginChecked func(allowed bool)
+
+	// SubprotocolSelected is called with the subprotocol selected by the
+	// upgrader, or the empty string if no subprotocol is selected.
+	SubprotocolSelected func(subprotocol string)
+
+	// ExtensionsNegotiated is called with the value of the
+	// Sec-WebSocket-Extensions response header, or the empty string if no
+	// extension is accepted.
+	ExtensionsNegotiated func(header string)
+
+	// HijackDone is called when the upgrader took over the network
+	// connection. HijackDone is not called for HTTP/2 requests.
+	HijackDone func(err error)
+
+	// UpgradeError is called when the upgrader rejects the handshake with
+	// an HTTP error response.
+	UpgradeError func(status int, err error)
+
+	// ConnTrace holds the hooks of the upgraded connection.
+	ConnTrace
+}
+
+// ClientTrace is a set of hooks to run at various stages of a client
+// handshake and of the connection it creates. It complements the
+// httptrace.ClientTrace hooks run by Dialer. Any particular hook may be nil.
+// Functions may be called concurrently from different goroutines.
+//
+// Attach a ClientTrace to the context passed to Dialer.DialContext or
+// FastHTTPDialer.DialContext with WithClientTrace.
+type ClientTrace struct {
+	// HandshakeResponse is called when the handshake response headers are
+	// received.
+	HandshakeResponse func(status int, header http.Header)
+
+	// HandshakeValidated is called with the result of the validation of the
+	// handshake response. The error is the Cause of the *BadHandshakeError
+	// returned by the dialer when the validation fails.
+	HandshakeValidated func(err error)
+
+	// SubprotocolSelected is called with the subprotocol selected by the
+	// server, or the empty string if no subprotocol is selected.
+	SubprotocolSelected func(subprotocol string)
+
+	// ExtensionsNegotiated is called with the value of the
+	// Sec-WebSocket-Extensions response header and the result of configuring
+	// the extensions.
+	ExtensionsNegotiated func(header string, err error)
+
+	// ConnTrace holds the hooks of the dialed connection.
+	ConnTrace
+}
+
+// ConnTrace is a set of hooks to run on events of a connection.
+type ConnTrace struct {
+	// FirstFrame is called when the first frame is read from the connection.
+	FirstFrame func(frameType int)
+
+	// CloseSent is called when a close message is written to the
+	// connection.
+	CloseSent func(code int, text string)
+
+	// CloseReceived is called when a close message is read from the
+	// connection.
+	CloseReceived func(code int, text string)
+}
+
+type serverTraceKey struct{}
+
+type clientTraceKey struct{}
+
+// WithServerTrace returns a new context based on the provided parent ctx.
+// Upgrader calls the hooks of trace for requests with the returned context.
+func WithServerTrace(ctx context.Context, trace *ServerTrace) context.Context {
+	return context.WithValue(ctx, serverTraceKey{}, trace)
+}
+
+// SetFastHTTPServerTrace attaches trace to the request. FastHTTPUpgrader
+// calls the hooks of trace for the request.
+func SetFastHTTPServerTrace(ctx *fasthttp.RequestCtx, trace *ServerTrace) {
+	ctx.SetUserValue(serverTraceKey{}, trace)
+}
+
+// ContextServerTrace returns the ServerTrace associated with the provided
+// context. If none, it returns nil.
+func ContextServerTrace(ctx context.Context) *ServerTrace {
+	trace, _ := ctx.Value(serverTraceKey{}).(*ServerTrace)
+	return trace
+}
+
+// WithClientTrace returns a new context based on the provided parent ctx.
+// Dialers call the hooks of trace for handshakes with the returned context.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace associated with the provided
+// context. If none, it returns nil.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// connTrace is the state of the ConnTrace hooks of a connection.
+type connTrace struct {
+	ConnTrace
+	gotFirstFrame bool
+}
+
+// setTrace sets the trace hooks of a connection after the handshake.
+func (c *Conn) setTrace(trace ConnTrace) {
+	if trace.FirstFrame != nil || trace.CloseSent != nil || trace.CloseReceived != nil {
+		c.trace = &connTrace{ConnTrace: trace}
+	}
+}
+
+func (c *Conn) traceFrame(frameType int) {
+	if c.trace != nil && !c.trace.gotFirstFrame {
+		c.trace.gotFirstFrame = true
+		if c.trace.FirstFrame != nil {
+			c.trace.FirstFrame(frameType)
+		}
+	}
+}
+
+// closeSent reports a close frame written to the connection.
+func (c *Conn) closeSent(payload []byte) {
+	if c.observer == nil && c.trace == nil {
+		return
+	}
+	code, text := CloseNoStatusReceived, ""
+	if len(payload) >= 2 {
+		code, text = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+	}
+	if c.observer != nil {
+		c.observer.ObserveClose(c, OutboundMessage, code)
+	}
+	if c.trace != nil && c.trace.CloseSent != nil {
+		c.trace.CloseSent(code, text)
+	}
+}
+
+// closeReceived reports a close frame read from the connection.
+func (c *Conn) closeReceived(code int, text string) {
+	if c.observer != nil {
+		c.observer.ObserveClose(c, InboundMessage, code)
+	}
+	if c.trace != nil && c.trace.CloseReceived != nil {
+		c.trace.CloseReceived(code, text)
+	}
+}
@@ -0,0 +1,200 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// traceLog records trace events from concurrent goroutines.
+type traceLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *traceLog) add(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf(format, args...))
+}
+
+func (l *traceLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+func (l *traceLog) connTrace() ConnTrace {
+	return ConnTrace{
+		FirstFrame:    func(frameType int) { l.add("first frame %d", frameType) },
+		CloseSent:     func(code int, text string) { l.add("close sent %d %s", code, text) },
+		CloseReceived: func(code int, text string) { l.add("close received %d %s", code, text) },
+	}
+}
+
+func (l *traceLog) serverTrace() *ServerTrace {
+	return &ServerTrace{
+		UpgradeReceived:      func() { l.add("upgrade received") },
+		OriginChecked:        func(allowed bool) { l.add("origin checked %v", allowed) },
+		SubprotocolSelected:  func(subprotocol string) { l.add("subprotocol %s", subprotocol) },
+		ExtensionsNegotiated: func(header string) { l.add("extensions %s", header) },
+		HijackDone:           func(err error) { l.add("hijack done %v", err) },
+		UpgradeError:         func(status int, err error) { l.add("upgrade error %d", status) },
+		ConnTrace:            l.connTrace(),
+	}
+}
+
+func TestServerTrace(t *testing.T) {
+	var log traceLog
+	done := make(chan struct{})
+	upgrader := Upgrader{Subprotocols: []string{"p1"}, EnableCompression: true}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(WithServerTrace(r.Context(), log.serverTrace()))
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer close(done)
+		defer ws.Close()
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	dialer := cstDialer
+	dialer.EnableCompression = true
+	ws, _, err := dialer.Dial(makeWsProto(s.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if err := ws.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.CloseGracefully(CloseNormalClosure, "bye", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	want := []string{
+		"upgrade received",
+		"origin checked true",
+		"subprotocol p1",
+		"extensions permessage-deflate; client_no_context_takeover; server_no_context_takeover",
+		"hijack done <nil>",
+		"first frame 1",
+		"close received 1000 bye",
+		"close sent 1000 ",
+	}
+	if got := log.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestServerTraceFastHTTPError(t *testing.T) {
+	var log traceLog
+	upgrader := FastHTTPUpgrader{}
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		SetFastHTTPServerTrace(ctx, log.serverTrace())
+		_ = upgrader.Upgrade(ctx, fastHTTPEchoHandler)
+	})
+	defer s.Close()
+
+	if _, _, err := cstDialer.Dial(s.URL, http.Header{"Origin": {"http://other.example.com"}}); !errors.Is(err, ErrBadHandshake) {
+		t.Fatalf("Dial() returned %v, want %v", err, ErrBadHandshake)
+	}
+	want := []string{"upgrade received", "origin checked false", "upgrade error 403"}
+	if got := log.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestClientTrace(t *testing.T) {
+	upgrader := FastHTTPUpgrader{Subprotocols: []string{"p2"}}
+	s := newFastHTTPServer(t, func(ctx *fasthttp.RequestCtx) {
+		_ = upgrader.Upgrade(ctx, func(ws *Conn) {
+			defer ws.Close()
+			_ = ws.WriteMessage(BinaryMessage, []byte{1})
+			_ = ws.WriteControl(CloseMessage, FormatCloseMessage(CloseGoingAway, ""), time.Now().Add(time.Second))
+			_, _, _ = ws.ReadMessage()
+		})
+	})
+	defer s.Close()
+
+	var log traceLog
+	trace := &ClientTrace{
+		HandshakeResponse:    func(status int, header http.Header) { log.add("response %d %s", status, header.Get("Upgrade")) },
+		HandshakeValidated:   func(err error) { log.add("validated %v", err) },
+		SubprotocolSelected:  func(subprotocol string) { log.add("subprotocol %s", subprotocol) },
+		ExtensionsNegotiated: func(header string, err error) { log.add("extensions %q %v", header, err) },
+		ConnTrace:            log.connTrace(),
+	}
+	ctx := WithClientTrace(context.Background(), trace)
+
+	for _, dial := range []func() (*Conn, error){
+		func() (*Conn, error) {
+			ws, _, err := cstDialer.DialContext(ctx, s.URL, nil)
+			return ws, err
+		},
+		func() (*Conn, error) {
+			ws, resp, err := cstFastHTTPDialer.DialContext(ctx, s.URL, nil)
+			fasthttp.ReleaseResponse(resp)
+			return ws, err
+		},
+	} {
+		log.events = nil
+		ws, err := dial()
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				break
+			}
+		}
+		ws.Close()
+		want := []string{
+			"response 101 websocket",
+			"validated <nil>",
+			`extensions "" <nil>`,
+			"subprotocol p2",
+			"first frame 2",
+			"close received 1001 ",
+			"close sent 1001 ",
+		}
+		if got := log.get(); !reflect.DeepEqual(got, want) {
+			t.Errorf("events = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestClientTraceBadHandshake(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "upgrade")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	defer s.Close()
+
+	var validated error
+	ctx := WithClientTrace(context.Background(), &ClientTrace{
+		HandshakeValidated: func(err error) { validated = err },
+	})
+	if _, _, err := cstDialer.DialContext(ctx, makeWsProto(s.URL), nil); !errors.Is(err, ErrBadHandshake) {
+		t.Fatalf("Dial() returned %v, want %v", err, ErrBadHandshake)
+	}
+	if validated == nil || !strings.Contains(validated.Error(), "Sec-WebSocket-Accept") {
+		t.Errorf("HandshakeValidated() called with %v, want Sec-WebSocket-Accept error", validated)
+	}
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"errors"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+var errInvalidUTF8 = errors.New("websocket: invalid UTF-8 in text message")
+
+// utf8Validator validates UTF-8 text split across an arbitrary number of
+// buffers.
+type utf8Validator struct {
+	need   int  // number of continuation bytes expected
+	lo, hi byte // range of the next continuation byte
+}
+
+// write returns false if p does not continue a valid UTF-8 sequence.
+func (v *utf8Validator) write(p []byte) bool {
+	for i := 0; i < len(p); i++ {
+		b := p[i]
+		if v.need > 0 {
+			if b < v.lo || b > v.hi {
+				return false
+			}
+			v.lo, v.hi = 0x80, 0xbf
+			v.need--
+			continue
+		}
+		if b < utf8.RuneSelf {
+			continue
+		}
+		v.lo, v.hi = 0x80, 0xbf
+		switch {
+		case b >= 0xc2 && b <= 0xdf:
+			v.need = 1
+		case b == 0xe0:
+			v.need, v.lo = 2, 0xa0 // no overlong encodings
+		case b == 0xed:
+			v.need, v.hi = 2, 0x9f // no surrogates
+		case b >= 0xe1 && b <= 0xef:
+			v.need = 2
+		case b == 0xf0:
+			v.need, v.lo = 3, 0x90 // no overlong encodings
+		case b >= 0xf1 && b <= 0xf3:
+			v.need = 3
+		case b == 0xf4:
+			v.need, v.hi = 3, 0x8f // no code points above U+10FFFF
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// complete returns true if the text written so far does not end with a
+// truncated sequence.
+func (v *utf8Validator) complete() bool {
+	return v.need == 0
+}
+
+// utf8Reader fails the connection when a text message is not valid UTF-8.
+type utf8Reader struct {
+	c *Conn
+	r io.ReadCloser
+	v utf8Validator
+}
+
+func (r *utf8Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if !r.v.write(p[:n]) || (err == io.EOF && !r.v.complete()) {
+		return 0, r.c.handleInvalidUTF8()
+	}
+	return n, err
+}
+
+func (r *utf8Reader) Close() error {
+	return r.r.Close()
+}
+
+// handleInvalidUTF8 sends a close message with CloseInvalidFramePayloadData
+// and fails the read side of the connection.
+func (c *Conn) handleInvalidUTF8() error {
+	c.observeProtocolError(errInvalidUTF8)
+	_ = c.WriteControl(CloseMessage, FormatCloseMessage(CloseInvalidFramePayloadData, ""), time.Now().Add(writeWait))
+	c.readErr = errInvalidUTF8
+	return errInvalidUTF8
+}
+
+// EnableUTF8Validation enables and disables the validation of received text
+// messages. Validation is enabled by default. A connection receiving a text
+// message that is not valid UTF-8 sends a close message with
+// CloseInvalidFramePayloadData and fails. Applications may disable
+// validation for trusted peers.
+//
+// The validation is incremental: invalid data is reported by the read
+// methods as soon as it is received, without buffering the message.
+func (c *Conn) EnableUTF8Validation(enable bool) {
+	c.skipUTF8Validation = !enable
+}
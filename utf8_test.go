@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestUTF8Validator(t *testing.T) {
+	tests := []string{
+		"", "hello", "héllo", "€", "𝄞", "߿", "￿", "\U0010ffff",
+		"\xc0\x80", "\xc1\xbf", "\xe0\x80\x80", "\xed\xa0\x80", "\xf0\x80\x80\x80",
+		"\xf4\x90\x80\x80", "\xf5\x80\x80\x80", "\xff", "\x80", "a\xe2\x82", "\xe2\x82\xac\xe2",
+	}
+	// Add all two byte sequences.
+	for i := 0; i < 256; i++ {
+		for j := 0; j < 256; j++ {
+			tests = append(tests, string([]byte{byte(i), byte(j)}))
+		}
+	}
+	for _, tt := range tests {
+		want := utf8.ValidString(tt)
+		// Split the text at every position.
+		for i := 0; i <= len(tt); i++ {
+			var v utf8Validator
+			got := v.write([]byte(tt[:i])) && v.write([]byte(tt[i:])) && v.complete()
+			if got != want {
+				t.Fatalf("validate(%q split at %d) = %v, want %v", tt, i, got, want)
+			}
+		}
+	}
+}
+
+func TestReadUTF8Validation(t *testing.T) {
+	valid := strings.Repeat("€𝄞", 20)
+	for _, isServer := range []bool{true, false} {
+		for _, enable := range []bool{true, false} {
+			var connBuf, closeBuf bytes.Buffer
+			// A small write buffer splits runes across frames.
+			wc := newConn(fakeNetConn{Writer: &connBuf}, isServer, 1024, 16, nil, nil, nil)
+			rc := newTestConn(&connBuf, &closeBuf, !isServer)
+			rc.EnableUTF8Validation(enable)
+
+			for _, message := range []string{valid, valid[:len(valid)-1]} {
+				w, err := wc.NextWriter(TextMessage)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write([]byte(message)); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, p, err := rc.ReadMessage()
+			if err != nil || string(p) != valid {
+				t.Fatalf("ReadMessage() = %q, %v, want %q", p, err, valid)
+			}
+
+			_, p, err = rc.ReadMessage()
+			if !enable {
+				if err != nil || string(p) != valid[:len(valid)-1] {
+					t.Fatalf("ReadMessage() = %q, %v, want truncated text", p, err)
+				}
+				continue
+			}
+			if err != errInvalidUTF8 {
+				t.Fatalf("ReadMessage() returned %v, want %v", err, errInvalidUTF8)
+			}
+			if _, _, err := rc.NextReader(); err != errInvalidUTF8 {
+				t.Fatalf("NextReader() after failure returned %v, want %v", err, errInvalidUTF8)
+			}
+			_, _, err = newTestConn(&closeBuf, io.Discard, isServer).ReadMessage()
+			if !IsCloseError(err, CloseInvalidFramePayloadData) {
+				t.Fatalf("peer ReadMessage() returned %v, want close %d", err, CloseInvalidFramePayloadData)
+			}
+		}
+	}
+}
@@ -243,6 +243,12 @@ func tokenListContainsValue(header http.Header, name string, value string) bool
 
 // parseExtensions parses WebSocket extensions from a header.
 func parseExtensions(header http.Header) []map[string]string {
+	return parseExtensionList(header["Sec-Websocket-Extensions"])
+}
+
+// parseExtensionList parses WebSocket extensions from the values of a
+// Sec-WebSocket-Extensions header.
+func parseExtensionList(values []string) []map[string]string {
 	// From RFC 6455:
 	//
 	//  Sec-WebSocket-Extensions = extension-list
@@ -257,7 +263,7 @@ func parseExtensions(header http.Header) []map[string]string {
 
 	var result []map[string]string
 headers:
-	for _, s := range header["Sec-Websocket-Extensions"] {
+	for _, s := range values {
 		for {
 			var t string
 			t, s = nextToken(skipSpace(s))
@@ -299,6 +305,19 @@ headers:
 	return result
 }
 
+// parseExtensionValues parses WebSocket extensions from the values of a
+// Sec-WebSocket-Extensions header in a fasthttp header.
+func parseExtensionValues(values [][]byte) []map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	header := make([]string, len(values))
+	for i, v := range values {
+		header[i] = string(v)
+	}
+	return parseExtensionList(header)
+}
+
 // isValidChallengeKey checks if the argument meets RFC6455 specification.
 func isValidChallengeKey(s string) bool {
 	// From RFC6455:
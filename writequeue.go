@@ -0,0 +1,209 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy specifies what a connection does when a message is written
+// to a full write queue.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the writer until the queue has room for the
+	// message.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest discards the oldest queued message to make room for
+	// the message.
+	OverflowDropOldest
+
+	// OverflowDropNewest discards the message. The write method returns
+	// ErrWriteQueueFull.
+	OverflowDropNewest
+
+	// OverflowClose sends a close message with ClosePolicyViolation and closes
+	// the connection. The write method returns ErrWriteQueueFull.
+	OverflowClose
+)
+
+// ErrWriteQueueFull is returned when a message is discarded or the connection
+// is closed because the write queue is full.
+var ErrWriteQueueFull = errors.New("websocket: write queue full")
+
+// queuedMessage is a message waiting in the write queue. Exactly one of data
+// and pm is used.
+type queuedMessage struct {
+	messageType int
+	data        []byte
+	pm          *PreparedMessage
+}
+
+// writeQueue serialises the messages written to a connection from many
+// goroutines.
+type writeQueue struct {
+	c      *Conn
+	size   int
+	policy OverflowPolicy
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	items   []queuedMessage
+	writing bool // the goroutine is writing a message
+	err     error
+}
+
+// EnableWriteQueue makes the WriteMessage, WriteJSON and WritePreparedMessage
+// methods safe to call concurrently. The methods append the message to a queue
+// holding at most size messages and return. A goroutine owned by the
+// connection writes the queued messages in order. The policy specifies what
+// happens when the queue is full. A size less than one is treated as one.
+//
+// An error writing a queued message is returned by the next call to a write
+// method. The write methods return net.ErrClosed after the connection is
+// closed.
+//
+// EnableWriteQueue must be called at most once per connection, before the
+// application starts writing. The application must not call NextWriter or
+// the deadline and compression setters of the write side once the queue is
+// enabled. The Close and WriteControl methods remain safe to call concurrently
+// with all other methods.
+func (c *Conn) EnableWriteQueue(size int, policy OverflowPolicy) {
+	if c.writeQueue != nil {
+		return
+	}
+	if size < 1 {
+		size = 1
+	}
+	q := &writeQueue{c: c, size: size, policy: policy}
+	q.cond.L = &q.mu
+	c.writeQueue = q
+	go q.run()
+}
+
+// WriteQueueLen returns the number of messages waiting in the write queue.
+func (c *Conn) WriteQueueLen() int {
+	if c == nil || c.writeQueue == nil {
+		return 0
+	}
+	q := c.writeQueue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *writeQueue) push(ctx context.Context, m queuedMessage) error {
+	if ctx.Done() != nil {
+		// Wake the waiting writers when the context is done.
+		stop := context.AfterFunc(ctx, func() {
+			q.mu.Lock()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		})
+		defer stop()
+	}
+
+	q.mu.Lock()
+	for q.err == nil && len(q.items) >= q.size {
+		switch q.policy {
+		case OverflowDropOldest:
+			q.items[0] = queuedMessage{}
+			q.items = q.items[1:]
+		case OverflowDropNewest:
+			q.mu.Unlock()
+			return ErrWriteQueueFull
+		case OverflowClose:
+			q.mu.Unlock()
+			q.fail(ErrWriteQueueFull)
+			_ = q.c.WriteControl(CloseMessage, FormatCloseMessage(ClosePolicyViolation, "write queue full"), time.Now().Add(writeWait))
+			_ = q.c.Close()
+			return ErrWriteQueueFull
+		default:
+			if err := ctx.Err(); err != nil {
+				q.mu.Unlock()
+				return err
+			}
+			q.cond.Wait()
+		}
+	}
+	if q.err != nil {
+		err := q.err
+		q.mu.Unlock()
+		return err
+	}
+	q.items = append(q.items, m)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	return nil
+}
+
+func (q *writeQueue) run() {
+	for {
+		q.mu.Lock()
+		for q.err == nil && len(q.items) == 0 {
+			q.cond.Wait()
+		}
+		if q.err != nil {
+			q.mu.Unlock()
+			return
+		}
+		m := q.items[0]
+		q.items[0] = queuedMessage{}
+		q.items = q.items[1:]
+		q.writing = true
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		var err error
+		if m.pm != nil {
+			err = q.c.writePreparedMessage(m.pm)
+		} else {
+			err = q.c.writeMessage(m.messageType, m.data)
+		}
+
+		q.mu.Lock()
+		q.writing = false
+		q.cond.Broadcast()
+		q.mu.Unlock()
+		if err != nil {
+			q.fail(err)
+			return
+		}
+	}
+}
+
+// flush waits until the queued messages are written or ctx is done.
+func (q *writeQueue) flush(ctx context.Context) error {
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			q.mu.Lock()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		})
+		defer stop()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.err == nil && (len(q.items) > 0 || q.writing) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		q.cond.Wait()
+	}
+	return q.err
+}
+
+// fail discards the queued messages and records the error returned by
+// subsequent writes.
+func (q *writeQueue) fail(err error) {
+	q.mu.Lock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.items = nil
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
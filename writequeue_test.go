@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteQueueConcurrent(t *testing.T) {
+	p1, p2 := net.Pipe()
+	wc := newConn(p1, true, 1024, 1024, nil, nil, nil)
+	rc := newConn(p2, false, 1024, 1024, nil, nil, nil)
+	defer wc.Close()
+	defer rc.Close()
+	wc.EnableWriteQueue(4, OverflowBlock)
+
+	pm, err := NewPreparedMessage(TextMessage, []byte("prepared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, messages = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				var err error
+				switch j % 3 {
+				case 0:
+					err = wc.WriteMessage(BinaryMessage, []byte(strconv.Itoa(i)))
+				case 1:
+					err = wc.WriteJSON(i)
+				case 2:
+					err = wc.WritePreparedMessage(pm)
+				}
+				if err != nil {
+					t.Errorf("write returned %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	for n := 0; n < writers*messages; n++ {
+		if _, _, err := rc.ReadMessage(); err != nil {
+			t.Fatalf("ReadMessage() returned %v after %d messages", err, n)
+		}
+	}
+	wg.Wait()
+}
+
+func TestWriteQueueOverflow(t *testing.T) {
+	const size = 3
+	tests := []struct {
+		policy OverflowPolicy
+		err    error
+		want   []string // messages read after the overflow
+	}{
+		{OverflowBlock, nil, []string{"0", "1", "2", "3", "x"}},
+		{OverflowDropOldest, nil, []string{"0", "2", "3", "x"}},
+		{OverflowDropNewest, ErrWriteQueueFull, []string{"0", "1", "2", "3"}},
+		{OverflowClose, ErrWriteQueueFull, []string{"0"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.policy), func(t *testing.T) {
+			pr, pw := io.Pipe()
+			wc := newTestConn(nil, pw, true)
+			rc := newTestConn(pr, io.Discard, false)
+			wc.EnableWriteQueue(size, tt.policy)
+
+			// The first message blocks the queue's goroutine in the pipe.
+			if err := wc.WriteMessage(TextMessage, []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+			for wc.WriteQueueLen() != 0 {
+				time.Sleep(time.Millisecond)
+			}
+			for i := 1; i <= size; i++ {
+				if err := wc.WriteMessage(TextMessage, []byte(strconv.Itoa(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- wc.WriteMessage(TextMessage, []byte("x")) }()
+			switch tt.policy {
+			case OverflowDropOldest, OverflowDropNewest:
+				if err := <-done; err != tt.err {
+					t.Fatalf("WriteMessage() returned %v, want %v", err, tt.err)
+				}
+			case OverflowClose:
+				// Wait for the queued messages to be discarded.
+				for wc.WriteQueueLen() != 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+
+			for _, want := range tt.want {
+				_, p, err := rc.ReadMessage()
+				if err != nil {
+					t.Fatalf("ReadMessage() returned %v", err)
+				}
+				if string(p) != want {
+					t.Fatalf("ReadMessage() = %q, want %q", p, want)
+				}
+			}
+
+			if tt.policy == OverflowClose {
+				_, _, err := rc.ReadMessage()
+				if !IsCloseError(err, ClosePolicyViolation) {
+					t.Fatalf("ReadMessage() returned %v, want close %d", err, ClosePolicyViolation)
+				}
+			}
+			if tt.policy == OverflowBlock || tt.policy == OverflowClose {
+				if err := <-done; err != tt.err {
+					t.Fatalf("WriteMessage() returned %v, want %v", err, tt.err)
+				}
+			}
+			if tt.policy == OverflowClose {
+				if err := wc.WriteMessage(TextMessage, []byte("y")); !errors.Is(err, ErrWriteQueueFull) {
+					t.Fatalf("WriteMessage() after close returned %v", err)
+				}
+			}
+		})
+	}
+}